@@ -1,30 +1,90 @@
 package main
 
 import (
+	"context"
 	_ "farmApp/docs" // Required for Swagger documentation
 	"farmApp/pkg/handler"
 	"farmApp/pkg/persistence"
+	"flag"
+	"fmt"
 	"github.com/gorilla/mux"
-	_ "github.com/mattn/go-sqlite3"
 	httpSwagger "github.com/swaggo/http-swagger"
 	"log"
 	"net/http"
-	"sync"
+	"os"
+	"time"
 )
 
-var once sync.Once
-
 // @title Farm Customer API
 // @version 1.0
 // @description This is a simple API for managing farm customers.
 // @host localhost:8080
 // @BasePath /
 func main() {
-	once.Do(func() {
-		persistence.CreateFarmDB()
+	dsn := flag.String("db", envOr("FARMAPP_DB", "./farmCustomers.db"),
+		"SQLite database file, postgres:// connection URL or \"memory\" (env FARMAPP_DB)")
+	resetDB := flag.Bool("reset-db", false, "delete all data before starting")
+	seed := flag.String("seed", string(persistence.SeedNone), "initial data for an empty database: demo or none")
+	trashRetention := flag.Duration("trash-retention", 30*24*time.Hour, "how long deleted customers are kept in the trash")
+	idempotencyTTL := flag.Duration("idempotency-ttl", 24*time.Hour, "how long responses are kept for retries with the same Idempotency-Key")
+	flag.Parse()
+
+	seedMode, err := persistence.ParseSeedMode(*seed)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	migrateCommand := flag.Arg(0) == "migrate"
+	store, err := persistence.Open(*dsn, persistence.Options{
+		Reset:          *resetDB,
+		Seed:           seedMode,
+		SkipMigrations: migrateCommand,
 	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	if migrateCommand {
+		sqlStore, ok := store.(*persistence.SQLStore)
+		if !ok {
+			log.Fatal("migrate needs a SQLite or PostgreSQL database")
+		}
+		if err := runMigrate(sqlStore, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	go purgeTrash(store, *trashRetention, time.Hour)
+
+	log.Fatal(http.ListenAndServe(":8080", newRouter(store, *trashRetention, *idempotencyTTL)))
+}
+
+// newRouter wires the HTTP routes to handlers backed by the given store.
+func newRouter(store persistence.Store, trashRetention, idempotencyTTL time.Duration) *mux.Router {
+	customers := handler.NewCustomerHandler(store, trashRetention)
+	bulk := handler.NewBulkHandler(store)
+	audit := handler.NewAuditHandler(store)
+	search := handler.NewSearchHandler(store)
+	merge := handler.NewMergeHandler(store, store)
+	imports := handler.NewImportHandler(store)
+	interactions := handler.NewInteractionHandler(store)
+	addresses := handler.NewAddressHandler(store)
+	farms := handler.NewFarmHandler(store)
+	products := handler.NewProductHandler(store)
+	orders := handler.NewOrderHandler(store, store)
+	tags := handler.NewTagHandler(store)
+	idempotent := handler.Idempotency(store, idempotencyTTL)
 
 	r := mux.NewRouter()
+	r.Use(handler.RequestID, handler.Actor)
+	r.NotFoundHandler = handler.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.WriteProblem(w, r, http.StatusNotFound, "No resource at "+r.URL.Path)
+	}))
+	r.MethodNotAllowedHandler = handler.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.WriteProblem(w, r, http.StatusMethodNotAllowed, r.Method+" is not supported for "+r.URL.Path)
+	}))
 
 	// Serve Swagger documentation
 	r.PathPrefix("/swagger/").Handler(httpSwagger.WrapHandler)
@@ -33,14 +93,93 @@ func main() {
 	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("./static/"))))
 	r.HandleFunc("/", homePageHandler)
 
-	// Define API routes
-	r.HandleFunc("/customers", logRequest(handler.GetCustomers)).Methods("GET")
-	r.HandleFunc("/customers/{id}", logRequest(handler.GetCustomer)).Methods("GET")
-	r.HandleFunc("/customers", logRequest(handler.AddCustomer)).Methods("POST")
-	r.HandleFunc("/customers/{id}", logRequest(handler.UpdateCustomer)).Methods("PUT")
-	r.HandleFunc("/customers/{id}", logRequest(handler.DeleteCustomer)).Methods("DELETE")
+	// vCards are not negotiated, so they are routed before the API
+	r.HandleFunc("/customers/export.vcf", logRequest(customers.ExportVCards)).Methods("GET")
+	r.HandleFunc("/customers/{id:[0-9]+}.vcf", logRequest(customers.GetCustomerVCard)).Methods("GET")
+
+	// Define API routes, answering in the format the client accepts
+	api := r.NewRoute().Subrouter()
+	api.Use(handler.Negotiate)
+	api.HandleFunc("/customers/trash", logRequest(customers.GetTrash)).Methods("GET")
+	api.HandleFunc("/customers/trash", logRequest(customers.PurgeTrash)).Methods("DELETE")
+	api.HandleFunc("/customers/bulk", logRequest(bulk.CreateCustomers)).Methods("POST")
+	api.HandleFunc("/customers/bulk", logRequest(bulk.UpdateCustomers)).Methods("PUT")
+	api.HandleFunc("/customers/bulk", logRequest(bulk.DeleteCustomers)).Methods("DELETE")
+	api.HandleFunc("/customers/search", logRequest(search.SearchCustomers)).Methods("GET")
+	api.HandleFunc("/customers/import", logRequest(imports.ImportCustomers)).Methods("POST")
+	api.HandleFunc("/customers/import/vcard", logRequest(imports.ImportVCards)).Methods("POST")
+	api.HandleFunc("/customers/duplicates", logRequest(merge.GetDuplicates)).Methods("GET")
+	api.HandleFunc("/customers", logRequest(customers.GetCustomers)).Methods("GET")
+	api.HandleFunc("/customers/{id}", logRequest(customers.GetCustomer)).Methods("GET")
+	api.Handle("/customers", idempotent(logRequest(customers.AddCustomer))).Methods("POST")
+	api.HandleFunc("/customers/{id}", logRequest(customers.UpdateCustomer)).Methods("PUT")
+	api.HandleFunc("/customers/{id}", logRequest(customers.PatchCustomer)).Methods("PATCH")
+	api.HandleFunc("/customers/{id}", logRequest(customers.DeleteCustomer)).Methods("DELETE")
+	api.HandleFunc("/customers/{id}/restore", logRequest(customers.RestoreCustomer)).Methods("POST")
+	api.HandleFunc("/customers/{id}/merge", logRequest(merge.MergeCustomer)).Methods("POST")
+	api.HandleFunc("/customers/{id}/history", logRequest(audit.GetCustomerHistory)).Methods("GET")
+	api.HandleFunc("/customers/{id}/interactions", logRequest(interactions.GetInteractions)).Methods("GET")
+	api.HandleFunc("/customers/{id}/interactions", logRequest(interactions.AddInteraction)).Methods("POST")
+	api.HandleFunc("/customers/{id}/interactions/{interactionId}", logRequest(interactions.GetInteraction)).Methods("GET")
+	api.HandleFunc("/customers/{id}/interactions/{interactionId}", logRequest(interactions.DeleteInteraction)).Methods("DELETE")
+	api.HandleFunc("/customers/{id}/addresses", logRequest(addresses.GetAddresses)).Methods("GET")
+	api.HandleFunc("/customers/{id}/addresses", logRequest(addresses.AddAddress)).Methods("POST")
+	api.HandleFunc("/customers/{id}/addresses/{addressId}", logRequest(addresses.GetAddress)).Methods("GET")
+	api.HandleFunc("/customers/{id}/addresses/{addressId}", logRequest(addresses.UpdateAddress)).Methods("PUT")
+	api.HandleFunc("/customers/{id}/addresses/{addressId}", logRequest(addresses.DeleteAddress)).Methods("DELETE")
+	api.HandleFunc("/customers/{id}/farms", logRequest(farms.GetCustomerFarms)).Methods("GET")
+	api.HandleFunc("/farms", logRequest(farms.GetFarms)).Methods("GET")
+	api.HandleFunc("/farms", logRequest(farms.AddFarm)).Methods("POST")
+	api.HandleFunc("/farms/{id}", logRequest(farms.GetFarm)).Methods("GET")
+	api.HandleFunc("/farms/{id}", logRequest(farms.UpdateFarm)).Methods("PUT")
+	api.HandleFunc("/farms/{id}", logRequest(farms.DeleteFarm)).Methods("DELETE")
+	api.HandleFunc("/farms/{id}/contacts", logRequest(farms.GetFarmContacts)).Methods("GET")
+	api.HandleFunc("/farms/{id}/contacts/{customerId}", logRequest(farms.LinkCustomer)).Methods("PUT")
+	api.HandleFunc("/farms/{id}/contacts/{customerId}", logRequest(farms.UnlinkCustomer)).Methods("DELETE")
+	api.HandleFunc("/customers/{id}/orders", logRequest(orders.GetCustomerOrders)).Methods("GET")
+	api.HandleFunc("/products", logRequest(products.GetProducts)).Methods("GET")
+	api.HandleFunc("/products", logRequest(products.AddProduct)).Methods("POST")
+	api.HandleFunc("/products/{id}", logRequest(products.GetProduct)).Methods("GET")
+	api.HandleFunc("/products/{id}", logRequest(products.UpdateProduct)).Methods("PUT")
+	api.HandleFunc("/products/{id}", logRequest(products.DeleteProduct)).Methods("DELETE")
+	api.HandleFunc("/orders", logRequest(orders.GetOrders)).Methods("GET")
+	api.HandleFunc("/orders", logRequest(orders.AddOrder)).Methods("POST")
+	api.HandleFunc("/orders/{id}", logRequest(orders.GetOrder)).Methods("GET")
+	api.HandleFunc("/orders/{id}", logRequest(orders.UpdateOrder)).Methods("PUT")
+	api.HandleFunc("/orders/{id}", logRequest(orders.DeleteOrder)).Methods("DELETE")
+	api.HandleFunc("/customers/{id}/tags", logRequest(tags.GetCustomerTags)).Methods("GET")
+	api.HandleFunc("/customers/{id}/tags", logRequest(tags.TagCustomer)).Methods("POST")
+	api.HandleFunc("/customers/{id}/tags/{tagId}", logRequest(tags.UntagCustomer)).Methods("DELETE")
+	api.HandleFunc("/tags", logRequest(tags.GetTags)).Methods("GET")
+	api.HandleFunc("/tags", logRequest(tags.AddTag)).Methods("POST")
+	api.HandleFunc("/tags/{id}", logRequest(tags.GetTag)).Methods("GET")
+	api.HandleFunc("/tags/{id}", logRequest(tags.RenameTag)).Methods("PUT")
+	api.HandleFunc("/tags/{id}", logRequest(tags.DeleteTag)).Methods("DELETE")
+	api.HandleFunc("/tags/{id}/merge", logRequest(tags.MergeTag)).Methods("POST")
+	api.HandleFunc("/audit", logRequest(audit.GetAudit)).Methods("GET")
+
+	return r
+}
 
-	log.Fatal(http.ListenAndServe(":8080", r))
+// purgeTrash permanently removes customers that have been in the trash
+// longer than retention, checking once per interval.
+func purgeTrash(repo persistence.CustomerRepository, retention, interval time.Duration) {
+	for ; ; time.Sleep(interval) {
+		purged, err := repo.Purge(context.Background(), time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("Failed to purge the trash: %v", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d customers from the trash.", purged)
+		}
+	}
+}
+
+// envOr returns the environment variable key, or fallback if it is unset.
+func envOr(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
 }
 
 // Home page handler for the static HTML page
@@ -53,11 +192,10 @@ func logRequest(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
-				log.Printf("panic: %v", err)
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				handler.InternalServerError(w, r, fmt.Errorf("panic: %v", err))
 			}
 		}()
-		log.Printf("Handling request: %s %s", r.Method, r.URL.Path)
+		log.Printf("Handling request %s: %s %s", handler.RequestIDFromContext(r.Context()), r.Method, r.URL.Path)
 		next(w, r)
 	}
 }
@@ -1,18 +1,44 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"farmApp/pkg/api"
 	handlerApp "farmApp/pkg/handler"
 	"farmApp/pkg/persistence"
+	"fmt"
 	"github.com/gorilla/mux"
+	"golang.org/x/text/encoding/charmap"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 )
 
+// newTestHandler creates a handler on top of an in-memory store seeded with the demo customers
+func newTestHandler(t *testing.T) *handlerApp.CustomerHandler {
+	return handlerApp.NewCustomerHandler(newTestStore(t), 30*24*time.Hour)
+}
+
+// newTestStore returns a memory store seeded with the demo customers.
+func newTestStore(t *testing.T) persistence.Store {
+	store, err := persistence.Open(persistence.MemoryDSN, persistence.Options{Seed: persistence.SeedDemo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
 // Tests happy path of submitting a well-formed GET /customers request
 func TestGetCustomersHandler(t *testing.T) {
-	persistence.CreateDB("./test1.db")
+	customers := newTestHandler(t)
 	req, err := http.NewRequest("GET", "/customers", nil)
 
 	if err != nil {
@@ -20,7 +46,7 @@ func TestGetCustomersHandler(t *testing.T) {
 	}
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(handlerApp.GetCustomers)
+	handler := http.HandlerFunc(customers.GetCustomers)
 	handler.ServeHTTP(rr, req)
 
 	// Checks for 200 status code
@@ -38,12 +64,12 @@ func TestGetCustomersHandler(t *testing.T) {
 
 // Tests happy path of submitting a well-formed POST /customers request
 func TestAddCustomerHandler(t *testing.T) {
-	persistence.CreateDB("./test2.db")
+	customers := newTestHandler(t)
 	requestBody := strings.NewReader(`
 		{
 			"name": "Example Name",
 			"role": "Example Role",
-			"email": "Example Email",
+			"email": "example@example.com",
 			"phone": "5550199",
 			"contacted": true
 		}
@@ -56,7 +82,7 @@ func TestAddCustomerHandler(t *testing.T) {
 	}
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(handlerApp.AddCustomer)
+	handler := http.HandlerFunc(customers.AddCustomer)
 	handler.ServeHTTP(rr, req)
 
 	// Checks for 201 status code
@@ -74,7 +100,7 @@ func TestAddCustomerHandler(t *testing.T) {
 
 // Tests unhappy path of deleting a user that doesn't exist
 func TestDeleteCustomerHandler(t *testing.T) {
-	persistence.CreateDB("./test3.db")
+	customers := newTestHandler(t)
 	req, err := http.NewRequest("DELETE", "/customers/222", nil)
 
 	if err != nil {
@@ -83,7 +109,7 @@ func TestDeleteCustomerHandler(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 	router := mux.NewRouter()
-	router.HandleFunc("/customers/{id}", handlerApp.DeleteCustomer).Methods("DELETE")
+	router.HandleFunc("/customers/{id}", customers.DeleteCustomer).Methods("DELETE")
 	router.ServeHTTP(rr, req)
 
 	// Checks for 404 status code
@@ -95,7 +121,7 @@ func TestDeleteCustomerHandler(t *testing.T) {
 
 // Tests unhappy path of getting a user that doesn't exist
 func TestGetCustomerHandler(t *testing.T) {
-	persistence.CreateDB("./test4.db")
+	customers := newTestHandler(t)
 	req, err := http.NewRequest("GET", "/customers/1000", nil)
 
 	if err != nil {
@@ -104,7 +130,7 @@ func TestGetCustomerHandler(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 	router := mux.NewRouter()
-	router.HandleFunc("/customers/{id}", handlerApp.GetCustomer).Methods("GET")
+	router.HandleFunc("/customers/{id}", customers.GetCustomer).Methods("GET")
 	router.ServeHTTP(rr, req)
 
 	// Checks for 404 status code
@@ -113,3 +139,1281 @@ func TestGetCustomerHandler(t *testing.T) {
 			status, http.StatusNotFound)
 	}
 }
+
+// Tests that a deleted customer is moved to the trash and can be restored
+func TestDeleteAndRestoreCustomer(t *testing.T) {
+	store := persistence.NewMemoryStore()
+	id, err := store.Create(context.Background(), api.Customer{Name: "Bauer Klaus"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newRouter(store, time.Hour, time.Hour)
+	path := fmt.Sprintf("/customers/%d", id)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/customers/trash", nil))
+	if rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != "[]" {
+		t.Errorf("getTrash of an empty trash returned wrong response: got %v %q want %v %q", rr.Code, rr.Body, http.StatusOK, "[]")
+	}
+
+	steps := []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{"DELETE", path, http.StatusNoContent},
+		{"GET", path, http.StatusNotFound},
+		{"GET", "/customers/trash", http.StatusOK},
+		{"POST", path + "/restore", http.StatusOK},
+		{"GET", path, http.StatusOK},
+		{"POST", path + "/restore", http.StatusNotFound},
+	}
+	for _, step := range steps {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(step.method, step.path, nil)
+		req.Header.Set("If-Match", "*")
+		router.ServeHTTP(rr, req)
+		if rr.Code != step.want {
+			t.Errorf("%s %s returned wrong status code: got %v want %v", step.method, step.path, rr.Code, step.want)
+		}
+	}
+}
+
+// Tests that writes need the current ETag and stale writes are rejected
+func TestUpdateCustomerPreconditions(t *testing.T) {
+	store := persistence.NewMemoryStore()
+	id, err := store.Create(context.Background(), api.Customer{Name: "Bauer Klaus"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newRouter(store, time.Hour, time.Hour)
+	path := fmt.Sprintf("/customers/%d", id)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+	etag := rr.Header().Get("ETag")
+	if etag != `"1"` {
+		t.Fatalf("getCustomer returned wrong ETag: got %v want %v", etag, `"1"`)
+	}
+
+	steps := []struct {
+		method  string
+		ifMatch string
+		want    int
+	}{
+		{"PUT", "", http.StatusPreconditionRequired},
+		{"PUT", "W/" + etag, http.StatusPreconditionFailed},
+		{"PUT", etag, http.StatusOK},
+		{"PUT", etag, http.StatusPreconditionFailed},
+		{"DELETE", etag, http.StatusPreconditionFailed},
+		{"DELETE", `"2"`, http.StatusNoContent},
+	}
+	for _, step := range steps {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(step.method, path, strings.NewReader(`{"name": "Bauer Klaus", "email": "klaus.bauer@farm.de", "contacted": true}`))
+		if step.ifMatch != "" {
+			req.Header.Set("If-Match", step.ifMatch)
+		}
+		router.ServeHTTP(rr, req)
+		if rr.Code != step.want {
+			t.Errorf("%s with If-Match %q returned wrong status code: got %v want %v", step.method, step.ifMatch, rr.Code, step.want)
+		}
+	}
+}
+
+// Tests that changes are recorded in the customer history with the X-Actor header
+func TestCustomerHistory(t *testing.T) {
+	router := newRouter(persistence.NewMemoryStore(), time.Hour, time.Hour)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/customers", strings.NewReader(`{"name": "Bauer Klaus", "email": "klaus.bauer@farm.de"}`))
+	req.Header.Set("X-Actor", "anna")
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("addCustomer returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/customers/1/history", nil))
+	var history []api.AuditEntry
+	if err := json.NewDecoder(rr.Body).Decode(&history); err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Actor != "anna" || history[0].Operation != "create" {
+		t.Errorf("history does not contain the creation by anna: got %+v", history)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/customers/2/history", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("history of a missing customer returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+// Tests that GET /customers pages through all customers with next links
+func TestGetCustomersPagination(t *testing.T) {
+	store, err := persistence.Open(persistence.MemoryDSN, persistence.Options{Seed: persistence.SeedDemo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newRouter(store, time.Hour, time.Hour)
+
+	seen := 0
+	next := "/customers?limit=4"
+	for pages := 0; next != ""; pages++ {
+		if pages == 3 {
+			t.Fatal("getCustomers returned too many pages")
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", next, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("getCustomers returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		if total := rr.Header().Get("X-Total-Count"); total != "10" {
+			t.Errorf("getCustomers returned wrong total: got %v want %v", total, 10)
+		}
+
+		var customers []api.Customer
+		if err := json.NewDecoder(rr.Body).Decode(&customers); err != nil {
+			t.Fatal(err)
+		}
+		seen += len(customers)
+
+		next = ""
+		if link := rr.Header().Get("Link"); link != "" {
+			next = strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+		}
+	}
+	if seen != 10 {
+		t.Errorf("pages returned wrong number of customers: got %v want %v", seen, 10)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/customers?cursor=bogus", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("getCustomers with a bad cursor returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+// Tests filtering and sorting GET /customers through query parameters
+func TestGetCustomersFilterAndSort(t *testing.T) {
+	router := newRouter(newTestStore(t), time.Hour, time.Hour)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/customers?contacted=false&name=ER&sort=-name&created_after=2000-01-01", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("getCustomers returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var customers []api.Customer
+	if err := json.NewDecoder(rr.Body).Decode(&customers); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, customer := range customers {
+		names = append(names, customer.Name)
+	}
+	want := []string{"Weber Karl", "Wagner Thomas", "Schmidt Peter", "Bauerin Anna"}
+	if strings.Join(names, ", ") != strings.Join(want, ", ") {
+		t.Errorf("getCustomers returned wrong customers: got %v want %v", names, want)
+	}
+
+	for _, query := range []string{"contacted=maybe", "sort=deleted_at", "created_before=yesterday"} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/customers?"+query, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("getCustomers?%s returned wrong status code: got %v want %v", query, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+// Tests GET /customers/search with a transliterated umlaut
+func TestSearchCustomers(t *testing.T) {
+	router := newRouter(newTestStore(t), time.Hour, time.Hour)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/customers/search?q=mueller", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("searchCustomers returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var results []api.SearchResult
+	if err := json.NewDecoder(rr.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Customer.Name != "Müller Hans" {
+		t.Fatalf("searchCustomers returned wrong customers: got %+v", results)
+	}
+	want := map[string]string{"name": "<mark>Müller</mark> Hans", "email": "hans.<mark>mueller</mark>@farm.de"}
+	if fmt.Sprint(results[0].Highlights) != fmt.Sprint(want) {
+		t.Errorf("searchCustomers returned wrong highlights: got %v want %v", results[0].Highlights, want)
+	}
+
+	for _, query := range []string{"", "q=+", "q=bauer&limit=0"} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/customers/search?"+query, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("searchCustomers?%s returned wrong status code: got %v want %v", query, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+// Tests the duplicate report and POST /customers/{id}/merge on the demo customers
+func TestMergeCustomers(t *testing.T) {
+	router := newRouter(newTestStore(t), time.Hour, time.Hour)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/customers/duplicates", nil))
+	var pairs []api.DuplicatePair
+	if err := json.NewDecoder(rr.Body).Decode(&pairs); err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != 1 || *pairs[0].Customers[0].ID != 1 || *pairs[0].Customers[1].ID != 2 {
+		t.Errorf("getDuplicates returned wrong pairs: got %+v", pairs)
+	}
+
+	steps := []struct {
+		ifMatch string
+		body    string
+		want    int
+	}{
+		{"", `{"source_id": 2, "source_version": 1}`, http.StatusPreconditionRequired},
+		{`"1"`, `{"source_id": 2}`, http.StatusPreconditionRequired},
+		{`"1"`, `{"source_version": 1}`, http.StatusBadRequest},
+		{`"1"`, `{"source_id": 1, "source_version": 1}`, http.StatusBadRequest},
+		{`"1"`, `{"source_id": 2, "source_version": 1, "fields": {"name": "both", "id": "source"}}`, http.StatusUnprocessableEntity},
+		{`"1"`, `{"source_id": 99, "source_version": 1}`, http.StatusNotFound},
+		{`"1"`, `{"source_id": 2, "source_version": 1, "fields": {"name": "source"}}`, http.StatusOK},
+		{`"2"`, `{"source_id": 2, "source_version": 1}`, http.StatusNotFound},
+	}
+	for _, step := range steps {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/customers/1/merge", strings.NewReader(step.body))
+		if step.ifMatch != "" {
+			req.Header.Set("If-Match", step.ifMatch)
+		}
+		router.ServeHTTP(rr, req)
+		if rr.Code != step.want {
+			t.Errorf("mergeCustomer with %s returned wrong status code: got %v want %v", step.body, rr.Code, step.want)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/customers/1", nil))
+	var merged api.Customer
+	if err := json.NewDecoder(rr.Body).Decode(&merged); err != nil {
+		t.Fatal(err)
+	}
+	if merged.Name != "Bauerin Anna" || merged.Email != "klaus.bauer@farm.de" || !merged.Contacted || merged.Version != 2 {
+		t.Errorf("mergeCustomer stored wrong values: got %+v", merged)
+	}
+}
+
+// Tests recording interactions and the contact fields derived from them
+func TestCustomerInteractions(t *testing.T) {
+	router := newRouter(newTestStore(t), time.Hour, time.Hour)
+
+	steps := []struct {
+		method, url, body string
+		want              int
+	}{
+		{"POST", "/customers/2/interactions", `{"channel": "fax"}`, http.StatusUnprocessableEntity},
+		{"POST", "/customers/99/interactions", `{"channel": "call"}`, http.StatusNotFound},
+		{"POST", "/customers/2/interactions", `{"channel": "visit", "occurred_at": "2024-03-01T09:30:00+01:00", "notes": "Looked at the barn"}`, http.StatusCreated},
+		{"POST", "/customers/2/interactions", `{"channel": "Call", "author": "Anna"}`, http.StatusCreated},
+		{"GET", "/customers/2/interactions/1", "", http.StatusOK},
+		{"GET", "/customers/1/interactions/1", "", http.StatusNotFound},
+		{"DELETE", "/customers/2/interactions/2", "", http.StatusNoContent},
+		{"DELETE", "/customers/2/interactions/2", "", http.StatusNotFound},
+		{"GET", "/customers/2/interactions/abc", "", http.StatusBadRequest},
+	}
+	for _, step := range steps {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(step.method, step.url, strings.NewReader(step.body))
+		req.Header.Set("X-Actor", "klaus")
+		router.ServeHTTP(rr, req)
+		if rr.Code != step.want {
+			t.Errorf("%s %s %s returned wrong status code: got %v want %v", step.method, step.url, step.body, rr.Code, step.want)
+		}
+		if rr.Code == http.StatusCreated && !strings.HasPrefix(rr.Header().Get("Location"), "/customers/2/interactions/") {
+			t.Errorf("%s %s returned wrong Location: got %q", step.method, step.url, rr.Header().Get("Location"))
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/customers/2/interactions", nil))
+	var interactions []api.Interaction
+	if err := json.NewDecoder(rr.Body).Decode(&interactions); err != nil {
+		t.Fatal(err)
+	}
+	if len(interactions) != 1 || interactions[0].Author != "klaus" || interactions[0].Channel != api.ChannelVisit ||
+		interactions[0].Notes != "Looked at the barn" {
+		t.Errorf("getInteractions returned wrong interactions: got %+v", interactions)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/customers/2", nil))
+	var customer api.Customer
+	if err := json.NewDecoder(rr.Body).Decode(&customer); err != nil {
+		t.Fatal(err)
+	}
+	if !customer.Contacted || customer.ContactCount != 1 || customer.LastContactedAt == nil ||
+		customer.LastContactedAt.Format(time.RFC3339) != "2024-03-01T08:30:00Z" || customer.Version != 4 {
+		t.Errorf("addInteraction did not update the customer: got %+v", customer)
+	}
+
+	rr = httptest.NewRecorder()
+	req := httptest.NewRequest("PATCH", "/customers/2", strings.NewReader(`{"contact_count": 5}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("If-Match", `"4"`)
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("patchCustomer of contact_count returned wrong status code: got %v want %v", rr.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+// Tests the addresses of customers and filtering customers by them
+func TestCustomerAddresses(t *testing.T) {
+	router := newRouter(newTestStore(t), time.Hour, time.Hour)
+
+	steps := []struct {
+		method, url, body string
+		want              int
+	}{
+		{"POST", "/customers/2/addresses", `{"type": "office", "street": "Feldweg", "postal_code": "84028", "city": "Landshut"}`, http.StatusUnprocessableEntity},
+		{"POST", "/customers/2/addresses", `{"type": "billing", "street": "Feldweg", "postal_code": "8402", "city": "Landshut"}`, http.StatusUnprocessableEntity},
+		{"POST", "/customers/2/addresses", `{"type": "billing", "street": "Feldweg", "postal_code": "84028", "city": "Landshut", "country": "Germany"}`, http.StatusUnprocessableEntity},
+		{"POST", "/customers/99/addresses", `{"type": "billing", "street": "Feldweg", "postal_code": "84028", "city": "Landshut"}`, http.StatusNotFound},
+		{"POST", "/customers/2/addresses", `{"type": "Farm_Yard", "street": "Feldweg", "house_number": "3", "postal_code": " 84028 ", "city": "Landshut"}`, http.StatusCreated},
+		{"POST", "/customers/1/addresses", `{"type": "delivery", "street": "Dorfstraße", "postal_code": "6020", "city": "Innsbruck", "country": "at"}`, http.StatusCreated},
+		{"GET", "/customers/2/addresses/1", "", http.StatusOK},
+		{"GET", "/customers/1/addresses/1", "", http.StatusNotFound},
+		{"PUT", "/customers/2/addresses/1", `{"type": "farm_yard", "street": "Feldweg", "house_number": "5", "postal_code": "84028", "city": "Landshut"}`, http.StatusOK},
+		{"PUT", "/customers/2/addresses/1", `{"type": "farm_yard", "street": "Feldweg", "postal_code": "8402A", "city": "Landshut"}`, http.StatusUnprocessableEntity},
+		{"PUT", "/customers/2/addresses/2", `{"type": "farm_yard", "street": "Feldweg", "postal_code": "84028", "city": "Landshut"}`, http.StatusNotFound},
+		{"GET", "/customers/2/addresses/abc", "", http.StatusBadRequest},
+	}
+	for _, step := range steps {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(step.method, step.url, strings.NewReader(step.body)))
+		if rr.Code != step.want {
+			t.Errorf("%s %s %s returned wrong status code: got %v want %v", step.method, step.url, step.body, rr.Code, step.want)
+		}
+		if rr.Code == http.StatusCreated && !strings.HasPrefix(rr.Header().Get("Location"), "/customers/") {
+			t.Errorf("%s %s returned wrong Location: got %q", step.method, step.url, rr.Header().Get("Location"))
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/customers/2/addresses", nil))
+	var addresses []api.Address
+	if err := json.NewDecoder(rr.Body).Decode(&addresses); err != nil {
+		t.Fatal(err)
+	}
+	if len(addresses) != 1 || addresses[0].Type != api.AddressFarmYard || addresses[0].PostalCode != "84028" ||
+		addresses[0].Country != api.CountryGermany || addresses[0].HouseNumber != "5" {
+		t.Errorf("getAddresses returned wrong addresses: got %+v", addresses)
+	}
+
+	filters := []struct {
+		query string
+		want  []int
+	}{
+		{"address=feldweg", []int{2}},
+		{"address=innsbruck", []int{1}},
+		{"postal_code=84", []int{2}},
+		{"city=LANDSHUT", []int{2}},
+		{"country=AT", []int{1}},
+		{"address_type=delivery&country=DE", nil},
+		{"address_type=farm_yard&name=bauerin", []int{2}},
+	}
+	for _, filter := range filters {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/customers?"+filter.query, nil))
+		var customers []api.Customer
+		if err := json.NewDecoder(rr.Body).Decode(&customers); err != nil {
+			t.Fatal(err)
+		}
+		var got []int
+		for _, customer := range customers {
+			got = append(got, *customer.ID)
+		}
+		if !slices.Equal(got, filter.want) {
+			t.Errorf("getCustomers?%s returned wrong customers: got %v want %v", filter.query, got, filter.want)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("DELETE", "/customers/2/addresses/1", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("deleteAddress returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+}
+
+// Tests the farm endpoints and linking customers to farms
+func TestFarms(t *testing.T) {
+	router := newRouter(newTestStore(t), time.Hour, time.Hour)
+
+	steps := []struct {
+		method, url, body string
+		want              int
+	}{
+		{"POST", "/farms", `{"name": "Lindenhof", "type": "castle"}`, http.StatusUnprocessableEntity},
+		{"POST", "/farms", `{"name": "Lindenhof", "address": "Dorfstraße 1, 12345 Linden", "size_hectares": 120.5, "type": "dairy"}`, http.StatusCreated},
+		{"PUT", "/farms/1", `{"name": "Lindenhof", "size_hectares": 130, "type": "dairy"}`, http.StatusOK},
+		{"PUT", "/farms/9", `{"name": "Lindenhof", "type": "dairy"}`, http.StatusNotFound},
+		{"PUT", "/farms/1/contacts/1", `{"role": " Owner "}`, http.StatusOK},
+		{"PUT", "/farms/1/contacts/2", `{"role": "Manager"}`, http.StatusOK},
+		{"PUT", "/farms/1/contacts/3", `{}`, http.StatusUnprocessableEntity},
+		{"PUT", "/farms/1/contacts/99", `{"role": "Owner"}`, http.StatusNotFound},
+		{"PUT", "/farms/9/contacts/1", `{"role": "Owner"}`, http.StatusNotFound},
+		{"DELETE", "/farms/1/contacts/3", "", http.StatusNotFound},
+		{"DELETE", "/farms/1/contacts/2", "", http.StatusNoContent},
+		{"DELETE", "/farms/1", "", http.StatusConflict},
+		{"DELETE", "/farms/1?cascade=everything", "", http.StatusBadRequest},
+	}
+	for _, step := range steps {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(step.method, step.url, strings.NewReader(step.body)))
+		if rr.Code != step.want {
+			t.Errorf("%s %s %s returned wrong status code: got %v want %v: %s", step.method, step.url, step.body, rr.Code, step.want, rr.Body)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/farms/1/contacts", nil))
+	var contacts []api.FarmContact
+	if err := json.NewDecoder(rr.Body).Decode(&contacts); err != nil {
+		t.Fatal(err)
+	}
+	if len(contacts) != 1 || contacts[0].Role != "Owner" || contacts[0].Customer.Name != "Bauer Klaus" {
+		t.Errorf("getFarmContacts returned wrong contacts: got %+v", contacts)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/customers/1/farms", nil))
+	var farms []api.CustomerFarm
+	if err := json.NewDecoder(rr.Body).Decode(&farms); err != nil {
+		t.Fatal(err)
+	}
+	if len(farms) != 1 || farms[0].Role != "Owner" || farms[0].Farm.SizeHectares != 130 || farms[0].Farm.Address != "" {
+		t.Errorf("getCustomerFarms returned wrong farms: got %+v", farms)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("DELETE", "/farms/1?cascade=trash", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("deleteFarm returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	for url, want := range map[string]int{"/farms/1": http.StatusNotFound, "/customers/1": http.StatusNotFound, "/customers/2": http.StatusOK} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+		if rr.Code != want {
+			t.Errorf("GET %s after deleting the farm returned wrong status code: got %v want %v", url, rr.Code, want)
+		}
+	}
+}
+
+// Tests the product and order endpoints and the purchase history of a customer
+func TestOrders(t *testing.T) {
+	router := newRouter(newTestStore(t), time.Hour, time.Hour)
+
+	steps := []struct {
+		method, url, body string
+		want              int
+	}{
+		{"POST", "/products", `{"name": "Winterweizen", "category": "seeds", "unit": "25 kg bag"}`, http.StatusUnprocessableEntity},
+		{"POST", "/products", `{"name": "Winterweizen", "category": "seed", "unit": "25 kg bag", "price_cents": 3450}`, http.StatusCreated},
+		{"POST", "/products", `{"name": "Weidezaungerät", "category": "equipment", "unit": "piece", "price_cents": 18900}`, http.StatusCreated},
+		{"GET", "/products?category=tools", "", http.StatusBadRequest},
+		{"POST", "/orders", `{"customer_id": 1, "lines": []}`, http.StatusUnprocessableEntity},
+		{"POST", "/orders", `{"customer_id": 99, "lines": [{"product_id": 1, "quantity": 2}]}`, http.StatusUnprocessableEntity},
+		{"POST", "/orders", `{"customer_id": 1, "lines": [{"product_id": 1, "quantity": 2}, {"product_id": 2, "quantity": 1}]}`, http.StatusCreated},
+		{"POST", "/orders", `{"customer_id": 2, "lines": [{"product_id": 2, "quantity": 1}]}`, http.StatusCreated},
+		{"PUT", "/orders/1", `{"customer_id": 1, "status": "shipped", "lines": [{"product_id": 1, "quantity": 2}, {"product_id": 2, "quantity": 1}]}`, http.StatusConflict},
+		{"PUT", "/orders/1", `{"customer_id": 1, "status": "confirmed", "lines": [{"product_id": 1, "quantity": 2}, {"product_id": 2, "quantity": 1}]}`, http.StatusOK},
+		{"DELETE", "/orders/1", "", http.StatusConflict},
+		{"PUT", "/products/1", `{"name": "Winterweizen", "category": "seed", "unit": "25 kg bag", "price_cents": 3900}`, http.StatusOK},
+		{"DELETE", "/products/2", "", http.StatusNoContent},
+		{"GET", "/products/2", "", http.StatusNotFound},
+		{"DELETE", "/orders/2", "", http.StatusNoContent},
+		{"GET", "/orders/2", "", http.StatusNotFound},
+		{"GET", "/customers/99/orders", "", http.StatusNotFound},
+		{"GET", "/orders?customer_id=0", "", http.StatusBadRequest},
+		{"GET", "/orders?customer_id=-1", "", http.StatusBadRequest},
+	}
+	for _, step := range steps {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(step.method, step.url, strings.NewReader(step.body)))
+		if rr.Code != step.want {
+			t.Errorf("%s %s %s returned wrong status code: got %v want %v: %s", step.method, step.url, step.body, rr.Code, step.want, rr.Body)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/customers/1/orders?status=confirmed", nil))
+	var orders []api.Order
+	if err := json.NewDecoder(rr.Body).Decode(&orders); err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 || orders[0].TotalCents != 2*3450+18900 || orders[0].Lines[1].ProductName != "Weidezaungerät" {
+		t.Errorf("getCustomerOrders returned wrong orders: got %+v", orders)
+	}
+}
+
+// Tests the tag endpoints and filtering customers by tags
+func TestTags(t *testing.T) {
+	router := newRouter(newTestStore(t), time.Hour, time.Hour)
+
+	steps := []struct {
+		method, url, body string
+		want              int
+	}{
+		{"POST", "/tags", `{"name": ""}`, http.StatusUnprocessableEntity},
+		{"POST", "/tags", `{"name": "organic, dairy"}`, http.StatusUnprocessableEntity},
+		{"POST", "/tags", `{"name": "organic"}`, http.StatusCreated},
+		{"POST", "/tags", `{"name": "Organic"}`, http.StatusConflict},
+		{"POST", "/customers/1/tags", `{"name": "ORGANIC"}`, http.StatusOK},
+		{"POST", "/customers/1/tags", `{"name": "VIP"}`, http.StatusOK},
+		{"POST", "/customers/2/tags", `{"name": "Bio"}`, http.StatusOK},
+		{"POST", "/customers/99/tags", `{"name": "VIP"}`, http.StatusNotFound},
+		{"PUT", "/tags/2", `{"name": "organic"}`, http.StatusConflict},
+		{"PUT", "/tags/2", `{"name": "Premium"}`, http.StatusOK},
+		{"PUT", "/tags/99", `{"name": "Premium"}`, http.StatusNotFound},
+		{"POST", "/tags/1/merge", `{"source_id": 1}`, http.StatusBadRequest},
+		{"POST", "/tags/1/merge", `{}`, http.StatusBadRequest},
+		{"POST", "/tags/1/merge", `{"source_id": 3}`, http.StatusOK},
+		{"GET", "/tags/3", "", http.StatusNotFound},
+		{"DELETE", "/customers/2/tags/2", "", http.StatusNotFound},
+		{"GET", "/customers?tags_match=some", "", http.StatusBadRequest},
+	}
+	for _, step := range steps {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(step.method, step.url, strings.NewReader(step.body)))
+		if rr.Code != step.want {
+			t.Errorf("%s %s %s returned wrong status code: got %v want %v", step.method, step.url, step.body, rr.Code, step.want)
+		}
+		if rr.Code == http.StatusCreated && !strings.HasPrefix(rr.Header().Get("Location"), "/tags/") {
+			t.Errorf("%s %s returned wrong Location: got %q", step.method, step.url, rr.Header().Get("Location"))
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/tags", nil))
+	var tags []api.Tag
+	if err := json.NewDecoder(rr.Body).Decode(&tags); err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 2 || tags[0].Name != "organic" || tags[0].CustomerCount != 2 || tags[1].Name != "Premium" ||
+		tags[1].CustomerCount != 1 {
+		t.Errorf("getTags returned wrong tags: got %+v", tags)
+	}
+
+	filters := []struct {
+		query string
+		want  []int
+	}{
+		{"tags=organic", []int{1, 2}},
+		{"tags=premium,bio", []int{1}},
+		{"tags=organic,premium&tags_match=all", []int{1}},
+		{"tags=organic&role=farmer", []int{1}},
+	}
+	for _, filter := range filters {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/customers?"+filter.query, nil))
+		var customers []api.Customer
+		if err := json.NewDecoder(rr.Body).Decode(&customers); err != nil {
+			t.Fatal(err)
+		}
+		var got []int
+		for _, customer := range customers {
+			got = append(got, *customer.ID)
+		}
+		if !slices.Equal(got, filter.want) {
+			t.Errorf("getCustomers?%s returned wrong customers: got %v want %v", filter.query, got, filter.want)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("DELETE", "/customers/1/tags/2", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("untagCustomer returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/customers/1/tags", nil))
+	if err := json.NewDecoder(rr.Body).Decode(&tags); err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0].Name != "organic" {
+		t.Errorf("getCustomerTags returned wrong tags: got %+v", tags)
+	}
+}
+
+// Tests POST /customers/import with a German Excel file, dry runs and both modes
+func TestImportCustomers(t *testing.T) {
+	router := newRouter(newTestStore(t), time.Hour, time.Hour)
+	file, err := charmap.Windows1252.NewEncoder().String("Kunde;Funktion;E-Mail;Telefon;Kontaktiert\r\n" +
+		"Müller Jürgen;Landwirt;juergen@hof.de;0171 1234567;ja\r\n" +
+		";;\r\n" +
+		"Ohne Mail;Gast;;;vielleicht\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := "delimiter=semicolon&encoding=windows-1252&columns=name:Kunde,role:Funktion,email:E-Mail,phone:4,contacted:Kontaktiert"
+
+	steps := []struct {
+		params     string
+		wantStatus []int
+		wantTotal  int
+	}{
+		{"&dry_run=true", []int{http.StatusFailedDependency, http.StatusUnprocessableEntity}, 10},
+		{"", []int{http.StatusFailedDependency, http.StatusUnprocessableEntity}, 10},
+		{"&mode=best-effort", []int{http.StatusCreated, http.StatusUnprocessableEntity}, 11},
+	}
+	for _, step := range steps {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/customers/import?"+query+step.params, strings.NewReader(file)))
+		if rr.Code != http.StatusMultiStatus {
+			t.Fatalf("importCustomers%s returned wrong status code: got %v want %v", step.params, rr.Code, http.StatusMultiStatus)
+		}
+		var response api.ImportResponse
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		if len(response.Rows) != 2 || response.Rows[0].Row != 2 || response.Rows[1].Row != 4 {
+			t.Fatalf("importCustomers%s returned wrong rows: got %+v", step.params, response.Rows)
+		}
+		for i, row := range response.Rows {
+			if row.Status != step.wantStatus[i] {
+				t.Errorf("importCustomers%s returned wrong status for row %d: got %v want %v", step.params, row.Row, row.Status, step.wantStatus[i])
+			}
+		}
+		// Only an aborted row of a real import has no customer.
+		customer := response.Rows[0].Customer
+		switch {
+		case step.params == "" && customer != nil:
+			t.Errorf("importCustomers%s returned a customer for an aborted row: got %+v", step.params, customer)
+		case step.params == "":
+		case customer == nil || customer.Name != "Müller Jürgen" || customer.Phone != "0171 1234567" || !customer.Contacted:
+			t.Errorf("importCustomers%s returned wrong customer: got %+v", step.params, customer)
+		}
+		if fields := fmt.Sprint(response.Rows[1].Problem.Errors); fields != "[{email is required} {contacted must be yes or no}]" {
+			t.Errorf("importCustomers%s returned wrong field errors: got %v", step.params, fields)
+		}
+
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/customers", nil))
+		if total := rr.Header().Get("X-Total-Count"); total != fmt.Sprint(step.wantTotal) {
+			t.Errorf("importCustomers%s left wrong number of customers: got %v want %v", step.params, total, step.wantTotal)
+		}
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", "customers.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte("\xef\xbb\xbfName|Email\nWeber Anna|anna.weber@farm.de\n"))
+	form.Close()
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/customers/import?delimiter=%7C", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("importCustomers with a form returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	for _, bad := range []struct{ query, file string }{
+		{"delimiter=ab", "name\nA"},
+		{"encoding=klingon", "name\nA"},
+		{"columns=name:Kunde", "name\nA"},
+		{"columns=birthday:2", "name\nA"},
+		{"", "role\nFarmer"},
+		{"", "name\n\"A"},
+		{"", "name\n"},
+	} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/customers/import?"+bad.query, strings.NewReader(bad.file)))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("importCustomers?%s with %q returned wrong status code: got %v want %v", bad.query, bad.file, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+// Tests vCard export and import, including a round trip of a folded line
+func TestVCards(t *testing.T) {
+	store := newTestStore(t)
+	longName := strings.Repeat("Müller-Lüdenscheidt ", 4) + "Jürgen"
+	id, err := store.Create(context.Background(), api.Customer{Name: longName, Role: "Landwirt; Jäger", Email: "juergen@hof.de"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newRouter(store, time.Hour, time.Hour)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/customers/3.vcf", nil))
+	if ctype := rr.Header().Get("Content-Type"); rr.Code != http.StatusOK || ctype != "text/vcard; charset=utf-8" {
+		t.Fatalf("getCustomerVCard returned wrong response: got %v %v", rr.Code, ctype)
+	}
+	want := "BEGIN:VCARD\r\nVERSION:4.0\r\nUID:urn:farmapp:customer:3\r\nFN:Müller Hans\r\nN:Müller Hans;;;;\r\n" +
+		"TITLE:Worker\r\nEMAIL:hans.mueller@farm.de\r\nTEL;VALUE=text:01234 567892\r\nEND:VCARD\r\n"
+	if rr.Body.String() != want {
+		t.Errorf("getCustomerVCard returned wrong vCard:\ngot  %q\nwant %q", rr.Body.String(), want)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", fmt.Sprintf("/customers/%d.vcf", id), nil))
+	exported := rr.Body.String()
+	for _, line := range strings.Split(exported, "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("getCustomerVCard returned a line of %d octets: %q", len(line), line)
+		}
+	}
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/customers/import/vcard?dry_run=true", strings.NewReader(exported)))
+	var response api.ImportResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if customer := response.Rows[0].Customer; customer.Name != longName || customer.Role != "Landwirt; Jäger" {
+		t.Errorf("importVCards did not read back an exported vCard: got %+v", customer)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/customers/export.vcf?contacted=true", nil))
+	if count := strings.Count(rr.Body.String(), "BEGIN:VCARD"); count != 5 {
+		t.Errorf("exportVCards returned wrong number of vCards: got %v want %v", count, 5)
+	}
+
+	file := "BEGIN:VCARD\nVERSION:3.0\nN:Weber;Anna;;;\nitem1.EMAIL;TYPE=INTERNET:anna@example.com\n" +
+		"EMAIL;TYPE=INTERNET,pref:anna.weber@farm.de\nTEL;TYPE=CELL:+49 171 \n 2345678\nROLE:Tierärztin\\, Beratung\nEND:VCARD\n" +
+		"\nBEGIN:VCARD\nVERSION:4.0\nFN:Ohne Adresse\nEND:VCARD\n"
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/customers/import/vcard?mode=best-effort", strings.NewReader(file)))
+	if rr.Code != http.StatusMultiStatus {
+		t.Fatalf("importVCards returned wrong status code: got %v want %v", rr.Code, http.StatusMultiStatus)
+	}
+	response = api.ImportResponse{}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	rows := response.Rows
+	if len(rows) != 2 || rows[0].Row != 1 || rows[0].Status != http.StatusCreated || rows[1].Row != 11 || rows[1].Status != http.StatusUnprocessableEntity {
+		t.Fatalf("importVCards returned wrong rows: got %+v", rows)
+	}
+	wantCustomer := api.Customer{Name: "Weber Anna", Role: "Tierärztin, Beratung", Email: "anna.weber@farm.de", Phone: "+49 171 2345678"}
+	if got := *rows[0].Customer; got.ID == nil || got.Name != wantCustomer.Name || got.Role != wantCustomer.Role ||
+		got.Email != wantCustomer.Email || got.Phone != wantCustomer.Phone {
+		t.Errorf("importVCards created wrong customer: got %+v want %+v", got, wantCustomer)
+	}
+
+	for _, bad := range []string{"", "FN:Nobody\n", "BEGIN:VCARD\nFN:Open\n", "BEGIN:VCARD\nno colon\nEND:VCARD\n"} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("POST", "/customers/import/vcard", strings.NewReader(bad)))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("importVCards with %q returned wrong status code: got %v want %v", bad, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+// Tests that customers are written and read as XML, YAML and CSV depending on Accept and Content-Type
+func TestContentNegotiation(t *testing.T) {
+	router := newRouter(newTestStore(t), time.Hour, time.Hour)
+
+	responses := []struct {
+		path, accept, wantType, wantBody string
+	}{
+		{"/customers/1", "", "application/json", `"name":"Bauer Klaus"`},
+		{"/customers/1", "application/xml", "application/xml", "<customer>\n  <id>1</id>\n  <name>Bauer Klaus</name>"},
+		{"/customers/1", "text/xml", "application/xml", "<contacted>true</contacted>"},
+		{"/customers/1", "application/x-yaml", "application/yaml", "id: 1\nname: Bauer Klaus\nrole: Farmer\n"},
+		{"/customers?limit=2", "text/csv", "text/csv; charset=utf-8",
+			"id,name,role,email,phone,contacted,contact_count,created_at,version\n1,Bauer Klaus,Farmer,klaus.bauer@farm.de,01234 567890,true,0,"},
+		{"/customers?limit=2", "text/html, application/xml;q=0.5, */*;q=0.1", "application/xml", "<customers>\n  <customer>"},
+		{"/customers?limit=2", "application/json;q=0.5, text/csv;q=0.8", "text/csv; charset=utf-8", "id,name,"},
+		{"/customers/1/history", "text/csv", "text/csv; charset=utf-8", "operation,after.contact_count,after.contacted,after.email"},
+		{"/customers/trash", "application/xml", "application/xml", "<customers></customers>"},
+		{"/customers/99", "application/xml", "application/problem+xml", `<problem xmlns="urn:ietf:rfc:7807">`},
+		{"/customers/99", "text/csv", "application/problem+json", `"status":404`},
+		{"/customers/1", "text/html", "application/problem+json", `"status":406`},
+		{"/customers/1", "application/json;q=0, */*", "application/xml", "<customer>"},
+	}
+	for _, response := range responses {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", response.path, nil)
+		if response.accept != "" {
+			req.Header.Set("Accept", response.accept)
+		}
+		router.ServeHTTP(rr, req)
+		if ctype := rr.Header().Get("Content-Type"); ctype != response.wantType {
+			t.Errorf("GET %s with Accept %q returned wrong Content-Type: got %v want %v", response.path, response.accept, ctype, response.wantType)
+		}
+		if !strings.Contains(rr.Body.String(), response.wantBody) {
+			t.Errorf("GET %s with Accept %q returned wrong body: got %q want it to contain %q", response.path, response.accept, rr.Body.String(), response.wantBody)
+		}
+	}
+
+	requests := []struct {
+		path, contentType, body string
+		wantStatus              int
+		wantBody                string
+	}{
+		{"/customers", "application/xml", "<customer><name>Huber Anna</name><email>anna@huber.de</email><contacted>true</contacted></customer>",
+			http.StatusCreated, `"name":"Huber Anna","role":"","email":"anna@huber.de","phone":"","contacted":true`},
+		{"/customers", "application/yaml", "name: Huber Berta\nemail: berta@huber.de\ncontacted: true\n",
+			http.StatusCreated, `"name":"Huber Berta","role":"","email":"berta@huber.de","phone":"","contacted":true`},
+		{"/customers", "text/csv", "name,role,email,contacted\nHuber Carl,Farmer,carl@huber.de,false\n",
+			http.StatusCreated, `"name":"Huber Carl","role":"Farmer","email":"carl@huber.de","phone":"","contacted":false`},
+		{"/customers", "text/csv", "name,email,contacted\nHuber Dora,dora@huber.de,vielleicht\n", http.StatusBadRequest, "contacted must be a boolean"},
+		{"/customers", "text/csv", "name,email\nHuber Dora,dora@huber.de\nHuber Emil,emil@huber.de\n", http.StatusBadRequest, "exactly one row"},
+		{"/customers", "application/xml", "<customer><name>Huber Dora", http.StatusBadRequest, "not valid XML"},
+		{"/customers", "text/plain", "Huber Dora", http.StatusUnsupportedMediaType, "Content-Type must be"},
+		{"/customers/bulk", "application/xml",
+			"<customers><customer><name>Huber Emil</name><email>emil@huber.de</email></customer>" +
+				"<customer><name>Huber Frida</name><email>frida@huber.de</email></customer></customers>",
+			http.StatusOK, `"succeeded":2`},
+		{"/customers/bulk", "text/csv", "name,email\nHuber Gustav,gustav@huber.de\n", http.StatusOK, `"succeeded":1`},
+		{"/customers", "text/csv", "name,role,email,phone\n'=Huber Hans,\"'\trechnen\",hans@huber.de,+49 171 5551234\n",
+			http.StatusCreated, `"name":"=Huber Hans","role":"\trechnen","email":"hans@huber.de","phone":"+49 171 5551234"`},
+		{"/customers", "text/csv", "name,role,email,phone\nHuber Ida,'+SUM(A1),ida@huber.de,'+49 171 5554321\n",
+			http.StatusCreated, `"name":"Huber Ida","role":"+SUM(A1)","email":"ida@huber.de","phone":"+49 171 5554321"`},
+	}
+	for _, request := range requests {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", request.path, strings.NewReader(request.body))
+		req.Header.Set("Content-Type", request.contentType)
+		router.ServeHTTP(rr, req)
+		if rr.Code != request.wantStatus {
+			t.Errorf("POST %s as %s returned wrong status code: got %v want %v", request.path, request.contentType, rr.Code, request.wantStatus)
+		}
+		if !strings.Contains(rr.Body.String(), request.wantBody) {
+			t.Errorf("POST %s as %s returned wrong body: got %q want it to contain %q", request.path, request.contentType, rr.Body.String(), request.wantBody)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/customers?name=huber+hans", nil)
+	req.Header.Set("Accept", "text/csv")
+	router.ServeHTTP(rr, req)
+	if want := ",'=Huber Hans,'\trechnen,hans@huber.de,+49 171 5551234,"; !strings.Contains(rr.Body.String(), want) {
+		t.Errorf("GET /customers as CSV did not escape formulas: got %q want it to contain %q", rr.Body.String(), want)
+	}
+
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/customers?name=huber+ida", nil)
+	req.Header.Set("Accept", "text/csv")
+	router.ServeHTTP(rr, req)
+	if want := ",Huber Ida,'+SUM(A1),ida@huber.de,+49 171 5554321,"; !strings.Contains(rr.Body.String(), want) {
+		t.Errorf("GET /customers as CSV did not escape formulas: got %q want it to contain %q", rr.Body.String(), want)
+	}
+}
+
+// blockingStore is a store whose first Create waits until release is closed
+type blockingStore struct {
+	persistence.Store
+	started, release chan struct{}
+}
+
+func (s blockingStore) Create(ctx context.Context, customer api.Customer) (int, error) {
+	select {
+	case <-s.started:
+	default:
+		close(s.started)
+		<-s.release
+	}
+	return s.Store.Create(ctx, customer)
+}
+
+// Tests that retries of POST /customers with the same Idempotency-Key are answered without creating duplicates
+func TestIdempotentAddCustomer(t *testing.T) {
+	store := blockingStore{newTestStore(t), make(chan struct{}), make(chan struct{})}
+	router := newRouter(store, time.Hour, time.Hour)
+	post := func(key, actor, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/customers", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", key)
+		req.Header.Set("X-Actor", actor)
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	anna := `{"name": "Huber Anna", "email": "anna@huber.de"}`
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- post("k1", "app", anna) }()
+	<-store.started
+	if rr := post("k1", "app", anna); rr.Code != http.StatusConflict {
+		t.Errorf("retry of a running request returned wrong status code: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	close(store.release)
+	created := <-first
+	if created.Code != http.StatusCreated {
+		t.Fatalf("addCustomer returned wrong status code: got %v want %v", created.Code, http.StatusCreated)
+	}
+
+	retried := post("k1", "app", anna)
+	if retried.Code != http.StatusCreated || retried.Body.String() != created.Body.String() ||
+		retried.Header().Get("ETag") != created.Header().Get("ETag") || retried.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry was not answered with the first response: got %v %v %q", retried.Code, retried.Header(), retried.Body.String())
+	}
+
+	rr := post("k1", "app", `{"name": "Huber Berta", "email": "berta@huber.de"}`)
+	var problem api.Problem
+	if err := json.NewDecoder(rr.Body).Decode(&problem); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusUnprocessableEntity || problem.Type != "/problems/idempotency-key-reused" {
+		t.Errorf("reused key returned wrong response: got %v %+v", rr.Code, problem)
+	}
+	req := httptest.NewRequest("POST", "/customers", strings.NewReader(anna))
+	req.Header.Set("Idempotency-Key", "k1")
+	req.Header.Set("X-Actor", "app")
+	req.Header.Set("Accept", "text/csv")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("retry accepting another format returned wrong status code: got %v want %v", rr.Code, http.StatusUnprocessableEntity)
+	}
+	if rr := post("k1", "other app", `{"name": "Huber Berta", "email": "berta@huber.de"}`); rr.Code != http.StatusCreated {
+		t.Errorf("key of another actor returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+	if rr := post("k2", "app", `{"name": "Huber Carl"}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("invalid customer returned wrong status code: got %v want %v", rr.Code, http.StatusUnprocessableEntity)
+	}
+	if rr := post("k2", "app", `{"name": "Huber Carl"}`); rr.Code != http.StatusUnprocessableEntity || rr.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry of an invalid customer was not replayed: got %v %v", rr.Code, rr.Header())
+	}
+	if rr := post(strings.Repeat("k", 256), "app", anna); rr.Code != http.StatusBadRequest {
+		t.Errorf("overlong key returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	page, err := store.List(context.Background(), persistence.ListOptions{Filter: persistence.CustomerFilter{Name: "Huber"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 2 {
+		t.Errorf("retries created wrong number of customers: got %v want %v", page.Total, 2)
+	}
+}
+
+// completeFailingStore is a store that cannot keep the responses to idempotent requests
+type completeFailingStore struct {
+	persistence.Store
+}
+
+func (s completeFailingStore) CompleteKey(ctx context.Context, key string, response persistence.StoredResponse) error {
+	return errors.New("disk full")
+}
+
+// Tests that an Idempotency-Key is released when its response cannot be stored or the handler panics
+func TestIdempotencyReleasesKey(t *testing.T) {
+	router := newRouter(completeFailingStore{newTestStore(t)}, time.Hour, time.Hour)
+	for i := range 2 {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/customers", strings.NewReader(`{"name": "Huber Anna", "email": "anna@huber.de"}`))
+		req.Header.Set("Idempotency-Key", "k1")
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusCreated || rr.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("request %d after failing to store the response returned wrong response: got %v %v", i+1, rr.Code, rr.Header())
+		}
+	}
+
+	store := newTestStore(t)
+	panicking := handlerApp.Idempotency(store, time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	func() {
+		defer func() { recover() }()
+		req := httptest.NewRequest("POST", "/customers", strings.NewReader("{}"))
+		req.Header.Set("Idempotency-Key", "k1")
+		panicking.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	if _, reserved, err := store.ReserveKey(context.Background(), "k1", "", time.Hour, time.Minute); err != nil || !reserved {
+		t.Errorf("key of a panicking request was not released: got %v, %v", reserved, err)
+	}
+}
+
+// Tests PATCH /customers/{id} with JSON Merge Patch and JSON Patch documents
+func TestPatchCustomer(t *testing.T) {
+	store := persistence.NewMemoryStore()
+	id, err := store.Create(context.Background(), api.Customer{Name: "Bauer Klaus", Role: "Farmer", Email: "klaus.bauer@farm.de"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newRouter(store, time.Hour, time.Hour)
+	path := fmt.Sprintf("/customers/%d", id)
+
+	steps := []struct {
+		contentType string
+		body        string
+		want        int
+	}{
+		{"application/merge-patch+json", `{"contacted": true, "phone": "01234 567890"}`, http.StatusOK},
+		{"application/merge-patch+json; charset=utf-8", `{"phone": null}`, http.StatusOK},
+		{"application/json-patch+json", `[{"op": "test", "path": "/role", "value": "Farmer"}, {"op": "replace", "path": "/role", "value": "Owner"}]`, http.StatusOK},
+		{"application/json-patch+json", `[{"op": "copy", "from": "/role", "path": "/phone"}]`, http.StatusUnprocessableEntity},
+		{"application/json-patch+json", `[{"op": "move", "from": "/email", "path": "/phone"}]`, http.StatusUnprocessableEntity},
+		{"application/json-patch+json", `[{"op": "add", "path": "/phone", "value": "+49 1234 567890"}]`, http.StatusOK},
+		{"application/json-patch+json", `[{"op": "test", "path": "/role", "value": "Farmer"}]`, http.StatusConflict},
+		{"application/json-patch+json", `[{"op": "remove", "path": "/nickname"}]`, http.StatusUnprocessableEntity},
+		{"application/json-patch+json", `[{"op": "replace", "path": "/id", "value": 42}]`, http.StatusUnprocessableEntity},
+		{"application/merge-patch+json", `{"contacted": "yes"}`, http.StatusUnprocessableEntity},
+		{"application/json-patch+json", `[{"op": "jump", "path": "/name"}]`, http.StatusBadRequest},
+		{"application/merge-patch+json", `{"contacted": `, http.StatusBadRequest},
+		{"application/json", `{"contacted": false}`, http.StatusUnsupportedMediaType},
+	}
+	for _, step := range steps {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("PATCH", path, strings.NewReader(step.body))
+		req.Header.Set("Content-Type", step.contentType)
+		req.Header.Set("If-Match", "*")
+		router.ServeHTTP(rr, req)
+		if rr.Code != step.want {
+			t.Errorf("PATCH %s %s returned wrong status code: got %v want %v", step.contentType, step.body, rr.Code, step.want)
+		}
+	}
+
+	customer, err := store.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := api.Customer{ID: &id, Name: "Bauer Klaus", Role: "Owner", Email: "klaus.bauer@farm.de", Phone: "+49 1234 567890",
+		Contacted: true, CreatedAt: customer.CreatedAt, Version: 5}
+	if !reflect.DeepEqual(customer, want) {
+		t.Errorf("patches produced wrong customer: got %+v want %+v", customer, want)
+	}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("PATCH", path, strings.NewReader(`{"contacted": false}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusPreconditionRequired {
+		t.Errorf("PATCH without If-Match returned wrong status code: got %v want %v", rr.Code, http.StatusPreconditionRequired)
+	}
+}
+
+// Tests that invalid customers are rejected with 422 and a list of field errors
+func TestCustomerValidation(t *testing.T) {
+	store := persistence.NewMemoryStore()
+	id, err := store.Create(context.Background(), api.Customer{Name: "Bauer Klaus", Email: "klaus.bauer@farm.de"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newRouter(store, time.Hour, time.Hour)
+
+	requests := []struct {
+		method, path, contentType, body string
+		wantFields                      []string
+	}{
+		{"POST", "/customers", "application/json", `{}`, []string{"name", "email"}},
+		{"POST", "/customers", "application/json",
+			`{"name": "Bauer Klaus", "email": "Klaus <klaus@farm.de>", "phone": "0123 abc"}`, []string{"email", "phone"}},
+		{"POST", "/customers", "application/json",
+			`{"name": "` + strings.Repeat("x", api.MaxNameLength+1) + `", "email": "klaus@farm", "phone": "+49 12"}`,
+			[]string{"name", "email", "phone"}},
+		{"PUT", fmt.Sprintf("/customers/%d", id), "application/json", `{"name": " ", "email": "klaus.bauer@farm.de"}`, []string{"name"}},
+		{"PATCH", fmt.Sprintf("/customers/%d", id), "application/merge-patch+json", `{"email": "not an email"}`, []string{"email"}},
+	}
+	for _, request := range requests {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(request.method, request.path, strings.NewReader(request.body))
+		req.Header.Set("Content-Type", request.contentType)
+		req.Header.Set("If-Match", "*")
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s %s returned wrong status code: got %v want %v", request.method, request.body, rr.Code, http.StatusUnprocessableEntity)
+			continue
+		}
+
+		var body api.Problem
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		var fields []string
+		for _, fieldError := range body.Errors {
+			fields = append(fields, fieldError.Field)
+		}
+		if !reflect.DeepEqual(fields, request.wantFields) {
+			t.Errorf("%s %s returned wrong field errors: got %v want %v", request.method, request.body, body.Errors, request.wantFields)
+		}
+	}
+
+	// Limits count characters, so umlauts taking two bytes fit as often as the limit says.
+	email := strings.Repeat("ü", api.MaxEmailLength-10) + "@müller.de"
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/customers", strings.NewReader(`{"name": "Müller Ülrike", "email": "`+email+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Errorf("POST with an email of %d characters returned wrong status code: got %v want %v", api.MaxEmailLength, rr.Code, http.StatusCreated)
+	}
+
+	customer, err := store.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if customer.Version != 1 {
+		t.Errorf("rejected writes changed the customer: got version %v want %v", customer.Version, 1)
+	}
+}
+
+// failingStore is a store whose List fails with a database error
+type failingStore struct {
+	persistence.Store
+}
+
+func (failingStore) List(context.Context, persistence.ListOptions) (persistence.CustomerPage, error) {
+	return persistence.CustomerPage{}, errors.New(`no such table: customer`)
+}
+
+// secondPageFailingStore is a store whose List fails for every page but the first
+type secondPageFailingStore struct {
+	persistence.Store
+}
+
+func (s secondPageFailingStore) List(ctx context.Context, opts persistence.ListOptions) (persistence.CustomerPage, error) {
+	if opts.Cursor != "" {
+		return persistence.CustomerPage{}, errors.New("database is locked")
+	}
+	return s.Store.List(ctx, opts)
+}
+
+// Tests that the vCard export writes each page before reading the next one
+func TestExportVCardsStreamsPages(t *testing.T) {
+	store := newTestStore(t)
+	customers := make([]api.Customer, 1000)
+	for i := range customers {
+		customers[i] = api.Customer{Name: fmt.Sprintf("Kunde %d", i), Email: fmt.Sprintf("kunde%d@farm.de", i)}
+	}
+	if _, err := store.CreateMany(context.Background(), customers, persistence.AllOrNothing); err != nil {
+		t.Fatal(err)
+	}
+	router := newRouter(secondPageFailingStore{store}, time.Hour, time.Hour)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/customers/export.vcf", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("exportVCards returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if count := strings.Count(rr.Body.String(), "BEGIN:VCARD"); count != 1000 {
+		t.Errorf("exportVCards did not write the first page before the failing one: got %v vCards want %v", count, 1000)
+	}
+}
+
+// Tests that errors are answered with RFC 7807 problem details and a request ID
+func TestProblemResponses(t *testing.T) {
+	router := newRouter(failingStore{newTestStore(t)}, time.Hour, time.Hour)
+
+	requests := []struct {
+		method, path, requestID string
+		wantStatus              int
+		wantType                string
+	}{
+		{"GET", "/customers/222", "", http.StatusNotFound, "about:blank"},
+		{"GET", "/customers/abc", "", http.StatusBadRequest, "about:blank"},
+		{"GET", "/nowhere", "", http.StatusNotFound, "about:blank"},
+		{"POST", "/audit", "", http.StatusMethodNotAllowed, "about:blank"},
+		{"PUT", "/customers/1", `"7"`, http.StatusPreconditionFailed, "/problems/version-conflict"},
+		{"GET", "/customers", "", http.StatusInternalServerError, "about:blank"},
+	}
+	for _, request := range requests {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(request.method, request.path,
+			strings.NewReader(`{"name": "Bauer Klaus", "email": "klaus.bauer@farm.de"}`))
+		req.Header.Set("If-Match", request.requestID)
+		req.Header.Set("X-Request-ID", "test-"+request.method)
+		router.ServeHTTP(rr, req)
+
+		if ctype := rr.Header().Get("Content-Type"); ctype != "application/problem+json" {
+			t.Errorf("%s %s returned wrong Content-Type: got %v want %v", request.method, request.path, ctype, "application/problem+json")
+		}
+		var problem api.Problem
+		if err := json.NewDecoder(rr.Body).Decode(&problem); err != nil {
+			t.Fatal(err)
+		}
+		want := api.Problem{Type: request.wantType, Title: http.StatusText(request.wantStatus), Status: request.wantStatus,
+			Detail: problem.Detail, RequestID: "test-" + request.method}
+		if request.wantType != "about:blank" {
+			want.Title = problem.Title
+		}
+		if rr.Code != request.wantStatus || !reflect.DeepEqual(problem, want) {
+			t.Errorf("%s %s returned wrong problem: got %v %+v want %+v", request.method, request.path, rr.Code, problem, want)
+		}
+		if rr.Header().Get("X-Request-ID") != "test-"+request.method {
+			t.Errorf("%s %s did not echo the request ID: got %q", request.method, request.path, rr.Header().Get("X-Request-ID"))
+		}
+		if strings.Contains(problem.Detail, "no such table") {
+			t.Errorf("%s %s leaked the database error: %q", request.method, request.path, problem.Detail)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/customers/1", nil))
+	if id := rr.Header().Get("X-Request-ID"); len(id) != 32 {
+		t.Errorf("getCustomer returned no generated request ID: got %q", id)
+	}
+}
+
+// Tests the bulk endpoints in both modes
+func TestBulkCustomers(t *testing.T) {
+	store := persistence.NewMemoryStore()
+	router := newRouter(store, time.Hour, time.Hour)
+
+	send := func(method, query, body string) (int, api.BulkResponse) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(method, "/customers/bulk"+query, strings.NewReader(body)))
+		var response api.BulkResponse
+		if rr.Code == http.StatusOK || rr.Code == http.StatusMultiStatus {
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rr.Code, response
+	}
+	statuses := func(response api.BulkResponse) []int {
+		var statuses []int
+		for _, result := range response.Results {
+			statuses = append(statuses, result.Status)
+		}
+		return statuses
+	}
+
+	customers := `[{"name": "Bauer Klaus", "email": "klaus.bauer@farm.de"}, {"name": "", "email": "anna"},
+		{"name": "Müller Hans", "email": "hans.mueller@farm.de"}]`
+	code, response := send("POST", "", customers)
+	if code != http.StatusMultiStatus || !reflect.DeepEqual(statuses(response), []int{424, 422, 424}) {
+		t.Errorf("all-or-nothing create returned wrong results: got %v %v", code, statuses(response))
+	}
+	if page, _ := store.List(context.Background(), persistence.ListOptions{}); page.Total != 0 {
+		t.Errorf("all-or-nothing create stored customers: got %v want %v", page.Total, 0)
+	}
+
+	code, response = send("POST", "?mode=best-effort", customers)
+	if code != http.StatusMultiStatus || !reflect.DeepEqual(statuses(response), []int{201, 422, 201}) ||
+		response.Succeeded != 2 || response.Failed != 1 || response.Mode != "best-effort" {
+		t.Errorf("best-effort create returned wrong results: got %v %+v", code, response)
+	}
+	if fields := response.Results[1].Problem.Errors; len(fields) != 2 {
+		t.Errorf("best-effort create returned wrong field errors: got %+v", fields)
+	}
+
+	code, response = send("PUT", "", `[{"id": 1, "version": 1, "name": "Bauer Klaus", "email": "klaus.bauer@farm.de", "contacted": true},
+		{"id": 2, "version": 1, "name": "Müller Hans", "email": "hans.mueller@farm.de", "contacted": true}]`)
+	if code != http.StatusOK || !reflect.DeepEqual(statuses(response), []int{200, 200}) || !response.Results[1].Customer.Contacted {
+		t.Errorf("update returned wrong results: got %v %+v", code, response)
+	}
+
+	code, response = send("DELETE", "?mode=best-effort", `[{"id": 1, "version": 2}, {"id": 2}, {"id": 42, "version": 1}]`)
+	if code != http.StatusMultiStatus || !reflect.DeepEqual(statuses(response), []int{204, 428, 404}) {
+		t.Errorf("delete returned wrong results: got %v %v", code, statuses(response))
+	}
+
+	for _, request := range []struct{ method, query, body string }{
+		{"POST", "?mode=sometimes", customers},
+		{"POST", "", `[]`},
+		{"DELETE", "", `{"id": 1}`},
+	} {
+		if code, _ := send(request.method, request.query, request.body); code != http.StatusBadRequest {
+			t.Errorf("%s %s %s returned wrong status code: got %v want %v", request.method, request.query, request.body, code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"farmApp/pkg/persistence"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+const migrateUsage = `usage: main [options] migrate <command>
+
+commands:
+  status         list migrations and whether they are applied
+  up             apply all pending migrations
+  down [-steps]  roll back the most recent migrations (default 1)`
+
+// runMigrate implements the "migrate" command for inspecting and rolling back the schema.
+func runMigrate(store *persistence.SQLStore, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(migrateUsage)
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "status":
+		status, err := store.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, m := range status {
+			appliedAt := "pending"
+			if m.Requires != "" {
+				appliedAt += " (requires " + m.Requires + ")"
+			}
+			if m.AppliedAt != nil {
+				appliedAt = m.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", m.Version, m.Name, appliedAt)
+		}
+		return w.Flush()
+	case "up":
+		return store.Migrate(ctx)
+	case "down":
+		fs := flag.NewFlagSet("migrate down", flag.ContinueOnError)
+		steps := fs.Int("steps", 1, "number of migrations to roll back")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		return store.Rollback(ctx, *steps)
+	}
+	return fmt.Errorf("unknown migrate command %q\n%s", args[0], migrateUsage)
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Types of an address.
+const (
+	AddressBilling  = "billing"
+	AddressDelivery = "delivery"
+	AddressFarmYard = "farm_yard"
+)
+
+// AddressTypes lists the valid address types.
+var AddressTypes = []string{AddressBilling, AddressDelivery, AddressFarmYard}
+
+// CountryGermany is the country an address is in unless it names another.
+const CountryGermany = "DE"
+
+// Length limits of the address fields, in characters.
+const (
+	MaxStreetLength      = 100
+	MaxHouseNumberLength = 10
+	MaxPostalCodeLength  = 10
+	MaxCityLength        = 100
+)
+
+// Address is a postal address of a customer. A customer can have several
+// addresses, also of the same type.
+type Address struct {
+	ID          int    `json:"id"`
+	CustomerID  int    `json:"customer_id"`
+	Type        string `json:"type"`
+	Street      string `json:"street"`
+	HouseNumber string `json:"house_number"`
+	PostalCode  string `json:"postal_code"`
+	City        string `json:"city"`
+	// Country is the ISO 3166-1 alpha-2 code, e.g. DE.
+	Country string `json:"country"`
+}
+
+// Validate checks the fields a client sets and returns a *ValidationError
+// listing all problems, or nil. German postal codes must have five digits.
+func (a Address) Validate() error {
+	var errs []FieldError
+	check := func(field string, ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+		}
+	}
+
+	if a.Type == "" {
+		check("type", false, "is required")
+	} else {
+		check("type", validAddressType(a.Type), "must be one of %s", strings.Join(AddressTypes, ", "))
+	}
+	check("street", strings.TrimSpace(a.Street) != "", "is required")
+	check("street", utf8.RuneCountInString(a.Street) <= MaxStreetLength, "must be at most %d characters", MaxStreetLength)
+	check("house_number", utf8.RuneCountInString(a.HouseNumber) <= MaxHouseNumberLength,
+		"must be at most %d characters", MaxHouseNumberLength)
+	check("city", strings.TrimSpace(a.City) != "", "is required")
+	check("city", utf8.RuneCountInString(a.City) <= MaxCityLength, "must be at most %d characters", MaxCityLength)
+	check("country", validCountryCode(a.Country), "must be a two-letter ISO 3166-1 code such as DE")
+	switch {
+	case strings.TrimSpace(a.PostalCode) == "":
+		check("postal_code", false, "is required")
+	case a.Country == CountryGermany:
+		check("postal_code", validGermanPostalCode(a.PostalCode), "must be a German postal code of five digits")
+	default:
+		check("postal_code", utf8.RuneCountInString(a.PostalCode) <= MaxPostalCodeLength,
+			"must be at most %d characters", MaxPostalCodeLength)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Message: "address is invalid", Errors: errs}
+}
+
+func validAddressType(addressType string) bool {
+	for _, t := range AddressTypes {
+		if addressType == t {
+			return true
+		}
+	}
+	return false
+}
+
+func validCountryCode(code string) bool {
+	return len(code) == 2 && isUpper(code[0]) && isUpper(code[1])
+}
+
+func isUpper(b byte) bool {
+	return 'A' <= b && b <= 'Z'
+}
+
+// validGermanPostalCode accepts five digits; codes starting with 00 are not
+// assigned.
+func validGermanPostalCode(code string) bool {
+	if len(code) != 5 || strings.HasPrefix(code, "00") {
+		return false
+	}
+	for i := 0; i < len(code); i++ {
+		if code[i] < '0' || code[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,16 @@
+package api
+
+import "time"
+
+// AuditEntry records one change to a customer. Before and After hold the
+// values of the fields that changed; Before is empty for creates and After
+// is empty for deletes.
+type AuditEntry struct {
+	ID         int            `json:"id"`
+	CustomerID int            `json:"customer_id"`
+	Actor      string         `json:"actor"`
+	OccurredAt time.Time      `json:"occurred_at"`
+	Operation  string         `json:"operation"`
+	Before     map[string]any `json:"before,omitempty"`
+	After      map[string]any `json:"after,omitempty"`
+}
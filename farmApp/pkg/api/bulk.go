@@ -0,0 +1,25 @@
+package api
+
+// BulkDeleteItem names a customer to delete and the version the client last saw.
+type BulkDeleteItem struct {
+	ID      int `json:"id"`
+	Version int `json:"version"`
+}
+
+// BulkItemResult is the outcome of one item of a batch request.
+type BulkItemResult struct {
+	// Index is the position of the item in the request.
+	Index int `json:"index"`
+	// Status is the HTTP status the item would have received on its own.
+	Status   int       `json:"status"`
+	Customer *Customer `json:"customer,omitempty"`
+	Problem  *Problem  `json:"problem,omitempty"`
+}
+
+// BulkResponse reports the outcome of every item of a batch request.
+type BulkResponse struct {
+	Mode      string           `json:"mode"`
+	Succeeded int              `json:"succeeded"`
+	Failed    int              `json:"failed"`
+	Results   []BulkItemResult `json:"results"`
+}
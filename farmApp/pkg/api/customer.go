@@ -1,14 +1,41 @@
 package api
 
+import "time"
+
 type Customer struct {
-	ID        *int   `json:"id,omitempty"`
-	Name      string `json:"name"`
-	Role      string `json:"role"`
-	Email     string `json:"email"`
-	Phone     string `json:"phone"`
-	Contacted bool   `json:"contacted"`
+	ID    *int   `json:"id,omitempty"`
+	Name  string `json:"name"`
+	Role  string `json:"role"`
+	Email string `json:"email"`
+	Phone string `json:"phone"`
+	// Contacted is set by clients or by recording an interaction.
+	Contacted bool `json:"contacted"`
+	// LastContactedAt and ContactCount are derived from the recorded
+	// interactions and ignored in requests.
+	LastContactedAt *time.Time `json:"last_contacted_at,omitempty"`
+	ContactCount    int        `json:"contact_count"`
+	CreatedAt       *time.Time `json:"created_at,omitempty"`
+	DeletedAt       *time.Time `json:"deleted_at,omitempty"`
+	// Version is incremented on every change and sent as the ETag.
+	Version int `json:"version"`
+}
+
+// Problem describes an error as RFC 7807 problem details, sent with the
+// media type application/problem+json.
+type Problem struct {
+	// Type is a URI reference identifying the kind of problem; about:blank
+	// means the problem is fully described by its status code.
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// RequestID identifies the request in the server logs; it is also sent
+	// in the X-Request-ID header.
+	RequestID string `json:"request_id,omitempty"`
+	// Errors lists the invalid fields of a validation problem.
+	Errors []FieldError `json:"errors,omitempty"`
 }
 
-type ErrorResponse struct {
-	Message string `json:"message"`
+type PurgeResult struct {
+	Purged int `json:"purged"`
 }
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Types of farm.
+const (
+	FarmTypeArable       = "arable"
+	FarmTypeDairy        = "dairy"
+	FarmTypeLivestock    = "livestock"
+	FarmTypePoultry      = "poultry"
+	FarmTypeHorticulture = "horticulture"
+	FarmTypeMixed        = "mixed"
+)
+
+// FarmTypes lists the valid farm types.
+var FarmTypes = []string{FarmTypeArable, FarmTypeDairy, FarmTypeLivestock, FarmTypePoultry, FarmTypeHorticulture, FarmTypeMixed}
+
+// Limits of the farm fields; lengths are in characters.
+const (
+	MaxAddressLength = 200
+	MaxFarmSize      = 100000
+)
+
+type Farm struct {
+	ID      *int   `json:"id,omitempty"`
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	// SizeHectares is the farmed area in hectares.
+	SizeHectares float64    `json:"size_hectares"`
+	Type         string     `json:"type"`
+	CreatedAt    *time.Time `json:"created_at,omitempty"`
+}
+
+// FarmLink is the body that makes a customer a contact of a farm.
+type FarmLink struct {
+	// Role is what the customer does on this farm, e.g. owner or manager.
+	Role string `json:"role"`
+}
+
+// FarmContact is a customer linked to a farm.
+type FarmContact struct {
+	Role     string   `json:"role"`
+	Customer Customer `json:"customer"`
+}
+
+// CustomerFarm is a farm a customer is linked to.
+type CustomerFarm struct {
+	Role string `json:"role"`
+	Farm Farm   `json:"farm"`
+}
+
+// Validate checks the fields a client sets and returns a *ValidationError
+// listing all problems, or nil. Name and type are required.
+func (f Farm) Validate() error {
+	var errs []FieldError
+	check := func(field string, ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+		}
+	}
+
+	check("name", strings.TrimSpace(f.Name) != "", "is required")
+	check("name", utf8.RuneCountInString(f.Name) <= MaxNameLength, "must be at most %d characters", MaxNameLength)
+	check("address", utf8.RuneCountInString(f.Address) <= MaxAddressLength, "must be at most %d characters", MaxAddressLength)
+	check("size_hectares", f.SizeHectares >= 0 && f.SizeHectares <= MaxFarmSize, "must be between 0 and %d", MaxFarmSize)
+	if f.Type == "" {
+		check("type", false, "is required")
+	} else {
+		check("type", validFarmType(f.Type), "must be one of %s", strings.Join(FarmTypes, ", "))
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Message: "farm is invalid", Errors: errs}
+}
+
+// Validate checks the role and returns a *ValidationError, or nil. The role
+// is required.
+func (l FarmLink) Validate() error {
+	var errs []FieldError
+	if strings.TrimSpace(l.Role) == "" {
+		errs = append(errs, FieldError{Field: "role", Message: "is required"})
+	} else if utf8.RuneCountInString(l.Role) > MaxRoleLength {
+		errs = append(errs, FieldError{Field: "role", Message: fmt.Sprintf("must be at most %d characters", MaxRoleLength)})
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Message: "farm link is invalid", Errors: errs}
+}
+
+func validFarmType(farmType string) bool {
+	for _, t := range FarmTypes {
+		if farmType == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,27 @@
+package api
+
+// ImportRowResult is the outcome of one data row or vCard of an imported
+// file.
+type ImportRowResult struct {
+	// Row is the line of the file the row or vCard starts on, counting the
+	// header.
+	Row int `json:"row"`
+	// Status is the HTTP status the row would have received if it had been
+	// created on its own.
+	Status int `json:"status"`
+	// Customer is the created customer, or in a dry run the customer that
+	// would be created.
+	Customer *Customer `json:"customer,omitempty"`
+	Problem  *Problem  `json:"problem,omitempty"`
+}
+
+// ImportResponse reports the outcome of every data row or vCard of an
+// imported file.
+type ImportResponse struct {
+	// DryRun is set if nothing was stored.
+	DryRun    bool              `json:"dry_run"`
+	Mode      string            `json:"mode"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Rows      []ImportRowResult `json:"rows"`
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Channels of an interaction.
+const (
+	ChannelCall    = "call"
+	ChannelEmail   = "email"
+	ChannelVisit   = "visit"
+	ChannelMeeting = "meeting"
+)
+
+// Channels lists the valid interaction channels.
+var Channels = []string{ChannelCall, ChannelEmail, ChannelVisit, ChannelMeeting}
+
+// Length limits of the interaction fields, in characters.
+const (
+	MaxAuthorLength = 100
+	MaxNotesLength  = 2000
+)
+
+// Interaction records one contact with a customer.
+type Interaction struct {
+	ID         int    `json:"id"`
+	CustomerID int    `json:"customer_id"`
+	Channel    string `json:"channel"`
+	// OccurredAt is when the customer was contacted; it defaults to the
+	// time the interaction is recorded.
+	OccurredAt time.Time `json:"occurred_at"`
+	// Author is who contacted the customer; it defaults to the X-Actor of
+	// the request recording it.
+	Author string `json:"author"`
+	Notes  string `json:"notes"`
+}
+
+// Validate checks the fields a client sets and returns a *ValidationError
+// listing all problems, or nil. The channel is required.
+func (i Interaction) Validate() error {
+	var errs []FieldError
+	check := func(field string, ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+		}
+	}
+
+	if i.Channel == "" {
+		check("channel", false, "is required")
+	} else {
+		check("channel", validChannel(i.Channel), "must be one of %s", strings.Join(Channels, ", "))
+	}
+	check("author", strings.TrimSpace(i.Author) != "", "is required")
+	check("author", utf8.RuneCountInString(i.Author) <= MaxAuthorLength, "must be at most %d characters", MaxAuthorLength)
+	check("notes", utf8.RuneCountInString(i.Notes) <= MaxNotesLength, "must be at most %d characters", MaxNotesLength)
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Message: "interaction is invalid", Errors: errs}
+}
+
+func validChannel(channel string) bool {
+	for _, c := range Channels {
+		if channel == c {
+			return true
+		}
+	}
+	return false
+}
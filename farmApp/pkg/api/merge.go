@@ -0,0 +1,29 @@
+package api
+
+// DuplicatePair is two customers that are likely the same person or
+// household.
+type DuplicatePair struct {
+	Customers []Customer `json:"customers"`
+	// Score is the likelihood of a duplicate, from 0 to 1.
+	Score float64 `json:"score"`
+	// Reasons explains the score, such as "same email".
+	Reasons []string `json:"reasons"`
+}
+
+// Values of MergeRequest.Fields.
+const (
+	MergeKeepTarget = "target"
+	MergeKeepSource = "source"
+)
+
+// MergeRequest combines the source customer into the target named in the
+// path.
+type MergeRequest struct {
+	SourceID int `json:"source_id"`
+	// SourceVersion is the version the source customer was read with.
+	SourceVersion int `json:"source_version"`
+	// Fields chooses for name, role, email, phone and contacted whether the
+	// value of the target or the source survives. Fields that are not listed
+	// keep the target's value, or take the source's if the target's is empty.
+	Fields map[string]string `json:"fields,omitempty"`
+}
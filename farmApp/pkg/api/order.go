@@ -0,0 +1,109 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Order statuses. An order is pending until it is confirmed, and can be
+// cancelled until it is shipped.
+const (
+	StatusPending   = "pending"
+	StatusConfirmed = "confirmed"
+	StatusShipped   = "shipped"
+	StatusDelivered = "delivered"
+	StatusCancelled = "cancelled"
+)
+
+// Statuses lists the valid order statuses.
+var Statuses = []string{StatusPending, StatusConfirmed, StatusShipped, StatusDelivered, StatusCancelled}
+
+// statusTransitions lists the statuses each status can change to.
+var statusTransitions = map[string][]string{
+	StatusPending:   {StatusConfirmed, StatusCancelled},
+	StatusConfirmed: {StatusShipped, StatusCancelled},
+	StatusShipped:   {StatusDelivered},
+}
+
+// Limits of an order.
+const (
+	MaxOrderLines = 100
+	MaxQuantity   = 100000
+)
+
+type Order struct {
+	ID         *int        `json:"id,omitempty"`
+	CustomerID int         `json:"customer_id"`
+	Status     string      `json:"status"`
+	Lines      []OrderLine `json:"lines"`
+	// TotalCents is the sum of the line totals.
+	TotalCents int64      `json:"total_cents"`
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+}
+
+// OrderLine is a quantity of one product. The product name and unit price
+// are copied from the catalog whenever the lines of an order are stored, so
+// later changes to the catalog do not change the order; they are ignored in
+// requests.
+type OrderLine struct {
+	ProductID      int    `json:"product_id"`
+	ProductName    string `json:"product_name"`
+	Quantity       int    `json:"quantity"`
+	UnitPriceCents int64  `json:"unit_price_cents"`
+	TotalCents     int64  `json:"total_cents"`
+}
+
+// CanChangeStatus reports whether an order with status from may change to
+// status to. Keeping the status is always allowed.
+func CanChangeStatus(from, to string) bool {
+	if from == to {
+		return true
+	}
+	for _, status := range statusTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the fields a client sets and returns a *ValidationError
+// listing all problems, or nil. The customer and at least one line are
+// required.
+func (o Order) Validate() error {
+	var errs []FieldError
+	check := func(field string, ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+		}
+	}
+
+	check("customer_id", o.CustomerID > 0, "is required")
+	check("status", validStatus(o.Status), "must be one of %s", strings.Join(Statuses, ", "))
+	check("lines", len(o.Lines) > 0, "must contain at least one line")
+	check("lines", len(o.Lines) <= MaxOrderLines, "must contain at most %d lines", MaxOrderLines)
+	seen := map[int]bool{}
+	for i, line := range o.Lines {
+		field := fmt.Sprintf("lines[%d].", i)
+		check(field+"product_id", line.ProductID > 0, "is required")
+		check(field+"product_id", line.ProductID <= 0 || !seen[line.ProductID], "must not repeat another line's product")
+		check(field+"quantity", line.Quantity >= 1 && line.Quantity <= MaxQuantity, "must be between 1 and %d", MaxQuantity)
+		seen[line.ProductID] = true
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Message: "order is invalid", Errors: errs}
+}
+
+func validStatus(status string) bool {
+	for _, s := range Statuses {
+		if status == s {
+			return true
+		}
+	}
+	return false
+}
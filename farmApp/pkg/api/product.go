@@ -0,0 +1,74 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Product categories.
+const (
+	CategorySeed       = "seed"
+	CategoryFeed       = "feed"
+	CategoryFertilizer = "fertilizer"
+	CategoryEquipment  = "equipment"
+	CategoryOther      = "other"
+)
+
+// Categories lists the valid product categories.
+var Categories = []string{CategorySeed, CategoryFeed, CategoryFertilizer, CategoryEquipment, CategoryOther}
+
+// Limits of the product fields; lengths are in characters.
+const (
+	MaxUnitLength = 20
+	// MaxPriceCents is the highest unit price, 1 million euros.
+	MaxPriceCents = 100_000_000
+)
+
+type Product struct {
+	ID       *int   `json:"id,omitempty"`
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	// Unit is what one item is sold as, e.g. "25 kg bag" or "piece".
+	Unit string `json:"unit"`
+	// PriceCents is the current price of one unit in euro cents.
+	PriceCents int64      `json:"price_cents"`
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+}
+
+// Validate checks the fields a client sets and returns a *ValidationError
+// listing all problems, or nil. Name, category and unit are required.
+func (p Product) Validate() error {
+	var errs []FieldError
+	check := func(field string, ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+		}
+	}
+
+	check("name", strings.TrimSpace(p.Name) != "", "is required")
+	check("name", utf8.RuneCountInString(p.Name) <= MaxNameLength, "must be at most %d characters", MaxNameLength)
+	if p.Category == "" {
+		check("category", false, "is required")
+	} else {
+		check("category", validCategory(p.Category), "must be one of %s", strings.Join(Categories, ", "))
+	}
+	check("unit", strings.TrimSpace(p.Unit) != "", "is required")
+	check("unit", utf8.RuneCountInString(p.Unit) <= MaxUnitLength, "must be at most %d characters", MaxUnitLength)
+	check("price_cents", p.PriceCents >= 0 && p.PriceCents <= MaxPriceCents, "must be between 0 and %d", MaxPriceCents)
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Message: "product is invalid", Errors: errs}
+}
+
+func validCategory(category string) bool {
+	for _, c := range Categories {
+		if category == c {
+			return true
+		}
+	}
+	return false
+}
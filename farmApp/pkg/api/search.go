@@ -0,0 +1,12 @@
+package api
+
+// SearchResult is one customer found by a full-text search.
+type SearchResult struct {
+	Customer Customer `json:"customer"`
+	// Score ranks the results of one search, higher is better. Scores of
+	// different searches cannot be compared.
+	Score float64 `json:"score"`
+	// Highlights holds the fields that matched as HTML, with the matching
+	// words wrapped in <mark>.
+	Highlights map[string]string `json:"highlights"`
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxTagLength is the maximum length of a tag name, in characters.
+const MaxTagLength = 50
+
+// Tag labels customers, e.g. "organic" or "Eifel region". Names are unique
+// ignoring case.
+type Tag struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// CustomerCount is the number of customers with the tag, leaving out
+	// those in the trash.
+	CustomerCount int `json:"customer_count"`
+}
+
+// TagMergeRequest is the body of a tag merge.
+type TagMergeRequest struct {
+	// SourceID is the tag that is merged into the one in the path and then
+	// deleted.
+	SourceID int `json:"source_id"`
+}
+
+// Validate checks the name and returns a *ValidationError listing all
+// problems, or nil. Commas are not allowed, since the tag filters of the
+// customer listing separate names with them.
+func (t Tag) Validate() error {
+	var errs []FieldError
+	check := func(field string, ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+		}
+	}
+
+	check("name", strings.TrimSpace(t.Name) != "", "is required")
+	check("name", utf8.RuneCountInString(t.Name) <= MaxTagLength, "must be at most %d characters", MaxTagLength)
+	check("name", !strings.Contains(t.Name, ","), "must not contain commas")
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Message: "tag is invalid", Errors: errs}
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Length limits of the customer fields, in characters.
+const (
+	MaxNameLength  = 100
+	MaxRoleLength  = 50
+	MaxEmailLength = 254
+	MaxPhoneLength = 30
+)
+
+// phonePattern allows an optional leading + followed by digits grouped
+// with spaces, slashes, dashes and parentheses, e.g. "+49 (0)1234 567-890".
+var phonePattern = regexp.MustCompile(`^\+?[0-9() /-]+$`)
+
+// FieldError describes why one field of a request body was rejected.
+type FieldError struct {
+	// Field is the JSON name of the field.
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid field of a request body.
+type ValidationError struct {
+	Message string       `json:"message"`
+	Errors  []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, fieldError := range e.Errors {
+		messages[i] = fieldError.Field + ": " + fieldError.Message
+	}
+	return e.Message + ": " + strings.Join(messages, "; ")
+}
+
+// Validate checks the fields a client sets and returns a *ValidationError
+// listing all problems, or nil. Name and email are required.
+func (c Customer) Validate() error {
+	var errs []FieldError
+	check := func(field string, ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+		}
+	}
+
+	check("name", strings.TrimSpace(c.Name) != "", "is required")
+	check("name", utf8.RuneCountInString(c.Name) <= MaxNameLength, "must be at most %d characters", MaxNameLength)
+	check("role", utf8.RuneCountInString(c.Role) <= MaxRoleLength, "must be at most %d characters", MaxRoleLength)
+
+	if strings.TrimSpace(c.Email) == "" {
+		check("email", false, "is required")
+	} else {
+		check("email", validEmail(c.Email), "must be an email address such as name@example.com")
+		check("email", utf8.RuneCountInString(c.Email) <= MaxEmailLength, "must be at most %d characters", MaxEmailLength)
+	}
+
+	if c.Phone != "" {
+		check("phone", validPhone(c.Phone), "must contain 5 to 15 digits, optionally grouped with spaces, /, - or () and starting with +")
+		check("phone", utf8.RuneCountInString(c.Phone) <= MaxPhoneLength, "must be at most %d characters", MaxPhoneLength)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Message: "customer is invalid", Errors: errs}
+}
+
+// validEmail accepts a bare address with a dotted domain, without a display name.
+func validEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return false
+	}
+	_, domain, _ := strings.Cut(email, "@")
+	return strings.Contains(domain, ".") && !strings.HasPrefix(domain, ".") && !strings.HasSuffix(domain, ".")
+}
+
+func validPhone(phone string) bool {
+	digits := 0
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+	return phonePattern.MatchString(phone) && digits >= 5 && digits <= 15
+}
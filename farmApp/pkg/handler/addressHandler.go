@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"farmApp/pkg/api"
+	"farmApp/pkg/persistence"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// AddressHandler serves the postal addresses of customers.
+type AddressHandler struct {
+	repo persistence.AddressRepository
+}
+
+func NewAddressHandler(repo persistence.AddressRepository) *AddressHandler {
+	return &AddressHandler{repo: repo}
+}
+
+// @Summary Get the addresses of a customer
+// @Description Get the billing, delivery and farm yard addresses of a customer, ordered by type
+// @Tags addresses
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Customer ID"
+// @Success 200 {array} api.Address
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /customers/{id}/addresses [get]
+func (h *AddressHandler) GetAddresses(w http.ResponseWriter, r *http.Request) {
+	customerID, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	addresses, err := h.repo.ListAddresses(r.Context(), customerID)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	encodeResponse(w, r, addresses)
+}
+
+// @Summary Get an address of a customer
+// @Description Get an address of a customer
+// @Tags addresses
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Customer ID"
+// @Param addressId path int true "Address ID"
+// @Success 200 {object} api.Address
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /customers/{id}/addresses/{addressId} [get]
+func (h *AddressHandler) GetAddress(w http.ResponseWriter, r *http.Request) {
+	customerID, id, err := addressIDs(r)
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	address, err := h.repo.GetAddress(r.Context(), customerID, id)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	encodeResponse(w, r, address)
+}
+
+// @Summary Add an address to a customer
+// @Description Add a billing, delivery or farm yard address. country defaults to DE; German postal codes must have five digits. The customer's version does not change.
+// @Tags addresses
+// @Accept json,xml,application/yaml,text/csv
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Customer ID"
+// @Param address body api.Address true "Address"
+// @Success 201 {object} api.Address
+// @Header 201 {string} Location "URL of the address"
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 422 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /customers/{id}/addresses [post]
+func (h *AddressHandler) AddAddress(w http.ResponseWriter, r *http.Request) {
+	customerID, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	address, ok := decodeAddress(w, r)
+	if !ok {
+		return
+	}
+
+	address, err = h.repo.AddAddress(r.Context(), customerID, address)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/customers/"+strconv.Itoa(customerID)+"/addresses/"+strconv.Itoa(address.ID))
+	writeResponse(w, r, http.StatusCreated, address)
+}
+
+// @Summary Update an address of a customer
+// @Description Replace an address of a customer. country defaults to DE; German postal codes must have five digits.
+// @Tags addresses
+// @Accept json,xml,application/yaml,text/csv
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Customer ID"
+// @Param addressId path int true "Address ID"
+// @Param address body api.Address true "Address"
+// @Success 200 {object} api.Address
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 422 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /customers/{id}/addresses/{addressId} [put]
+func (h *AddressHandler) UpdateAddress(w http.ResponseWriter, r *http.Request) {
+	customerID, id, err := addressIDs(r)
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	address, ok := decodeAddress(w, r)
+	if !ok {
+		return
+	}
+
+	address, err = h.repo.UpdateAddress(r.Context(), customerID, id, address)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	encodeResponse(w, r, address)
+}
+
+// @Summary Delete an address of a customer
+// @Description Delete an address of a customer
+// @Tags addresses
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Customer ID"
+// @Param addressId path int true "Address ID"
+// @Success 204
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /customers/{id}/addresses/{addressId} [delete]
+func (h *AddressHandler) DeleteAddress(w http.ResponseWriter, r *http.Request) {
+	customerID, id, err := addressIDs(r)
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.DeleteAddress(r.Context(), customerID, id); err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeAddress reads and validates the address in the request body,
+// normalizing the type, country and postal code first. It writes the error
+// response and returns false if the address cannot be used.
+func decodeAddress(w http.ResponseWriter, r *http.Request) (api.Address, bool) {
+	var address api.Address
+	if err := decodeBody(r, &address); err != nil {
+		handleBodyError(w, r, err)
+		return address, false
+	}
+	address.Type = strings.ToLower(address.Type)
+	address.Country = strings.ToUpper(strings.TrimSpace(address.Country))
+	if address.Country == "" {
+		address.Country = api.CountryGermany
+	}
+	address.PostalCode = strings.TrimSpace(address.PostalCode)
+	if err := address.Validate(); err != nil {
+		handleRepositoryError(w, r, err)
+		return address, false
+	}
+	return address, true
+}
+
+// addressIDs reads the customer and address IDs from the path.
+func addressIDs(r *http.Request) (customerID, id int, err error) {
+	if customerID, err = pathID(r, "id"); err != nil {
+		return 0, 0, err
+	}
+	id, err = pathID(r, "addressId")
+	return customerID, id, err
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"errors"
+	"farmApp/pkg/persistence"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// AuditHandler serves the change history recorded by the repositories.
+type AuditHandler struct {
+	repo persistence.AuditRepository
+}
+
+func NewAuditHandler(repo persistence.AuditRepository) *AuditHandler {
+	return &AuditHandler{repo: repo}
+}
+
+// Actor is a middleware that records the X-Actor request header as the
+// author of any change made by the request.
+func Actor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := r.Header.Get("X-Actor")
+		if actor == "" {
+			actor = "anonymous"
+		}
+		next.ServeHTTP(w, r.WithContext(persistence.WithActor(r.Context(), actor)))
+	})
+}
+
+// @Summary Get the change history of a customer
+// @Description Get every recorded change to a customer, newest first
+// @Tags audit
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Customer ID"
+// @Success 200 {array} api.AuditEntry
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /customers/{id}/history [get]
+func (h *AuditHandler) GetCustomerHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	entries, err := h.repo.History(r.Context(), id)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	encodeResponse(w, r, entries)
+}
+
+// @Summary Get the audit log
+// @Description Get recorded customer changes, newest first
+// @Tags audit
+// @Produce json,xml,application/yaml,text/csv
+// @Param customer_id query int false "Only changes to this customer"
+// @Param actor query string false "Only changes by this actor"
+// @Param operation query string false "Only this operation" Enums(create, update, delete, restore, purge, merge)
+// @Param since query string false "Only changes at or after this RFC 3339 time"
+// @Param until query string false "Only changes before this RFC 3339 time"
+// @Param limit query int false "Maximum number of entries (default 100, at most 1000)"
+// @Success 200 {array} api.AuditEntry
+// @Failure 400 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /audit [get]
+func (h *AuditHandler) GetAudit(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	entries, err := h.repo.ListAudit(r.Context(), filter)
+	if err != nil {
+		handleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	encodeResponse(w, r, entries)
+}
+
+func parseAuditFilter(r *http.Request) (persistence.AuditFilter, error) {
+	query := r.URL.Query()
+	filter := persistence.AuditFilter{
+		Actor:     query.Get("actor"),
+		Operation: query.Get("operation"),
+		Limit:     defaultAuditLimit,
+	}
+
+	var err error
+	if value := query.Get("customer_id"); value != "" {
+		if filter.CustomerID, err = strconv.Atoi(value); err != nil {
+			return filter, errors.New("customer_id must be an integer")
+		}
+	}
+	if value := query.Get("since"); value != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, value); err != nil {
+			return filter, errors.New("since must be an RFC 3339 time")
+		}
+	}
+	if value := query.Get("until"); value != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, value); err != nil {
+			return filter, errors.New("until must be an RFC 3339 time")
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit < 1 || filter.Limit > maxAuditLimit {
+			return filter, errors.New("limit must be between 1 and 1000")
+		}
+	}
+	return filter, nil
+}
@@ -0,0 +1,247 @@
+package handler
+
+import (
+	"errors"
+	"farmApp/pkg/api"
+	"farmApp/pkg/persistence"
+	"fmt"
+	"net/http"
+)
+
+// maxBulkItems limits the size of a batch request.
+const maxBulkItems = 1000
+
+// Values of the mode query parameter.
+const (
+	allOrNothingMode = "all-or-nothing"
+	bestEffortMode   = "best-effort"
+)
+
+// BulkHandler serves the batch endpoints for customers.
+type BulkHandler struct {
+	repo persistence.BulkRepository
+}
+
+func NewBulkHandler(repo persistence.BulkRepository) *BulkHandler {
+	return &BulkHandler{repo: repo}
+}
+
+// @Summary Create customers in bulk
+// @Description Create up to 1000 customers in one transaction. In all-or-nothing mode (the default) no customer is created if any of them fails; in best-effort mode the valid ones are kept. The response lists the outcome of every item in request order.
+// @Tags customers
+// @Accept json,xml,application/yaml,text/csv
+// @Produce json,xml,application/yaml,text/csv
+// @Param mode query string false "How failures are handled" Enums(all-or-nothing, best-effort)
+// @Param customers body []api.Customer true "Customers"
+// @Success 200 {object} api.BulkResponse "Every item succeeded"
+// @Success 207 {object} api.BulkResponse "Some items failed"
+// @Failure 400 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /customers/bulk [post]
+func (h *BulkHandler) CreateCustomers(w http.ResponseWriter, r *http.Request) {
+	mode, customers, err := parseBulkRequest[api.Customer](r)
+	if err != nil {
+		handleBodyError(w, r, err)
+		return
+	}
+
+	problems := make([]error, len(customers))
+	for i, customer := range customers {
+		problems[i] = customer.Validate()
+	}
+	h.run(w, r, mode, http.StatusCreated, problems, func(valid []int) ([]persistence.BulkResult, error) {
+		batch := make([]api.Customer, len(valid))
+		for i, index := range valid {
+			batch[i] = customers[index]
+		}
+		return h.repo.CreateMany(r.Context(), batch, mode)
+	})
+}
+
+// @Summary Update customers in bulk
+// @Description Replace up to 1000 customers in one transaction. Every customer needs its id and the version it was read with, which must still be current. Modes and results are as for bulk creation.
+// @Tags customers
+// @Accept json,xml,application/yaml,text/csv
+// @Produce json,xml,application/yaml,text/csv
+// @Param mode query string false "How failures are handled" Enums(all-or-nothing, best-effort)
+// @Param customers body []api.Customer true "Customers with id and version"
+// @Success 200 {object} api.BulkResponse "Every item succeeded"
+// @Success 207 {object} api.BulkResponse "Some items failed"
+// @Failure 400 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /customers/bulk [put]
+func (h *BulkHandler) UpdateCustomers(w http.ResponseWriter, r *http.Request) {
+	mode, customers, err := parseBulkRequest[api.Customer](r)
+	if err != nil {
+		handleBodyError(w, r, err)
+		return
+	}
+
+	problems := make([]error, len(customers))
+	for i, customer := range customers {
+		switch {
+		case customer.ID == nil:
+			problems[i] = errMissingID
+		case customer.Version < 1:
+			problems[i] = errMissingVersion
+		default:
+			problems[i] = customer.Validate()
+		}
+	}
+	h.run(w, r, mode, http.StatusOK, problems, func(valid []int) ([]persistence.BulkResult, error) {
+		batch := make([]persistence.BulkUpdate, len(valid))
+		for i, index := range valid {
+			customer := customers[index]
+			batch[i] = persistence.BulkUpdate{ID: *customer.ID, Version: customer.Version, Customer: customer}
+		}
+		return h.repo.UpdateMany(r.Context(), batch, mode)
+	})
+}
+
+// @Summary Delete customers in bulk
+// @Description Move up to 1000 customers to the trash in one transaction. Every item needs the id and the version it was read with, which must still be current. Modes and results are as for bulk creation.
+// @Tags customers
+// @Accept json,xml,application/yaml,text/csv
+// @Produce json,xml,application/yaml,text/csv
+// @Param mode query string false "How failures are handled" Enums(all-or-nothing, best-effort)
+// @Param customers body []api.BulkDeleteItem true "IDs and versions"
+// @Success 200 {object} api.BulkResponse "Every item succeeded"
+// @Success 207 {object} api.BulkResponse "Some items failed"
+// @Failure 400 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /customers/bulk [delete]
+func (h *BulkHandler) DeleteCustomers(w http.ResponseWriter, r *http.Request) {
+	mode, items, err := parseBulkRequest[api.BulkDeleteItem](r)
+	if err != nil {
+		handleBodyError(w, r, err)
+		return
+	}
+
+	problems := make([]error, len(items))
+	for i, item := range items {
+		switch {
+		case item.ID == 0:
+			problems[i] = errMissingID
+		case item.Version < 1:
+			problems[i] = errMissingVersion
+		}
+	}
+	h.run(w, r, mode, http.StatusNoContent, problems, func(valid []int) ([]persistence.BulkResult, error) {
+		batch := make([]persistence.BulkDelete, len(valid))
+		for i, index := range valid {
+			batch[i] = persistence.BulkDelete{ID: items[index].ID, Version: items[index].Version}
+		}
+		return h.repo.DeleteMany(r.Context(), batch, mode)
+	})
+}
+
+var (
+	errMissingID      = errors.New("id is required")
+	errMissingVersion = errors.New("version is required; send the version the customer was read with")
+)
+
+// parseBulkMode reads the mode query parameter.
+func parseBulkMode(r *http.Request) (persistence.BulkMode, error) {
+	switch r.URL.Query().Get("mode") {
+	case "", allOrNothingMode:
+		return persistence.AllOrNothing, nil
+	case bestEffortMode:
+		return persistence.BestEffort, nil
+	}
+	return persistence.AllOrNothing, fmt.Errorf("mode must be %s or %s", allOrNothingMode, bestEffortMode)
+}
+
+// formatBulkMode is the inverse of parseBulkMode.
+func formatBulkMode(mode persistence.BulkMode) string {
+	if mode == persistence.BestEffort {
+		return bestEffortMode
+	}
+	return allOrNothingMode
+}
+
+// parseBulkRequest reads the mode query parameter and the array of items.
+func parseBulkRequest[T any](r *http.Request) (persistence.BulkMode, []T, error) {
+	mode, err := parseBulkMode(r)
+	if err != nil {
+		return mode, nil, err
+	}
+	var items []T
+	if err := decodeBody(r, &items); err != nil {
+		return mode, nil, err
+	}
+	if len(items) == 0 || len(items) > maxBulkItems {
+		return mode, nil, fmt.Errorf("send between 1 and %d items", maxBulkItems)
+	}
+	return mode, items, nil
+}
+
+// run applies the items that passed the checks made before touching the
+// repository, and answers with the outcome of every item; succeeded is the
+// status of an item that was applied.
+func (h *BulkHandler) run(w http.ResponseWriter, r *http.Request, mode persistence.BulkMode, succeeded int, problems []error,
+	apply func(valid []int) ([]persistence.BulkResult, error)) {
+	results, err := applyBatch(mode, problems, apply)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+
+	response := api.BulkResponse{Mode: formatBulkMode(mode), Results: make([]api.BulkItemResult, len(results))}
+	for i, result := range results {
+		item := api.BulkItemResult{Index: i, Status: succeeded}
+		if result.Err != nil {
+			problem := completeProblem(problemFor(r, result.Err))
+			item.Status, item.Problem = problem.Status, &problem
+			response.Failed++
+		} else {
+			if result.Customer.ID != nil {
+				item.Customer = &result.Customer
+			}
+			response.Succeeded++
+		}
+		response.Results[i] = item
+	}
+	writeBatchResponse(w, r, response.Failed, response)
+}
+
+// applyBatch passes the indexes of the items without problems to apply and
+// returns the outcome of every item. An all-or-nothing batch with an item
+// that has a problem is not applied at all.
+func applyBatch(mode persistence.BulkMode, problems []error,
+	apply func(valid []int) ([]persistence.BulkResult, error)) ([]persistence.BulkResult, error) {
+	var valid []int
+	for i, err := range problems {
+		if err == nil {
+			valid = append(valid, i)
+		}
+	}
+
+	results := make([]persistence.BulkResult, len(problems))
+	for i, err := range problems {
+		results[i].Err = err
+	}
+	if mode == persistence.BestEffort || len(valid) == len(problems) {
+		applied, err := apply(valid)
+		if err != nil {
+			return nil, err
+		}
+		for i, index := range valid {
+			results[index] = applied[i]
+		}
+	} else {
+		for _, index := range valid {
+			results[index].Err = persistence.ErrBatchAborted
+		}
+	}
+	return results, nil
+}
+
+// writeBatchResponse answers with 200 OK if no item failed, and with 207
+// Multi-Status otherwise.
+func writeBatchResponse(w http.ResponseWriter, r *http.Request, failed int, response any) {
+	status := http.StatusOK
+	if failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	writeResponse(w, r, status, response)
+}
@@ -1,167 +1,595 @@
 package handler
 
 import (
-	"database/sql"
 	"encoding/json"
 	"errors"
 	"farmApp/pkg/api"
 	"farmApp/pkg/persistence"
+	"fmt"
 	"github.com/gorilla/mux"
+	"gopkg.in/yaml.v3"
+	"io"
+	"log"
+	"mime"
 	"net/http"
+	"net/url"
+	"reflect"
 	"strconv"
+	"strings"
+	"time"
 )
 
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// CustomerHandler serves the customer endpoints from a CustomerRepository.
+type CustomerHandler struct {
+	repo persistence.CustomerRepository
+	// trashRetention is how long deleted customers stay in the trash.
+	trashRetention time.Duration
+}
+
+func NewCustomerHandler(repo persistence.CustomerRepository, trashRetention time.Duration) *CustomerHandler {
+	return &CustomerHandler{repo: repo, trashRetention: trashRetention}
+}
+
 // @Summary Get all customers
-// @Description Get all customers
+// @Description Get a page of customers matching the filters, in ID order unless sort is given. The address filters select customers with at least one address matching all of them. Follow the Link header with rel="next" for the next page.
 // @Tags customers
-// @Produce json
+// @Produce json,xml,application/yaml,text/csv
+// @Param role query string false "Role, ignoring case"
+// @Param contacted query bool false "Contacted flag"
+// @Param name query string false "Substring of the name, ignoring case"
+// @Param email query string false "Substring of the email, ignoring case"
+// @Param created_after query string false "Created at or after this RFC 3339 time or YYYY-MM-DD date"
+// @Param created_before query string false "Created before this RFC 3339 time or YYYY-MM-DD date"
+// @Param address query string false "Substring of the street, house number, postal code or city of an address, ignoring case"
+// @Param postal_code query string false "Beginning of the postal code of an address"
+// @Param city query string false "City of an address, ignoring case"
+// @Param country query string false "ISO 3166-1 alpha-2 country code of an address"
+// @Param address_type query string false "Type of an address (billing, delivery, farm_yard)"
+// @Param tags query string false "Comma-separated tag names, ignoring case"
+// @Param tags_match query string false "Whether customers need any (default) or all of the tags" Enums(any, all)
+// @Param sort query string false "Comma-separated fields (id, name, role, email, phone, contacted, created_at), prefixed with - for descending"
+// @Param limit query int false "Page size (default 100, at most 1000)"
+// @Param cursor query string false "Cursor from the previous page's next link"
 // @Success 200 {array} api.Customer
-// @Failure 500 {object} api.ErrorResponse
+// @Header 200 {integer} X-Total-Count "Number of customers on all pages"
+// @Header 200 {string} Link "URL of the next page (rel=next)"
+// @Failure 400 {object} api.Problem
+// @Failure 500 {object} api.Problem
 // @Router /customers [get]
-func GetCustomers(w http.ResponseWriter, r *http.Request) {
-	customers, err := persistence.GetCustomers()
+func (h *CustomerHandler) GetCustomers(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r)
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.repo.List(r.Context(), opts)
 	if err != nil {
-		handleError(w, err, http.StatusInternalServerError)
+		handleRepositoryError(w, r, err)
 		return
 	}
-	encodeJSONResponse(w, customers)
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	if page.NextCursor != "" {
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, nextPageURL(r, page.NextCursor)))
+	}
+	encodeResponse(w, r, page.Customers)
+}
+
+func parseListOptions(r *http.Request) (persistence.ListOptions, error) {
+	query := r.URL.Query()
+	opts := persistence.ListOptions{
+		Limit:  defaultPageSize,
+		Cursor: query.Get("cursor"),
+		Filter: persistence.CustomerFilter{
+			Role:        query.Get("role"),
+			Name:        query.Get("name"),
+			Email:       query.Get("email"),
+			Address:     query.Get("address"),
+			PostalCode:  query.Get("postal_code"),
+			City:        query.Get("city"),
+			Country:     query.Get("country"),
+			AddressType: query.Get("address_type"),
+		},
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return opts, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		opts.Limit = limit
+	}
+	if value := query.Get("tags"); value != "" {
+		opts.Filter.Tags = strings.Split(value, ",")
+	}
+	switch query.Get("tags_match") {
+	case "", "any":
+	case "all":
+		opts.Filter.AllTags = true
+	default:
+		return opts, errors.New("tags_match must be any or all")
+	}
+	if value := query.Get("contacted"); value != "" {
+		contacted, err := strconv.ParseBool(value)
+		if err != nil {
+			return opts, errors.New("contacted must be true or false")
+		}
+		opts.Filter.Contacted = &contacted
+	}
+
+	var err error
+	if opts.Filter.CreatedAfter, err = parseTimeParam(query, "created_after"); err != nil {
+		return opts, err
+	}
+	if opts.Filter.CreatedBefore, err = parseTimeParam(query, "created_before"); err != nil {
+		return opts, err
+	}
+	if opts.Sort, err = persistence.ParseSort(query.Get("sort")); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// parseTimeParam reads an RFC 3339 time or a YYYY-MM-DD date (midnight UTC);
+// a missing parameter is the zero time.
+func parseTimeParam(query url.Values, name string) (time.Time, error) {
+	value := query.Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%s must be an RFC 3339 time or a YYYY-MM-DD date", name)
+}
+
+// nextPageURL repeats the request with the cursor of the next page.
+func nextPageURL(r *http.Request, cursor string) string {
+	query := r.URL.Query()
+	query.Set("cursor", cursor)
+	next := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	return next.String()
 }
 
 // @Summary Get a customer by ID
 // @Description Get a customer by ID
 // @Tags customers
-// @Produce json
+// @Produce json,xml,application/yaml,text/csv
 // @Param id path int true "Customer ID"
 // @Success 200 {object} api.Customer
-// @Failure 400 {object} api.ErrorResponse
-// @Failure 404 {object} api.ErrorResponse
-// @Failure 500 {object} api.ErrorResponse
+// @Header 200 {string} ETag "Customer version"
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 500 {object} api.Problem
 // @Router /customers/{id} [get]
-func GetCustomer(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
+func (h *CustomerHandler) GetCustomer(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
 	if err != nil {
-		handleError(w, err, http.StatusBadRequest)
+		handleError(w, r, err, http.StatusBadRequest)
 		return
 	}
 
-	customer, err := persistence.GetCustomerByID(id)
+	customer, err := h.repo.Get(r.Context(), id)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			http.Error(w, "Customer not found", http.StatusNotFound)
-		} else {
-			handleError(w, err, http.StatusInternalServerError)
-		}
+		handleRepositoryError(w, r, err)
 		return
 	}
-	encodeJSONResponse(w, customer)
+	setETag(w, customer.Version)
+	encodeResponse(w, r, customer)
 }
 
 // @Summary Add a new customer
 // @Description Add a new customer
 // @Tags customers
-// @Accept json
-// @Produce json
+// @Accept json,xml,application/yaml,text/csv
+// @Produce json,xml,application/yaml,text/csv
 // @Param customer body api.Customer true "Customer"
+// @Param Idempotency-Key header string false "Unique key that makes retries return the first response"
 // @Success 201 {object} api.Customer
-// @Failure 400 {object} api.ErrorResponse
-// @Failure 500 {object} api.ErrorResponse
+// @Header 201 {string} ETag "Customer version"
+// @Header 201 {string} Idempotent-Replayed "true if this is the stored response to an earlier request"
+// @Failure 400 {object} api.Problem
+// @Failure 409 {object} api.Problem
+// @Failure 422 {object} api.Problem
+// @Failure 500 {object} api.Problem
 // @Router /customers [post]
-func AddCustomer(w http.ResponseWriter, r *http.Request) {
+func (h *CustomerHandler) AddCustomer(w http.ResponseWriter, r *http.Request) {
 	var customer api.Customer
 
-	if err := json.NewDecoder(r.Body).Decode(&customer); err != nil {
-		handleError(w, err, http.StatusBadRequest)
+	if err := decodeBody(r, &customer); err != nil {
+		handleBodyError(w, r, err)
+		return
+	}
+	if err := customer.Validate(); err != nil {
+		handleRepositoryError(w, r, err)
 		return
 	}
 
-	id, err := persistence.AddCustomer(customer)
+	id, err := h.repo.Create(r.Context(), customer)
 	if err != nil {
-		handleError(w, err, http.StatusInternalServerError)
+		handleError(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
-	customer.ID = &id
-	w.WriteHeader(http.StatusCreated)
-	encodeJSONResponse(w, customer)
+	customer, err = h.repo.Get(r.Context(), id)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	setETag(w, customer.Version)
+	writeResponse(w, r, http.StatusCreated, customer)
 }
 
 // @Summary Update a customer
 // @Description Update a customer
 // @Tags customers
-// @Accept json
-// @Produce json
+// @Accept json,xml,application/yaml,text/csv
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Customer ID"
+// @Param If-Match header string true "ETag of the customer version being replaced, or *"
 // @Param customer body api.Customer true "Customer"
 // @Success 200 {object} api.Customer
-// @Failure 400 {object} api.ErrorResponse
-// @Failure 500 {object} api.ErrorResponse
+// @Header 200 {string} ETag "Customer version"
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 412 {object} api.Problem
+// @Failure 422 {object} api.Problem
+// @Failure 428 {object} api.Problem
+// @Failure 500 {object} api.Problem
 // @Router /customers/{id} [put]
-func UpdateCustomer(w http.ResponseWriter, r *http.Request) {
+func (h *CustomerHandler) UpdateCustomer(w http.ResponseWriter, r *http.Request) {
 	var customer api.Customer
-	if err := json.NewDecoder(r.Body).Decode(&customer); err != nil {
-		handleError(w, err, http.StatusBadRequest)
+	if err := decodeBody(r, &customer); err != nil {
+		handleBodyError(w, r, err)
+		return
+	}
+	if err := customer.Validate(); err != nil {
+		handleRepositoryError(w, r, err)
 		return
 	}
 
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
+	id, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	version, present, err := expectedVersion(r)
 	if err != nil {
-		handleError(w, err, http.StatusBadRequest)
+		handleIfMatchError(w, r, err)
+		return
+	}
+	if !present {
+		requireIfMatch(w, r, h.repo, id)
 		return
 	}
-	customer.ID = &id
 
-	err = persistence.UpdateCustomer(*customer.ID, customer)
+	customer, err = h.repo.Update(r.Context(), id, version, customer)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+
+	setETag(w, customer.Version)
+	encodeResponse(w, r, customer)
+}
+
+// @Summary Partially update a customer
+// @Description Change only the fields in the patch. Send a JSON Merge Patch (RFC 7396) such as {"contacted": true} as application/merge-patch+json, or a JSON Patch (RFC 6902) as application/json-patch+json.
+// @Tags customers
+// @Accept application/merge-patch+json,application/json-patch+json
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Customer ID"
+// @Param If-Match header string true "ETag of the customer version being patched, or *"
+// @Param patch body object true "JSON Merge Patch or JSON Patch"
+// @Success 200 {object} api.Customer
+// @Header 200 {string} ETag "Customer version"
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 409 {object} api.Problem "A JSON Patch test operation failed"
+// @Failure 412 {object} api.Problem
+// @Failure 415 {object} api.Problem
+// @Failure 422 {object} api.Problem "The patch cannot be applied, or the patched customer is invalid"
+// @Failure 428 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /customers/{id} [patch]
+func (h *CustomerHandler) PatchCustomer(w http.ResponseWriter, r *http.Request) {
+	patch, err := parsePatch(r)
+	if errors.Is(err, errUnsupportedPatch) {
+		w.Header().Set("Accept-Patch", mergePatchType+", "+jsonPatchType)
+		handleError(w, r, err, http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	id, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	version, present, err := expectedVersion(r)
+	if err != nil {
+		handleIfMatchError(w, r, err)
+		return
+	}
+	if !present {
+		requireIfMatch(w, r, h.repo, id)
+		return
+	}
+
+	customer, err := h.repo.Patch(r.Context(), id, version, func(current api.Customer) (api.Customer, error) {
+		patched, err := patchCustomer(current, patch)
+		if err != nil {
+			return patched, err
+		}
+		return patched, patched.Validate()
+	})
+	var invalid *patchError
+	if errors.As(err, &invalid) {
+		problem := api.Problem{Status: invalid.status, Detail: invalid.message}
+		if invalid.status == http.StatusConflict {
+			problem.Type, problem.Title = problemPatchTestFailed, "Patch test failed"
+		}
+		writeProblem(w, r, problem)
+		return
+	}
 	if err != nil {
-		handleError(w, err, http.StatusInternalServerError)
+		handleRepositoryError(w, r, err)
 		return
 	}
 
-	encodeJSONResponse(w, customer)
+	setETag(w, customer.Version)
+	encodeResponse(w, r, customer)
+}
+
+// parsePatch reads a merge patch or JSON Patch, depending on the Content-Type.
+func parsePatch(r *http.Request) (func(doc any) (any, error), error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != mergePatchType && mediaType != jsonPatchType) {
+		return nil, errUnsupportedPatch
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if mediaType == jsonPatchType {
+		ops, err := parseJSONPatch(data)
+		if err != nil {
+			return nil, err
+		}
+		return func(doc any) (any, error) { return applyJSONPatch(doc, ops) }, nil
+	}
+	var mergePatch any
+	if err := json.Unmarshal(data, &mergePatch); err != nil {
+		return nil, err
+	}
+	return func(doc any) (any, error) { return applyMergePatch(doc, mergePatch), nil }, nil
 }
 
 // @Summary Delete a customer
-// @Description Delete a customer
+// @Description Move a customer to the trash. It can be restored until the trash is purged.
 // @Tags customers
 // @Param id path int true "Customer ID"
+// @Param If-Match header string true "ETag of the customer version being deleted, or *"
 // @Success 204
-// @Failure 400 {object} api.ErrorResponse
-// @Failure 404 {object} api.ErrorResponse
-// @Failure 500 {object} api.ErrorResponse
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 412 {object} api.Problem
+// @Failure 428 {object} api.Problem
+// @Failure 500 {object} api.Problem
 // @Router /customers/{id} [delete]
-func DeleteCustomer(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
+func (h *CustomerHandler) DeleteCustomer(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
 	if err != nil {
-		handleError(w, err, http.StatusBadRequest)
+		handleError(w, r, err, http.StatusBadRequest)
 		return
 	}
 
-	_, err = persistence.GetCustomerByID(id)
+	version, present, err := expectedVersion(r)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			http.Error(w, "Customer not found", http.StatusNotFound)
-		} else {
-			handleError(w, err, http.StatusInternalServerError)
-		}
+		handleIfMatchError(w, r, err)
+		return
+	}
+	if !present {
+		requireIfMatch(w, r, h.repo, id)
 		return
 	}
 
-	err = persistence.DeleteCustomer(id)
+	err = h.repo.Delete(r.Context(), id, version)
 	if err != nil {
-		handleError(w, err, http.StatusInternalServerError)
+		handleRepositoryError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func handleError(w http.ResponseWriter, err error, statusCode int) {
-	http.Error(w, err.Error(), statusCode)
+// @Summary List deleted customers
+// @Description List the customers in the trash, most recently deleted first
+// @Tags customers
+// @Produce json,xml,application/yaml,text/csv
+// @Success 200 {array} api.Customer
+// @Failure 500 {object} api.Problem
+// @Router /customers/trash [get]
+func (h *CustomerHandler) GetTrash(w http.ResponseWriter, r *http.Request) {
+	customers, err := h.repo.ListTrash(r.Context())
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	encodeResponse(w, r, customers)
+}
+
+// @Summary Restore a deleted customer
+// @Description Take a customer out of the trash
+// @Tags customers
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Customer ID"
+// @Success 200 {object} api.Customer
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /customers/{id}/restore [post]
+func (h *CustomerHandler) RestoreCustomer(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.Restore(r.Context(), id); err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+
+	customer, err := h.repo.Get(r.Context(), id)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	setETag(w, customer.Version)
+	encodeResponse(w, r, customer)
+}
+
+// @Summary Purge the trash
+// @Description Permanently remove customers that have been in the trash longer than the retention period
+// @Tags customers
+// @Produce json,xml,application/yaml,text/csv
+// @Success 200 {object} api.PurgeResult
+// @Failure 500 {object} api.Problem
+// @Router /customers/trash [delete]
+func (h *CustomerHandler) PurgeTrash(w http.ResponseWriter, r *http.Request) {
+	purged, err := h.repo.Purge(r.Context(), time.Now().Add(-h.trashRetention))
+	if err != nil {
+		handleError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	encodeResponse(w, r, api.PurgeResult{Purged: purged})
+}
+
+// pathID parses the integer path variable with the given name.
+func pathID(r *http.Request, name string) (int, error) {
+	id, err := strconv.Atoi(mux.Vars(r)[name])
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer", name)
+	}
+	return id, nil
+}
+
+// decodeBody reads the request body into v from JSON, XML, YAML or CSV,
+// depending on the Content-Type, describing errors in terms of the document
+// rather than Go types.
+func decodeBody(r *http.Request, v any) error {
+	f, err := requestFormat(r)
+	if err != nil {
+		return err
+	}
+	if f == formatJSON {
+		if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+			return describeJSONError(err)
+		}
+		return nil
+	}
+
+	var doc any
+	switch f {
+	case formatYAML:
+		err = yaml.NewDecoder(r.Body).Decode(&doc)
+	case formatXML:
+		doc, err = readXML(r.Body)
+		doc = coerce(doc, reflect.TypeOf(v).Elem())
+	case formatCSV:
+		doc, err = readCSVBody(r.Body, reflect.TypeOf(v).Elem())
+	}
+	if err != nil {
+		return fmt.Errorf("request body is not valid %s: %v", f, err)
+	}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("request body is not valid %s: %v", f, err)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return fmt.Errorf("%s must be a %s", typeErr.Field, jsonKind(typeErr.Type.Kind()))
+		}
+		return fmt.Errorf("request body is not valid %s: %v", f, err)
+	}
+	return nil
+}
+
+// readCSVBody reads a list from all rows of a CSV body, or anything else
+// from its only row.
+func readCSVBody(body io.Reader, t reflect.Type) (any, error) {
+	rows, err := readCSV(body)
+	if err != nil {
+		return nil, err
+	}
+	if t.Kind() == reflect.Slice {
+		return coerce(rows, t), nil
+	}
+	if len(rows) != 1 {
+		return nil, errors.New("send a header and exactly one row")
+	}
+	return coerce(rows[0], t), nil
+}
+
+// handleBodyError answers 415 for a body in an unsupported format and 400
+// for any other error reading the request.
+func handleBodyError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errUnsupportedBody) {
+		handleError(w, r, err, http.StatusUnsupportedMediaType)
+		return
+	}
+	handleError(w, r, err, http.StatusBadRequest)
+}
+
+func describeJSONError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return fmt.Errorf("%s must be a JSON %s", typeErr.Field, jsonKind(typeErr.Type.Kind()))
+	}
+	return fmt.Errorf("request body is not valid JSON: %v", err)
+}
+
+// jsonKind names the JSON type a Go value of the given kind is decoded from.
+func jsonKind(kind reflect.Kind) string {
+	switch kind {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	}
+	return "number"
+}
+
+// encodeResponse answers 200 OK with data in the format chosen by the
+// Negotiate middleware.
+func encodeResponse(w http.ResponseWriter, r *http.Request, data any) {
+	writeResponse(w, r, http.StatusOK, data)
 }
 
-func encodeJSONResponse(w http.ResponseWriter, data interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(data); err != nil {
-		handleError(w, err, http.StatusInternalServerError)
+// writeResponse answers with the status code and data in the format chosen
+// by the Negotiate middleware.
+func writeResponse(w http.ResponseWriter, r *http.Request, status int, data any) {
+	f := responseFormat(r)
+	w.Header().Set("Content-Type", f.mediaType())
+	w.WriteHeader(status)
+	if err := writeBody(w, f, data); err != nil {
+		log.Printf("Request %s: failed to write the response: %v", RequestIDFromContext(r.Context()), err)
 	}
 }
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"errors"
+	"farmApp/pkg/persistence"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// setETag sends the customer version as a strong entity tag.
+func setETag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", strconv.Quote(strconv.Itoa(version)))
+}
+
+// errWeakETag reports a weak entity tag in If-Match. If-Match compares
+// strongly, so a weak tag never matches (RFC 9110, section 13.1.1).
+var errWeakETag = errors.New("If-Match must be a strong entity tag such as \"3\", a weak tag never matches")
+
+// expectedVersion reads the version a write is conditional on from the
+// If-Match header. "*" matches any version.
+func expectedVersion(r *http.Request) (version int, present bool, err error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		return 0, false, nil
+	}
+	if header == "*" {
+		return persistence.AnyVersion, true, nil
+	}
+
+	// Only one entity tag can match the current version, so a list is not useful here.
+	if strings.HasPrefix(header, "W/") {
+		return 0, true, errWeakETag
+	}
+	unquoted, err := strconv.Unquote(header)
+	if err != nil {
+		return 0, true, errors.New("If-Match must be a single entity tag such as \"3\"")
+	}
+	version, err = strconv.Atoi(unquoted)
+	if err != nil || version < 1 {
+		return 0, true, errors.New("If-Match does not contain a customer version")
+	}
+	return version, true, nil
+}
+
+// handleIfMatchError answers an If-Match header expectedVersion rejected:
+// 412 Precondition Failed for a weak tag, 400 Bad Request otherwise.
+func handleIfMatchError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errWeakETag) {
+		handleError(w, r, err, http.StatusPreconditionFailed)
+		return
+	}
+	handleError(w, r, err, http.StatusBadRequest)
+}
+
+// requireIfMatch answers a write without If-Match: 404 if the customer does
+// not exist, otherwise 428 Precondition Required.
+func requireIfMatch(w http.ResponseWriter, r *http.Request, repo persistence.CustomerRepository, id int) {
+	if _, err := repo.Get(r.Context(), id); err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	WriteProblem(w, r, http.StatusPreconditionRequired, "If-Match header with the customer's ETag is required")
+}
@@ -0,0 +1,289 @@
+package handler
+
+import (
+	"farmApp/pkg/api"
+	"farmApp/pkg/persistence"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// FarmHandler serves the farms and the links between farms and customers.
+type FarmHandler struct {
+	repo persistence.FarmRepository
+}
+
+func NewFarmHandler(repo persistence.FarmRepository) *FarmHandler {
+	return &FarmHandler{repo: repo}
+}
+
+// @Summary Get all farms
+// @Description Get all farms ordered by name
+// @Tags farms
+// @Produce json,xml,application/yaml,text/csv
+// @Success 200 {array} api.Farm
+// @Failure 500 {object} api.Problem
+// @Router /farms [get]
+func (h *FarmHandler) GetFarms(w http.ResponseWriter, r *http.Request) {
+	farms, err := h.repo.ListFarms(r.Context())
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	encodeResponse(w, r, farms)
+}
+
+// @Summary Get a farm
+// @Description Get a farm by ID
+// @Tags farms
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Farm ID"
+// @Success 200 {object} api.Farm
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /farms/{id} [get]
+func (h *FarmHandler) GetFarm(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	farm, err := h.repo.GetFarm(r.Context(), id)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	encodeResponse(w, r, farm)
+}
+
+// @Summary Add a new farm
+// @Description Add a new farm
+// @Tags farms
+// @Accept json,xml,application/yaml,text/csv
+// @Produce json,xml,application/yaml,text/csv
+// @Param farm body api.Farm true "Farm"
+// @Success 201 {object} api.Farm
+// @Header 201 {string} Location "URL of the farm"
+// @Failure 400 {object} api.Problem
+// @Failure 422 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /farms [post]
+func (h *FarmHandler) AddFarm(w http.ResponseWriter, r *http.Request) {
+	farm, ok := decodeFarm(w, r)
+	if !ok {
+		return
+	}
+
+	farm, err := h.repo.CreateFarm(r.Context(), farm)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/farms/"+strconv.Itoa(*farm.ID))
+	writeResponse(w, r, http.StatusCreated, farm)
+}
+
+// @Summary Update a farm
+// @Description Replace the name, address, size and type of a farm
+// @Tags farms
+// @Accept json,xml,application/yaml,text/csv
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Farm ID"
+// @Param farm body api.Farm true "Farm"
+// @Success 200 {object} api.Farm
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 422 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /farms/{id} [put]
+func (h *FarmHandler) UpdateFarm(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	farm, ok := decodeFarm(w, r)
+	if !ok {
+		return
+	}
+
+	farm, err = h.repo.UpdateFarm(r.Context(), id, farm)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	encodeResponse(w, r, farm)
+}
+
+// @Summary Delete a farm
+// @Description Delete a farm and its links to customers. With cascade=restrict (the default) a farm that still has contacts is not deleted; unlink keeps the contacts, and trash also moves the contacts that belong to no other farm to the trash.
+// @Tags farms
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Farm ID"
+// @Param cascade query string false "What happens to the farm's contacts" Enums(restrict, unlink, trash)
+// @Success 204
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 409 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /farms/{id} [delete]
+func (h *FarmHandler) DeleteFarm(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	cascade := persistence.CascadeRestrict
+	if value := r.URL.Query().Get("cascade"); value != "" {
+		if cascade, err = persistence.ParseFarmCascade(value); err != nil {
+			handleError(w, r, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := h.repo.DeleteFarm(r.Context(), id, cascade); err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary Get the contacts of a farm
+// @Description Get the customers linked to a farm with their role there, ordered by name. Customers in the trash are left out.
+// @Tags farms
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Farm ID"
+// @Success 200 {array} api.FarmContact
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /farms/{id}/contacts [get]
+func (h *FarmHandler) GetFarmContacts(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	contacts, err := h.repo.ListFarmContacts(r.Context(), id)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	encodeResponse(w, r, contacts)
+}
+
+// @Summary Link a customer to a farm
+// @Description Make a customer a contact of a farm with the given role, or change its role there
+// @Tags farms
+// @Accept json,xml,application/yaml,text/csv
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Farm ID"
+// @Param customerId path int true "Customer ID"
+// @Param link body api.FarmLink true "Role on the farm"
+// @Success 200 {object} api.FarmContact
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 422 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /farms/{id}/contacts/{customerId} [put]
+func (h *FarmHandler) LinkCustomer(w http.ResponseWriter, r *http.Request) {
+	farmID, customerID, err := farmContactIDs(r)
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	var link api.FarmLink
+	if err := decodeBody(r, &link); err != nil {
+		handleBodyError(w, r, err)
+		return
+	}
+	link.Role = strings.TrimSpace(link.Role)
+	if err := link.Validate(); err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+
+	contact, err := h.repo.LinkFarm(r.Context(), farmID, customerID, link.Role)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	encodeResponse(w, r, contact)
+}
+
+// @Summary Unlink a customer from a farm
+// @Description Remove a customer from the contacts of a farm; the customer is kept
+// @Tags farms
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Farm ID"
+// @Param customerId path int true "Customer ID"
+// @Success 204
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /farms/{id}/contacts/{customerId} [delete]
+func (h *FarmHandler) UnlinkCustomer(w http.ResponseWriter, r *http.Request) {
+	farmID, customerID, err := farmContactIDs(r)
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.UnlinkFarm(r.Context(), farmID, customerID); err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary Get the farms of a customer
+// @Description Get the farms a customer is linked to with its role on each, ordered by name
+// @Tags farms
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Customer ID"
+// @Success 200 {array} api.CustomerFarm
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /customers/{id}/farms [get]
+func (h *FarmHandler) GetCustomerFarms(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	farms, err := h.repo.ListCustomerFarms(r.Context(), id)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	encodeResponse(w, r, farms)
+}
+
+// decodeFarm reads and validates a farm from the request body, answering
+// the request if it is invalid.
+func decodeFarm(w http.ResponseWriter, r *http.Request) (api.Farm, bool) {
+	var farm api.Farm
+	if err := decodeBody(r, &farm); err != nil {
+		handleBodyError(w, r, err)
+		return farm, false
+	}
+	if err := farm.Validate(); err != nil {
+		handleRepositoryError(w, r, err)
+		return farm, false
+	}
+	return farm, true
+}
+
+// farmContactIDs reads the farm and customer IDs from the path.
+func farmContactIDs(r *http.Request) (farmID, customerID int, err error) {
+	if farmID, err = pathID(r, "id"); err != nil {
+		return 0, 0, err
+	}
+	customerID, err = pathID(r, "customerId")
+	return farmID, customerID, err
+}
@@ -0,0 +1,737 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The XML, YAML and CSV representations are derived from the JSON one, so
+// that they use the same field names and omit the same empty fields.
+
+// node is a JSON value whose object keys keep their order.
+type node struct {
+	kind   nodeKind
+	keys   []string
+	items  []*node // object values or array items
+	scalar any     // string, json.Number, bool or nil
+}
+
+type nodeKind int
+
+const (
+	scalarNode nodeKind = iota
+	objectNode
+	arrayNode
+)
+
+// parseNode reads a JSON document, keeping numbers as written.
+func parseNode(body []byte) (*node, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	return readNode(dec)
+}
+
+func readNode(dec *json.Decoder) (*node, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'), json.Delim('['):
+		n := &node{kind: arrayNode}
+		if token == json.Delim('{') {
+			n.kind = objectNode
+		}
+		for dec.More() {
+			if n.kind == objectNode {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				n.keys = append(n.keys, key.(string))
+			}
+			item, err := readNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			n.items = append(n.items, item)
+		}
+		_, err := dec.Token()
+		return n, err
+	}
+	return &node{scalar: token}, nil
+}
+
+// MarshalJSON writes the node with its keys in their original order.
+func (n *node) MarshalJSON() ([]byte, error) {
+	if n.kind == scalarNode {
+		return json.Marshal(n.scalar)
+	}
+	start, end := byte('['), byte(']')
+	if n.kind == objectNode {
+		start, end = '{', '}'
+	}
+	var b bytes.Buffer
+	b.WriteByte(start)
+	for i, item := range n.items {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		if n.kind == objectNode {
+			key, _ := json.Marshal(n.keys[i])
+			b.Write(key)
+			b.WriteByte(':')
+		}
+		value, err := item.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		b.Write(value)
+	}
+	b.WriteByte(end)
+	return b.Bytes(), nil
+}
+
+// text is the value of a scalar as it appears in XML and CSV.
+func (n *node) text() string {
+	switch value := n.scalar.(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case bool:
+		return strconv.FormatBool(value)
+	}
+	return ""
+}
+
+// writeBody writes data in the format f. Lists are encoded one item at a
+// time, so that large responses are streamed rather than built in memory.
+func writeBody(w io.Writer, f format, data any) error {
+	list := reflect.ValueOf(data)
+	if list.Kind() == reflect.Slice && !list.IsNil() {
+		switch f {
+		case formatXML:
+			return writeXMLList(w, elementName(list.Type()), list)
+		case formatYAML:
+			if list.Len() > 0 {
+				return writeYAMLList(w, list)
+			}
+		case formatCSV:
+			return writeCSV(w, data)
+		default:
+			return writeJSONList(w, list)
+		}
+	}
+
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	switch f {
+	case formatXML:
+		return writeXML(w, elementName(reflect.TypeOf(data)), nil, body)
+	case formatYAML:
+		return writeYAML(w, body)
+	case formatCSV:
+		return writeCSV(w, data)
+	}
+	_, err = w.Write(append(body, '\n'))
+	return err
+}
+
+// eachItem calls fn with the JSON encoding of each item of a list.
+func eachItem(list reflect.Value, fn func(body []byte) error) error {
+	for i := 0; i < list.Len(); i++ {
+		body, err := json.Marshal(list.Index(i).Interface())
+		if err != nil {
+			return err
+		}
+		if err := fn(body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeJSONList writes the list like json.Marshal would.
+func writeJSONList(w io.Writer, list reflect.Value) error {
+	separator := "["
+	err := eachItem(list, func(body []byte) error {
+		if _, err := io.WriteString(w, separator); err != nil {
+			return err
+		}
+		separator = ","
+		_, err := w.Write(body)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if separator == "[" {
+		_, err = io.WriteString(w, "[]\n")
+	} else {
+		_, err = io.WriteString(w, "]\n")
+	}
+	return err
+}
+
+// xmlName matches the keys that can be used as XML element names; others
+// are written as <entry key="...">.
+var xmlName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// writeXML writes the document as an element called name: objects become
+// elements named by their keys, array items elements named by the singular
+// of the array, and null values are left out.
+func writeXML(w io.Writer, name string, attrs []xml.Attr, body []byte) error {
+	root, err := parseNode(body)
+	if err != nil {
+		return err
+	}
+	if root.kind == scalarNode && root.scalar == nil {
+		root = &node{kind: arrayNode}
+	}
+	return writeXMLDocument(w, func(enc *xml.Encoder) error {
+		return writeXMLElement(enc, name, attrs, root)
+	})
+}
+
+// writeXMLList writes a list like writeXML, one item at a time.
+func writeXMLList(w io.Writer, name string, list reflect.Value) error {
+	return writeXMLDocument(w, func(enc *xml.Encoder) error {
+		start := xml.StartElement{Name: xml.Name{Local: name}}
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		err := eachItem(list, func(body []byte) error {
+			item, err := parseNode(body)
+			if err != nil {
+				return err
+			}
+			return writeXMLElement(enc, singular(name), nil, item)
+		})
+		if err != nil {
+			return err
+		}
+		return enc.EncodeToken(start.End())
+	})
+}
+
+// writeXMLDocument writes the XML header and the root element written by
+// writeRoot.
+func writeXMLDocument(w io.Writer, writeRoot func(enc *xml.Encoder) error) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := writeRoot(enc); err != nil {
+		return err
+	}
+	if err := enc.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func writeXMLElement(enc *xml.Encoder, name string, attrs []xml.Attr, n *node) error {
+	if n.kind == scalarNode && n.scalar == nil {
+		return nil
+	}
+	start := xml.StartElement{Name: xml.Name{Local: name}, Attr: attrs}
+	if !xmlName.MatchString(name) {
+		start = xml.StartElement{Name: xml.Name{Local: "entry"}, Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: name}}}
+	}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	for i, item := range n.items {
+		itemName := singular(start.Name.Local)
+		if n.kind == objectNode {
+			itemName = n.keys[i]
+		}
+		if err := writeXMLElement(enc, itemName, nil, item); err != nil {
+			return err
+		}
+	}
+	if n.kind == scalarNode {
+		if err := enc.EncodeToken(xml.CharData(n.text())); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// elementName is the name of the root element for a value of type t: the
+// type's name in snake case, in the plural for slices.
+func elementName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Slice {
+		return plural(elementName(t.Elem()))
+	}
+	if t.Name() == "" {
+		return "response"
+	}
+	var name strings.Builder
+	for i, r := range t.Name() {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				name.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		name.WriteRune(r)
+	}
+	return name.String()
+}
+
+func plural(name string) string {
+	if strings.HasSuffix(name, "y") {
+		return strings.TrimSuffix(name, "y") + "ies"
+	}
+	return name + "s"
+}
+
+func singular(name string) string {
+	switch {
+	case strings.HasSuffix(name, "ies"):
+		return strings.TrimSuffix(name, "ies") + "y"
+	case strings.HasSuffix(name, "s"):
+		return strings.TrimSuffix(name, "s")
+	}
+	return "item"
+}
+
+// writeYAML re-encodes the JSON document in block style.
+func writeYAML(w io.Writer, body []byte) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(body, &doc); err != nil {
+		return err
+	}
+	return encodeYAML(w, &doc)
+}
+
+// writeYAMLList writes a non-empty list like writeYAML, encoding each item
+// as a sequence of its own; written one after the other, they form the
+// sequence of all items.
+func writeYAMLList(w io.Writer, list reflect.Value) error {
+	return eachItem(list, func(body []byte) error {
+		var doc yaml.Node
+		if err := yaml.Unmarshal(body, &doc); err != nil {
+			return err
+		}
+		return encodeYAML(w, &yaml.Node{Kind: yaml.SequenceNode, Content: doc.Content})
+	})
+}
+
+func encodeYAML(w io.Writer, doc *yaml.Node) error {
+	resetYAMLStyle(doc)
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// resetYAMLStyle drops the flow style and quotes JSON was read with, so
+// that the encoder only quotes strings where YAML needs it.
+func resetYAMLStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, child := range n.Content {
+		resetYAMLStyle(child)
+	}
+}
+
+// writeCSV writes a list as one row per item, or any other value as a
+// single row. Nested objects are flattened into columns named by their
+// path, like customer.name, and arrays are written as JSON. Cells that a
+// spreadsheet would take for a formula are escaped (see escapeCSVCell).
+//
+// The header names the columns of all rows, so the rows are encoded twice:
+// once to collect the columns and once to write them.
+func writeCSV(w io.Writer, data any) error {
+	var columns []string
+	seen := map[string]bool{}
+	addColumn := func(column, _ string) {
+		if !seen[column] {
+			seen[column] = true
+			columns = append(columns, column)
+		}
+	}
+	rows := 0
+	err := eachCSVRow(data, func(row *node) error {
+		rows++
+		return flattenCSV(row, "", addColumn)
+	})
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		// Name the columns of an empty list after an empty item.
+		if empty, err := emptyItem(reflect.TypeOf(data)); err == nil {
+			flattenCSV(empty, "", addColumn)
+		}
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columns); err != nil {
+		return err
+	}
+	record := make([]string, len(columns))
+	err = eachCSVRow(data, func(row *node) error {
+		cells := map[string]string{}
+		if err := flattenCSV(row, "", func(column, value string) { cells[column] = value }); err != nil {
+			return err
+		}
+		for i, column := range columns {
+			record[i] = escapeCSVCell(cells[column])
+		}
+		return cw.Write(record)
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// eachCSVRow calls fn with each row of data: the items of a list or of a
+// value encoded as a JSON array, nothing for null, or else data itself.
+func eachCSVRow(data any, fn func(row *node) error) error {
+	if list := reflect.ValueOf(data); list.Kind() == reflect.Slice {
+		return eachItem(list, func(body []byte) error {
+			row, err := parseNode(body)
+			if err != nil {
+				return err
+			}
+			return fn(row)
+		})
+	}
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	root, err := parseNode(body)
+	if err != nil {
+		return err
+	}
+	switch {
+	case root.kind == arrayNode:
+		for _, row := range root.items {
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	case root.kind == scalarNode && root.scalar == nil:
+		return nil
+	}
+	return fn(root)
+}
+
+// csvFormulaStarts are the first characters that make spreadsheets such as
+// Excel evaluate a cell as a formula. Tab and carriage return count as well,
+// because some spreadsheets skip them before looking for a formula.
+const csvFormulaStarts = "=+-@\t\r"
+
+// csvPlainPhone matches international phone numbers such as
+// "+49 171 5551234", which start with + but contain nothing a spreadsheet
+// could run.
+var csvPlainPhone = regexp.MustCompile(`^\+[0-9 ()/-]+$`)
+
+// escapeCSVCell prefixes a cell that would be evaluated as a formula with
+// an apostrophe, which spreadsheets show as text and do not display. Plain
+// phone numbers are left as they are.
+func escapeCSVCell(value string) string {
+	if value != "" && strings.ContainsRune(csvFormulaStarts, rune(value[0])) && !csvPlainPhone.MatchString(value) {
+		return "'" + value
+	}
+	return value
+}
+
+// unescapeCSVCell reverts escapeCSVCell, so that exported files can be
+// read back.
+func unescapeCSVCell(value string) string {
+	if len(value) > 1 && value[0] == '\'' && strings.ContainsRune(csvFormulaStarts, rune(value[1])) {
+		return value[1:]
+	}
+	return value
+}
+
+func flattenCSV(n *node, column string, add func(column, value string)) error {
+	switch {
+	case n.kind == objectNode:
+		for i, key := range n.keys {
+			if column != "" {
+				key = column + "." + key
+			}
+			if err := flattenCSV(n.items[i], key, add); err != nil {
+				return err
+			}
+		}
+		return nil
+	case column == "":
+		column = "value"
+	}
+	if n.kind == arrayNode {
+		value, err := n.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		add(column, string(value))
+		return nil
+	}
+	add(column, n.text())
+	return nil
+}
+
+// emptyItem is the JSON encoding of the zero item of a slice type.
+func emptyItem(t reflect.Type) (*node, error) {
+	if t.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("%v is not a slice", t)
+	}
+	body, err := json.Marshal(reflect.New(t.Elem()).Interface())
+	if err != nil {
+		return nil, err
+	}
+	return parseNode(body)
+}
+
+// readXML reads an XML document into maps of its child elements and
+// strings of its text, with repeated elements collected in slices. The name
+// of the root element is ignored.
+func readXML(r io.Reader) (any, error) {
+	dec := xml.NewDecoder(r)
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := token.(xml.StartElement); ok {
+			return readXMLElement(dec)
+		}
+	}
+}
+
+func readXMLElement(dec *xml.Decoder) (any, error) {
+	var children map[string]any
+	var text strings.Builder
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			child, err := readXMLElement(dec)
+			if err != nil {
+				return nil, err
+			}
+			name := token.Name.Local
+			if name == "entry" {
+				for _, attr := range token.Attr {
+					if attr.Name.Local == "key" {
+						name = attr.Value
+					}
+				}
+			}
+			if children == nil {
+				children = map[string]any{}
+			}
+			switch existing := children[name].(type) {
+			case nil:
+				children[name] = child
+			case []any:
+				children[name] = append(existing, child)
+			default:
+				children[name] = []any{existing, child}
+			}
+		case xml.CharData:
+			text.Write(token)
+		case xml.EndElement:
+			if children != nil {
+				return children, nil
+			}
+			return text.String(), nil
+		}
+	}
+}
+
+// readCSV reads a header and rows into one map per row, splitting columns
+// named like customer.name into nested maps.
+func readCSV(r io.Reader) ([]any, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	header := records[0]
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	rows := make([]any, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := map[string]any{}
+		for i, column := range header {
+			path := strings.Split(strings.TrimSpace(column), ".")
+			parent := row
+			for _, key := range path[:len(path)-1] {
+				child, ok := parent[key].(map[string]any)
+				if !ok {
+					child = map[string]any{}
+					parent[key] = child
+				}
+				parent = child
+			}
+			parent[path[len(path)-1]] = unescapeCSVCell(record[i])
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// coerce converts a document read from XML or CSV, whose values are all
+// strings, to the JSON types of t so that it decodes like a JSON body.
+// Values that do not convert are left as strings for the decoder to reject.
+func coerce(v any, t reflect.Type) any {
+	for t.Kind() == reflect.Pointer {
+		if v == "" {
+			return nil
+		}
+		t = t.Elem()
+	}
+	s, isString := v.(string)
+	switch {
+	case t == timeType:
+		return v
+	case t.Kind() == reflect.Bool && isString:
+		if b, err := strconv.ParseBool(strings.TrimSpace(s)); err == nil {
+			return b
+		}
+	case isNumberKind(t.Kind()) && isString:
+		s = strings.TrimSpace(s)
+		if _, err := strconv.ParseFloat(s, 64); err == nil && json.Valid([]byte(s)) {
+			return json.Number(s)
+		}
+	case t.Kind() == reflect.Slice:
+		return coerceList(v, t.Elem())
+	case t.Kind() == reflect.Map:
+		if m, ok := v.(map[string]any); ok {
+			out := make(map[string]any, len(m))
+			for key, value := range m {
+				out[key] = coerce(value, t.Elem())
+			}
+			return out
+		}
+	case t.Kind() == reflect.Struct:
+		if m, ok := v.(map[string]any); ok {
+			return coerceStruct(m, t)
+		}
+	}
+	return v
+}
+
+// coerceList accepts a list, an element wrapping the list items, like
+// <customers><customer>...</customer></customers>, or a single item.
+func coerceList(v any, elem reflect.Type) any {
+	switch value := v.(type) {
+	case []any:
+		items := make([]any, len(value))
+		for i, item := range value {
+			items[i] = coerce(item, elem)
+		}
+		return items
+	case map[string]any:
+		if len(value) == 1 {
+			for _, items := range value {
+				switch items.(type) {
+				case []any, map[string]any:
+					return coerceList(items, elem)
+				}
+			}
+		}
+	case string:
+		if strings.TrimSpace(value) == "" {
+			return []any{}
+		}
+	}
+	return []any{coerce(v, elem)}
+}
+
+// coerceStruct converts the fields of t, leaving out empty values of
+// fields that are not strings.
+func coerceStruct(m map[string]any, t reflect.Type) map[string]any {
+	fields := jsonFields(t)
+	out := make(map[string]any, len(m))
+	for key, value := range m {
+		field, ok := fields[key]
+		if !ok {
+			out[key] = value
+			continue
+		}
+		if value == "" && baseKind(field) != reflect.String {
+			continue
+		}
+		out[key] = coerce(value, field)
+	}
+	return out
+}
+
+// jsonFields maps the JSON names of the fields of a struct type, including
+// those of embedded structs, to their types.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			for embedded, fieldType := range jsonFields(field.Type) {
+				fields[embedded] = fieldType
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+func baseKind(t reflect.Type) reflect.Kind {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Kind()
+}
+
+func isNumberKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"farmApp/pkg/api"
+	"farmApp/pkg/persistence"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// IdempotencyKeyHeader lets a client retry a request without repeating it.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// idempotentReplayedHeader marks a response that was stored for an earlier
+// request with the same key.
+const idempotentReplayedHeader = "Idempotent-Replayed"
+
+const (
+	maxIdempotencyKeyLength = 255
+	// idempotencyLease is how long a running request holds its key. A
+	// request that has not stored its response by then, for example because
+	// the process died, no longer blocks retries.
+	idempotencyLease = time.Minute
+	// maxIdempotentBodySize limits the request bodies read to fingerprint them.
+	maxIdempotentBodySize = 1 << 20
+)
+
+const problemIdempotencyKeyReused = "/problems/idempotency-key-reused"
+
+// replayedHeaders are the response headers stored with the body.
+var replayedHeaders = []string{"Content-Type", "ETag", "Location"}
+
+// Idempotency returns a middleware that answers retries of a request sent
+// with the same Idempotency-Key with the response to the first one, for ttl
+// after it was received. Reusing a key for a different request is answered
+// with 422, and retrying while the first request is still running with 409.
+// Server errors are not stored, so the request can be retried. Requests
+// without the header are passed through.
+func Idempotency(repo persistence.IdempotencyRepository, ttl time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				WriteProblem(w, r, http.StatusBadRequest,
+					IdempotencyKeyHeader+" must be at most "+strconv.Itoa(maxIdempotencyKeyLength)+" characters long")
+				return
+			}
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodySize))
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				handleError(w, r, err, http.StatusRequestEntityTooLarge)
+				return
+			} else if err != nil {
+				handleError(w, r, err, http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			fingerprint := requestFingerprint(r, body)
+			stored, reserved, err := repo.ReserveKey(r.Context(), key, fingerprint, ttl, idempotencyLease)
+			switch {
+			case err != nil:
+				handleError(w, r, err, http.StatusInternalServerError)
+			case !reserved && stored.Fingerprint != fingerprint:
+				writeProblem(w, r, api.Problem{Type: problemIdempotencyKeyReused, Title: "Idempotency key reused",
+					Status: http.StatusUnprocessableEntity,
+					Detail: "The " + IdempotencyKeyHeader + " was already used for a different request. Send a new key for a new request."})
+			case !reserved && stored.Status == 0:
+				w.Header().Set("Retry-After", "1")
+				WriteProblem(w, r, http.StatusConflict, "A request with this "+IdempotencyKeyHeader+" is still being processed. Retry later.")
+			case !reserved:
+				replayResponse(w, stored)
+			default:
+				serveReserved(w, r, next, repo, key)
+			}
+		})
+	}
+}
+
+// requestFingerprint identifies a request by its method, path, media type,
+// negotiated response format and body, so that only exact retries reuse a
+// key and a stored response is never replayed in a format the client does
+// not accept.
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	io.WriteString(hash, r.Method+" "+r.URL.RequestURI()+"\n"+r.Header.Get("Content-Type")+"\n"+
+		responseFormat(r).mediaType()+"\n")
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// serveReserved runs the request that reserved key and stores its response
+// for retries. The key is released if the handler panics, answers with a
+// server error or the response cannot be stored, so that the request can be
+// retried at once.
+func serveReserved(w http.ResponseWriter, r *http.Request, next http.Handler, repo persistence.IdempotencyRepository, key string) {
+	// The response has been sent before it is stored, so failures can only
+	// be logged.
+	ctx := context.WithoutCancel(r.Context())
+	stored := false
+	defer func() {
+		if !stored {
+			if err := repo.ReleaseKey(ctx, key); err != nil {
+				log.Printf("Request %s: failed to release its %s: %v", RequestIDFromContext(ctx), IdempotencyKeyHeader, err)
+			}
+		}
+	}()
+
+	recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+	next.ServeHTTP(recorder, r)
+	if recorder.status >= http.StatusInternalServerError {
+		return
+	}
+	header := map[string]string{}
+	for _, name := range replayedHeaders {
+		if value := recorder.Header().Get(name); value != "" {
+			header[name] = value
+		}
+	}
+	err := repo.CompleteKey(ctx, key, persistence.StoredResponse{Status: recorder.status, Header: header, Body: recorder.body.Bytes()})
+	if err != nil {
+		log.Printf("Request %s: failed to store the response for its %s: %v", RequestIDFromContext(ctx), IdempotencyKeyHeader, err)
+		return
+	}
+	stored = true
+}
+
+func replayResponse(w http.ResponseWriter, stored persistence.StoredResponse) {
+	for name, value := range stored.Header {
+		w.Header().Set(name, value)
+	}
+	w.Header().Set(idempotentReplayedHeader, "true")
+	w.WriteHeader(stored.Status)
+	w.Write(stored.Body)
+}
+
+// responseRecorder passes a response on while keeping a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status, r.wroteHeader = status, true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,399 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"farmApp/pkg/api"
+	"farmApp/pkg/persistence"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+const (
+	// maxImportSize limits the size of an uploaded file.
+	maxImportSize = 10 << 20
+	// maxImportRows limits the number of data rows of an uploaded file.
+	maxImportRows = 10000
+)
+
+// importFields are the customer fields a column can be mapped to, in the
+// order assumed for files without a header.
+var importFields = []string{"name", "role", "email", "phone", "contacted"}
+
+// namedDelimiters are the values of the delimiter parameter that name a
+// character.
+var namedDelimiters = map[string]string{"comma": ",", "semicolon": ";", "tab": "\t"}
+
+// ImportHandler creates customers from uploaded files.
+type ImportHandler struct {
+	repo persistence.BulkRepository
+}
+
+func NewImportHandler(repo persistence.BulkRepository) *ImportHandler {
+	return &ImportHandler{repo: repo}
+}
+
+// importOptions are the query parameters of a CSV import.
+type importOptions struct {
+	mode      persistence.BulkMode
+	dryRun    bool
+	delimiter rune
+	encoding  string
+	header    bool
+	// columns maps a field to a header cell or, as a number, a column.
+	columns map[string]string
+}
+
+// @Summary Import customers from CSV
+// @Description Create customers from the rows of a CSV file, sent as the request body or as the "file" field of a multipart form. Every row is validated like a created customer. In all-or-nothing mode (the default) no customer is created if any row fails; in best-effort mode the valid rows are kept. A dry run reports the same without storing anything.
+// @Tags customers
+// @Accept text/csv,multipart/form-data
+// @Produce json,xml,application/yaml,text/csv
+// @Param mode query string false "How failures are handled" Enums(all-or-nothing, best-effort)
+// @Param dry_run query bool false "Only validate the rows and report the customers that would be created"
+// @Param delimiter query string false "Field delimiter: a character (send ; as %3B) or comma, semicolon or tab (default comma)"
+// @Param encoding query string false "Character encoding, such as windows-1252 (default utf-8)"
+// @Param header query bool false "Whether the first row names the columns (default true)"
+// @Param columns query string false "Comma-separated field:column pairs, where column is a header cell or a column number, such as name:Kunde,email:E-Mail"
+// @Success 200 {object} api.ImportResponse "Every row succeeded"
+// @Success 207 {object} api.ImportResponse "Some rows failed"
+// @Failure 400 {object} api.Problem
+// @Failure 413 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /customers/import [post]
+func (h *ImportHandler) ImportCustomers(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseImportOptions(r)
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	file, err := importFile(w, r)
+	if err != nil {
+		handleImportError(w, r, err)
+		return
+	}
+	lines, customers, problems, err := readCSVCustomers(file, opts)
+	if err != nil {
+		handleImportError(w, r, err)
+		return
+	}
+
+	h.run(w, r, opts.mode, opts.dryRun, lines, customers, problems)
+}
+
+// run stores the customers without problems, unless dryRun is set, and
+// answers with the outcome of every row; lines are the lines of the rows.
+func (h *ImportHandler) run(w http.ResponseWriter, r *http.Request, mode persistence.BulkMode, dryRun bool,
+	lines []int, customers []api.Customer, problems []error) {
+	apply := func(valid []int) ([]persistence.BulkResult, error) {
+		batch := make([]api.Customer, len(valid))
+		for i, index := range valid {
+			batch[i] = customers[index]
+		}
+		return h.repo.CreateMany(r.Context(), batch, mode)
+	}
+	if dryRun {
+		apply = func(valid []int) ([]persistence.BulkResult, error) {
+			return make([]persistence.BulkResult, len(valid)), nil
+		}
+	}
+	results, err := applyBatch(mode, problems, apply)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+
+	response := api.ImportResponse{DryRun: dryRun, Mode: formatBulkMode(mode), Rows: make([]api.ImportRowResult, len(results))}
+	for i, result := range results {
+		row := api.ImportRowResult{Row: lines[i], Status: http.StatusCreated}
+		switch {
+		case dryRun && problems[i] == nil:
+			// Show the would-be insert even if the rest of the batch fails.
+			row.Customer = &customers[i]
+		case result.Err == nil:
+			row.Customer = &result.Customer
+		}
+		if result.Err != nil {
+			problem := completeProblem(problemFor(r, result.Err))
+			row.Status, row.Problem = problem.Status, &problem
+			response.Failed++
+		} else {
+			response.Succeeded++
+		}
+		response.Rows[i] = row
+	}
+	writeBatchResponse(w, r, response.Failed, response)
+}
+
+// parseImportMode reads the mode and dry_run query parameters.
+func parseImportMode(r *http.Request) (mode persistence.BulkMode, dryRun bool, err error) {
+	if mode, err = parseBulkMode(r); err != nil {
+		return mode, false, err
+	}
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			return mode, false, errors.New("dry_run must be true or false")
+		}
+	}
+	return mode, dryRun, nil
+}
+
+func parseImportOptions(r *http.Request) (importOptions, error) {
+	query := r.URL.Query()
+	opts := importOptions{delimiter: ',', encoding: "utf-8", header: true}
+
+	var err error
+	if opts.mode, opts.dryRun, err = parseImportMode(r); err != nil {
+		return opts, err
+	}
+	if value := query.Get("header"); value != "" {
+		if opts.header, err = strconv.ParseBool(value); err != nil {
+			return opts, errors.New("header must be true or false")
+		}
+	}
+	if value := query.Get("delimiter"); value != "" {
+		// A raw ; ends the query, so it has to be sent as %3B or by name.
+		if named, ok := namedDelimiters[value]; ok {
+			value = named
+		}
+		delimiter, size := utf8.DecodeRuneInString(value)
+		if size != len(value) || delimiter == '"' || delimiter == '\r' || delimiter == '\n' || delimiter == utf8.RuneError {
+			return opts, errors.New(`delimiter must be a single character other than " or a line break, or one of comma, semicolon and tab`)
+		}
+		opts.delimiter = delimiter
+	}
+	if value := query.Get("encoding"); value != "" {
+		if _, err := htmlindex.Get(value); err != nil {
+			return opts, fmt.Errorf("unknown encoding %q; use a name such as utf-8, windows-1252 or iso-8859-15", value)
+		}
+		opts.encoding = value
+	}
+
+	if value := query.Get("columns"); value != "" {
+		opts.columns = map[string]string{}
+		for _, pair := range strings.Split(value, ",") {
+			field, column, ok := strings.Cut(pair, ":")
+			field = strings.ToLower(strings.TrimSpace(field))
+			if !ok || !containsField(field) || strings.TrimSpace(column) == "" {
+				return opts, fmt.Errorf("columns must be field:column pairs with a field of %s", strings.Join(importFields, ", "))
+			}
+			opts.columns[field] = strings.TrimSpace(column)
+		}
+	}
+	return opts, nil
+}
+
+func containsField(field string) bool {
+	for _, f := range importFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// importFile returns the uploaded file: the "file" field of a multipart
+// form or else the request body.
+func importFile(w http.ResponseWriter, r *http.Request) (io.Reader, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return r.Body, nil
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, errors.New(`the form has no "file" field`)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "file" {
+			return part, nil
+		}
+	}
+}
+
+// errTooManyRows is returned for files with more than maxImportRows rows.
+var errTooManyRows = fmt.Errorf("the file has more than %d rows or vCards", maxImportRows)
+
+// handleImportError answers 413 for files that are too large and 400 for
+// files that cannot be read.
+func handleImportError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		handleError(w, r, fmt.Errorf("the file is larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, errTooManyRows):
+		handleError(w, r, err, http.StatusRequestEntityTooLarge)
+	default:
+		handleError(w, r, err, http.StatusBadRequest)
+	}
+}
+
+// readCSVCustomers decodes the customers of a CSV file. It returns the line
+// of every data row, its customer and the row's validation problem, if any.
+func readCSVCustomers(file io.Reader, opts importOptions) ([]int, []api.Customer, []error, error) {
+	encoding, _ := htmlindex.Get(opts.encoding)
+	buffered := bufio.NewReader(encoding.NewDecoder().Reader(file))
+	// Excel starts UTF-8 files with a byte order mark.
+	if bom, err := buffered.Peek(3); err == nil && bytes.Equal(bom, []byte("\xef\xbb\xbf")) {
+		buffered.Discard(3)
+	}
+	reader := csv.NewReader(buffered)
+	reader.Comma = opts.delimiter
+	reader.FieldsPerRecord = -1
+
+	var columns map[string]int
+	if opts.header {
+		header, err := reader.Read()
+		if err == io.EOF {
+			return nil, nil, nil, errors.New("the file is empty")
+		}
+		if err != nil {
+			return nil, nil, nil, describeCSVError(err)
+		}
+		if columns, err = mapColumns(header, opts.columns); err != nil {
+			return nil, nil, nil, err
+		}
+	} else {
+		var err error
+		if columns, err = mapColumns(nil, opts.columns); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	var lines []int
+	var customers []api.Customer
+	var problems []error
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, nil, describeCSVError(err)
+		}
+		if isBlankRecord(record) {
+			continue
+		}
+		if len(customers) == maxImportRows {
+			return nil, nil, nil, errTooManyRows
+		}
+		line, _ := reader.FieldPos(0)
+		customer, problem := recordCustomer(record, columns)
+		lines = append(lines, line)
+		customers = append(customers, customer)
+		problems = append(problems, problem)
+	}
+	if len(customers) == 0 {
+		return nil, nil, nil, errors.New("the file has no data rows")
+	}
+	return lines, customers, problems, nil
+}
+
+func describeCSVError(err error) error {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return fmt.Errorf("the file is not valid CSV: line %d: %v", parseErr.Line, parseErr.Err)
+	}
+	return err
+}
+
+// mapColumns finds the column of every field. Without a mapping, header
+// cells are matched to field names ignoring case, and files without a
+// header are read in the order of importFields.
+func mapColumns(header []string, mapping map[string]string) (map[string]int, error) {
+	columns := map[string]int{}
+	if mapping == nil {
+		for i, field := range importFields {
+			if header == nil {
+				columns[field] = i
+				continue
+			}
+			for j, cell := range header {
+				if strings.EqualFold(strings.TrimSpace(cell), field) {
+					columns[field] = j
+				}
+			}
+		}
+	}
+	for field, column := range mapping {
+		if number, err := strconv.Atoi(column); err == nil && number >= 1 {
+			columns[field] = number - 1
+			continue
+		}
+		found := false
+		for j, cell := range header {
+			if strings.EqualFold(strings.TrimSpace(cell), column) {
+				columns[field], found = j, true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("the file has no column %q for %s", column, field)
+		}
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, errors.New("the file has no name column; map one with columns=name:<column>")
+	}
+	return columns, nil
+}
+
+func isBlankRecord(record []string) bool {
+	for _, cell := range record {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// recordCustomer builds the customer of a data row and validates it.
+func recordCustomer(record []string, columns map[string]int) (api.Customer, error) {
+	cell := func(field string) string {
+		if column, ok := columns[field]; ok && column < len(record) {
+			return strings.TrimSpace(unescapeCSVCell(record[column]))
+		}
+		return ""
+	}
+	customer := api.Customer{Name: cell("name"), Role: cell("role"), Email: cell("email"), Phone: cell("phone")}
+
+	var fieldErrors []api.FieldError
+	contacted, ok := parseContacted(cell("contacted"))
+	if !ok {
+		fieldErrors = append(fieldErrors, api.FieldError{Field: "contacted", Message: "must be yes or no"})
+	}
+	customer.Contacted = contacted
+	var invalid *api.ValidationError
+	if errors.As(customer.Validate(), &invalid) {
+		fieldErrors = append(invalid.Errors, fieldErrors...)
+	}
+	if len(fieldErrors) > 0 {
+		return customer, &api.ValidationError{Message: "customer is invalid", Errors: fieldErrors}
+	}
+	return customer, nil
+}
+
+// parseContacted reads the spellings of yes and no common in spreadsheets.
+func parseContacted(value string) (contacted, ok bool) {
+	switch strings.ToLower(value) {
+	case "", "0", "false", "no", "n", "nein":
+		return false, true
+	case "1", "true", "yes", "y", "ja", "j", "x":
+		return true, true
+	}
+	return false, false
+}
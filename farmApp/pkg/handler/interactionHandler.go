@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"farmApp/pkg/api"
+	"farmApp/pkg/persistence"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InteractionHandler serves the interactions recorded with customers.
+type InteractionHandler struct {
+	repo persistence.InteractionRepository
+}
+
+func NewInteractionHandler(repo persistence.InteractionRepository) *InteractionHandler {
+	return &InteractionHandler{repo: repo}
+}
+
+// @Summary Get the interactions with a customer
+// @Description Get the calls, emails, visits and meetings recorded with a customer, most recent first
+// @Tags interactions
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Customer ID"
+// @Success 200 {array} api.Interaction
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /customers/{id}/interactions [get]
+func (h *InteractionHandler) GetInteractions(w http.ResponseWriter, r *http.Request) {
+	customerID, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	interactions, err := h.repo.ListInteractions(r.Context(), customerID)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	encodeResponse(w, r, interactions)
+}
+
+// @Summary Get an interaction with a customer
+// @Description Get an interaction with a customer
+// @Tags interactions
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Customer ID"
+// @Param interactionId path int true "Interaction ID"
+// @Success 200 {object} api.Interaction
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /customers/{id}/interactions/{interactionId} [get]
+func (h *InteractionHandler) GetInteraction(w http.ResponseWriter, r *http.Request) {
+	customerID, id, err := interactionIDs(r)
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	interaction, err := h.repo.GetInteraction(r.Context(), customerID, id)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	encodeResponse(w, r, interaction)
+}
+
+// @Summary Record an interaction with a customer
+// @Description Record a call, email, visit or meeting with a customer. occurred_at defaults to now and author to the X-Actor header. The customer's contact_count and last_contacted_at are updated and it is marked as contacted, as a new version recorded in its history.
+// @Tags interactions
+// @Accept json,xml,application/yaml,text/csv
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Customer ID"
+// @Param interaction body api.Interaction true "Interaction"
+// @Success 201 {object} api.Interaction
+// @Header 201 {string} Location "URL of the interaction"
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 422 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /customers/{id}/interactions [post]
+func (h *InteractionHandler) AddInteraction(w http.ResponseWriter, r *http.Request) {
+	customerID, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	var interaction api.Interaction
+	if err := decodeBody(r, &interaction); err != nil {
+		handleBodyError(w, r, err)
+		return
+	}
+	if interaction.Author == "" {
+		interaction.Author = persistence.ActorFromContext(r.Context())
+	}
+	if interaction.OccurredAt.IsZero() {
+		interaction.OccurredAt = time.Now()
+	}
+	interaction.Channel = strings.ToLower(interaction.Channel)
+	if err := interaction.Validate(); err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+
+	interaction, err = h.repo.AddInteraction(r.Context(), customerID, interaction)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/customers/"+strconv.Itoa(customerID)+"/interactions/"+strconv.Itoa(interaction.ID))
+	writeResponse(w, r, http.StatusCreated, interaction)
+}
+
+// @Summary Delete an interaction with a customer
+// @Description Delete an interaction recorded by mistake. The customer's contact_count and last_contacted_at are recomputed; it stays marked as contacted and gets a new version.
+// @Tags interactions
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Customer ID"
+// @Param interactionId path int true "Interaction ID"
+// @Success 204
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /customers/{id}/interactions/{interactionId} [delete]
+func (h *InteractionHandler) DeleteInteraction(w http.ResponseWriter, r *http.Request) {
+	customerID, id, err := interactionIDs(r)
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.DeleteInteraction(r.Context(), customerID, id); err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// interactionIDs reads the customer and interaction IDs from the path.
+func interactionIDs(r *http.Request) (customerID, id int, err error) {
+	if customerID, err = pathID(r, "id"); err != nil {
+		return 0, 0, err
+	}
+	id, err = pathID(r, "interactionId")
+	return customerID, id, err
+}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"errors"
+	"farmApp/pkg/api"
+	"farmApp/pkg/persistence"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// defaultDuplicateScore is the least score of a reported duplicate pair. It
+// includes customers that only share part of their name, such as members of
+// one household.
+const defaultDuplicateScore = 0.3
+
+// MergeHandler serves the duplicate report and merges customers.
+type MergeHandler struct {
+	customers persistence.CustomerRepository
+	repo      persistence.MergeRepository
+}
+
+func NewMergeHandler(customers persistence.CustomerRepository, repo persistence.MergeRepository) *MergeHandler {
+	return &MergeHandler{customers: customers, repo: repo}
+}
+
+// @Summary Find duplicate customers
+// @Description List pairs of customers that are likely duplicates, most likely first. The score combines a shared email, phone number and similar names; reasons explains it.
+// @Tags customers
+// @Produce json,xml,application/yaml,text/csv
+// @Param min_score query number false "Least score of a pair, from 0 to 1 (default 0.3)"
+// @Success 200 {array} api.DuplicatePair
+// @Failure 400 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /customers/duplicates [get]
+func (h *MergeHandler) GetDuplicates(w http.ResponseWriter, r *http.Request) {
+	minScore := defaultDuplicateScore
+	if value := r.URL.Query().Get("min_score"); value != "" {
+		var err error
+		minScore, err = strconv.ParseFloat(value, 64)
+		if err != nil || minScore < 0 || minScore > 1 {
+			handleError(w, r, errors.New("min_score must be a number between 0 and 1"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	pairs, err := h.repo.Duplicates(r.Context(), minScore)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	encodeResponse(w, r, pairs)
+}
+
+// @Summary Merge two customers
+// @Description Combine the source customer into the customer in the path, choosing per field which value survives, and move the source to the trash. Both histories record the merge.
+// @Tags customers
+// @Accept json,xml,application/yaml,text/csv
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "ID of the customer that is kept"
+// @Param If-Match header string true "ETag of the kept customer's version, or *"
+// @Param merge body api.MergeRequest true "Source customer and field choices"
+// @Success 200 {object} api.Customer
+// @Header 200 {string} ETag "Customer version"
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 412 {object} api.Problem
+// @Failure 422 {object} api.Problem
+// @Failure 428 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /customers/{id}/merge [post]
+func (h *MergeHandler) MergeCustomer(w http.ResponseWriter, r *http.Request) {
+	var request api.MergeRequest
+	if err := decodeBody(r, &request); err != nil {
+		handleBodyError(w, r, err)
+		return
+	}
+	switch {
+	case request.SourceID == 0:
+		handleError(w, r, errors.New("source_id is required"), http.StatusBadRequest)
+		return
+	case request.SourceVersion < 1:
+		handleRepositoryError(w, r, errMissingVersion)
+		return
+	}
+
+	id, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	version, present, err := expectedVersion(r)
+	if err != nil {
+		handleIfMatchError(w, r, err)
+		return
+	}
+	if !present {
+		requireIfMatch(w, r, h.customers, id)
+		return
+	}
+
+	customer, err := h.repo.Merge(r.Context(), id, version, request.SourceID, request.SourceVersion,
+		func(target, source api.Customer) (api.Customer, error) {
+			merged, err := mergeFields(target, source, request.Fields)
+			if err != nil {
+				return merged, err
+			}
+			return merged, merged.Validate()
+		})
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+
+	setETag(w, customer.Version)
+	encodeResponse(w, r, customer)
+}
+
+// mergeFields picks the surviving value of every field as described by
+// api.MergeRequest.
+func mergeFields(target, source api.Customer, choices map[string]string) (api.Customer, error) {
+	merged := target
+	fields := map[string]struct {
+		target, source *string
+	}{
+		"name":  {&merged.Name, &source.Name},
+		"role":  {&merged.Role, &source.Role},
+		"email": {&merged.Email, &source.Email},
+		"phone": {&merged.Phone, &source.Phone},
+	}
+	invalid := &api.ValidationError{Message: "fields is invalid"}
+	for field, choice := range choices {
+		if _, ok := fields[field]; !ok && field != "contacted" {
+			invalid.Errors = append(invalid.Errors, api.FieldError{Field: "fields." + field, Message: "is not a customer field that can be merged"})
+		} else if choice != api.MergeKeepTarget && choice != api.MergeKeepSource {
+			invalid.Errors = append(invalid.Errors, api.FieldError{Field: "fields." + field,
+				Message: fmt.Sprintf("must be %q or %q", api.MergeKeepTarget, api.MergeKeepSource)})
+		}
+	}
+	if len(invalid.Errors) > 0 {
+		slices.SortFunc(invalid.Errors, func(a, b api.FieldError) int { return strings.Compare(a.Field, b.Field) })
+		return merged, invalid
+	}
+
+	for field, values := range fields {
+		switch choices[field] {
+		case api.MergeKeepSource:
+			*values.target = *values.source
+		case "":
+			if *values.target == "" {
+				*values.target = *values.source
+			}
+		}
+	}
+	switch choices["contacted"] {
+	case api.MergeKeepSource:
+		merged.Contacted = source.Contacted
+	case "":
+		merged.Contacted = target.Contacted || source.Contacted
+	}
+	return merged, nil
+}
@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// format is a representation the API can read and write.
+type format int
+
+const (
+	formatJSON format = iota
+	formatXML
+	formatYAML
+	formatCSV
+)
+
+// formats lists the representations in order of preference for clients that
+// accept several equally.
+var formats = []format{formatJSON, formatXML, formatYAML, formatCSV}
+
+// mediaTypes names each format, its canonical type first. The others are
+// accepted as aliases in Accept and Content-Type headers.
+var mediaTypes = map[format][]string{
+	formatJSON: {"application/json"},
+	formatXML:  {"application/xml", "text/xml"},
+	formatYAML: {"application/yaml", "application/x-yaml", "text/yaml"},
+	formatCSV:  {"text/csv"},
+}
+
+// errUnsupportedBody is returned for a request body in a format the API
+// cannot read.
+var errUnsupportedBody = errors.New("Content-Type must be application/json, application/xml, application/yaml or text/csv")
+
+func (f format) String() string {
+	return [...]string{"JSON", "XML", "YAML", "CSV"}[f]
+}
+
+// mediaType is the Content-Type of responses in the format.
+func (f format) mediaType() string {
+	if f == formatCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return mediaTypes[f][0]
+}
+
+type formatKey struct{}
+
+// Negotiate is a middleware that picks the response format from the Accept
+// header, answering 406 Not Acceptable if the client accepts none of JSON,
+// XML, YAML and CSV. Without an Accept header the response is JSON.
+func Negotiate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := negotiateFormat(r.Header.Values("Accept"))
+		if !ok {
+			WriteProblem(w, r, http.StatusNotAcceptable,
+				"Accept must allow application/json, application/xml, application/yaml or text/csv")
+			return
+		}
+		w.Header().Add("Vary", "Accept")
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), formatKey{}, f)))
+	})
+}
+
+// responseFormat returns the format chosen by the Negotiate middleware,
+// or JSON if it did not run.
+func responseFormat(r *http.Request) format {
+	f, _ := r.Context().Value(formatKey{}).(format)
+	return f
+}
+
+// acceptRange is one media range of an Accept header.
+type acceptRange struct {
+	mediaType string
+	quality   float64
+}
+
+// negotiateFormat returns the format with the highest quality in the Accept
+// header, preferring the more specific range when several match.
+func negotiateFormat(accept []string) (format, bool) {
+	ranges := parseAccept(accept)
+	if len(ranges) == 0 {
+		return formatJSON, true
+	}
+	best, bestQuality := formatJSON, 0.0
+	for _, f := range formats {
+		if quality := acceptQuality(ranges, f); quality > bestQuality {
+			best, bestQuality = f, quality
+		}
+	}
+	return best, bestQuality > 0
+}
+
+// parseAccept reads the media ranges of Accept headers, skipping any that
+// are malformed.
+func parseAccept(accept []string) []acceptRange {
+	var ranges []acceptRange
+	for _, header := range accept {
+		for _, item := range strings.Split(header, ",") {
+			if strings.TrimSpace(item) == "" {
+				continue
+			}
+			mediaType, params, err := mime.ParseMediaType(item)
+			if err != nil {
+				continue
+			}
+			quality := 1.0
+			if q, ok := params["q"]; ok {
+				quality, err = strconv.ParseFloat(q, 64)
+				if err != nil || quality < 0 || quality > 1 {
+					continue
+				}
+			}
+			ranges = append(ranges, acceptRange{mediaType, quality})
+		}
+	}
+	return ranges
+}
+
+// acceptQuality is the quality the client gives to the format: the quality
+// of the most specific range matching any of its media types.
+func acceptQuality(ranges []acceptRange, f format) float64 {
+	quality, specificity := 0.0, -1
+	for _, mediaType := range mediaTypes[f] {
+		for _, r := range ranges {
+			s := matchSpecificity(r.mediaType, mediaType)
+			if s < 0 {
+				continue
+			}
+			if s > specificity || (s == specificity && r.quality > quality) {
+				quality, specificity = r.quality, s
+			}
+		}
+	}
+	return quality
+}
+
+// matchSpecificity is 2 if the range names the media type, 1 if it matches
+// its subtypes, 0 for */* and -1 if it does not match.
+func matchSpecificity(mediaRange, mediaType string) int {
+	switch {
+	case mediaRange == mediaType:
+		return 2
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*")):
+		return 1
+	}
+	return -1
+}
+
+// requestFormat returns the format of the request body from its
+// Content-Type, which defaults to JSON.
+func requestFormat(r *http.Request) (format, error) {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return formatJSON, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return 0, errUnsupportedBody
+	}
+	for _, f := range formats {
+		for _, t := range mediaTypes[f] {
+			if mediaType == t {
+				return f, nil
+			}
+		}
+	}
+	return 0, errUnsupportedBody
+}
@@ -0,0 +1,219 @@
+package handler
+
+import (
+	"errors"
+	"farmApp/pkg/api"
+	"farmApp/pkg/persistence"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// OrderHandler serves the orders of customers.
+type OrderHandler struct {
+	customers persistence.CustomerRepository
+	repo      persistence.OrderRepository
+}
+
+func NewOrderHandler(customers persistence.CustomerRepository, repo persistence.OrderRepository) *OrderHandler {
+	return &OrderHandler{customers: customers, repo: repo}
+}
+
+// @Summary Get all orders
+// @Description Get the orders matching the filters, newest first. Orders of customers in the trash are left out.
+// @Tags orders
+// @Produce json,xml,application/yaml,text/csv
+// @Param customer_id query int false "Only orders of this customer" minimum(1)
+// @Param status query string false "Only orders with this status" Enums(pending, confirmed, shipped, delivered, cancelled)
+// @Success 200 {array} api.Order
+// @Failure 400 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /orders [get]
+func (h *OrderHandler) GetOrders(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseOrderFilter(r)
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	if value := r.URL.Query().Get("customer_id"); value != "" {
+		if filter.CustomerID, err = strconv.Atoi(value); err != nil || filter.CustomerID <= 0 {
+			handleError(w, r, errors.New("customer_id must be a positive integer"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	orders, err := h.repo.ListOrders(r.Context(), filter)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	encodeResponse(w, r, orders)
+}
+
+// @Summary Get the orders of a customer
+// @Description Get the purchase history of a customer, newest first
+// @Tags orders
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Customer ID"
+// @Param status query string false "Only orders with this status" Enums(pending, confirmed, shipped, delivered, cancelled)
+// @Success 200 {array} api.Order
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /customers/{id}/orders [get]
+func (h *OrderHandler) GetCustomerOrders(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	filter, err := parseOrderFilter(r)
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	if _, err := h.customers.Get(r.Context(), id); err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+
+	filter.CustomerID = id
+	orders, err := h.repo.ListOrders(r.Context(), filter)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	encodeResponse(w, r, orders)
+}
+
+// @Summary Get an order
+// @Description Get an order by ID
+// @Tags orders
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Order ID"
+// @Success 200 {object} api.Order
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /orders/{id} [get]
+func (h *OrderHandler) GetOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	order, err := h.repo.GetOrder(r.Context(), id)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	encodeResponse(w, r, order)
+}
+
+// @Summary Place an order
+// @Description Place an order for a customer. Each line names a product and a quantity; the product name and unit price are copied from the catalog. The status defaults to pending.
+// @Tags orders
+// @Accept json,xml,application/yaml
+// @Produce json,xml,application/yaml,text/csv
+// @Param order body api.Order true "Order"
+// @Success 201 {object} api.Order
+// @Header 201 {string} Location "URL of the order"
+// @Failure 400 {object} api.Problem
+// @Failure 422 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /orders [post]
+func (h *OrderHandler) AddOrder(w http.ResponseWriter, r *http.Request) {
+	var order api.Order
+	if err := decodeBody(r, &order); err != nil {
+		handleBodyError(w, r, err)
+		return
+	}
+	if order.Status == "" {
+		order.Status = api.StatusPending
+	}
+	if err := order.Validate(); err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+
+	order, err := h.repo.CreateOrder(r.Context(), order)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/orders/"+strconv.Itoa(*order.ID))
+	writeResponse(w, r, http.StatusCreated, order)
+}
+
+// @Summary Update an order
+// @Description Replace the status and lines of an order. A pending order can be confirmed or cancelled, a confirmed one shipped or cancelled, and a shipped one delivered. Lines can only change while the order is pending; lines with a new product or quantity get the current catalog price, the others keep theirs.
+// @Tags orders
+// @Accept json,xml,application/yaml
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Order ID"
+// @Param order body api.Order true "Order"
+// @Success 200 {object} api.Order
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 409 {object} api.Problem
+// @Failure 422 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /orders/{id} [put]
+func (h *OrderHandler) UpdateOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	var order api.Order
+	if err := decodeBody(r, &order); err != nil {
+		handleBodyError(w, r, err)
+		return
+	}
+	if err := order.Validate(); err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+
+	order, err = h.repo.UpdateOrder(r.Context(), id, order)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	encodeResponse(w, r, order)
+}
+
+// @Summary Delete an order
+// @Description Delete a pending or cancelled order
+// @Tags orders
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Order ID"
+// @Success 204
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 409 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /orders/{id} [delete]
+func (h *OrderHandler) DeleteOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.DeleteOrder(r.Context(), id); err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func parseOrderFilter(r *http.Request) (persistence.OrderFilter, error) {
+	filter := persistence.OrderFilter{Status: r.URL.Query().Get("status")}
+	if filter.Status != "" && !slices.Contains(api.Statuses, filter.Status) {
+		return filter, errors.New("status must be one of " + strings.Join(api.Statuses, ", "))
+	}
+	return filter, nil
+}
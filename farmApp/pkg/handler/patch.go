@@ -0,0 +1,327 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"farmApp/pkg/api"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Media types accepted by PATCH.
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// errUnsupportedPatch is returned for a PATCH with an unknown Content-Type.
+var errUnsupportedPatch = errors.New("Content-Type must be " + mergePatchType + " or " + jsonPatchType)
+
+// patchError is a patch that is well-formed but cannot be applied.
+type patchError struct {
+	status  int
+	message string
+}
+
+func (e *patchError) Error() string {
+	return e.message
+}
+
+func unprocessablePatch(format string, args ...any) error {
+	return &patchError{status: http.StatusUnprocessableEntity, message: fmt.Sprintf(format, args...)}
+}
+
+// patchOperation is one operation of an RFC 6902 JSON Patch.
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+
+	path, from []string
+	value      any
+}
+
+// parseJSONPatch decodes a JSON Patch document and checks that every
+// operation is complete.
+func parseJSONPatch(data []byte) ([]patchOperation, error) {
+	var ops []patchOperation
+	if err := json.Unmarshal(data, &ops); err != nil {
+		return nil, fmt.Errorf("JSON Patch must be an array of operations: %w", err)
+	}
+	for i := range ops {
+		op := &ops[i]
+		var err error
+		if op.path, err = parsePointer(op.Path); err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				return nil, fmt.Errorf("operation %d: %s needs a value", i, op.Op)
+			}
+			if err := json.Unmarshal(op.Value, &op.value); err != nil {
+				return nil, fmt.Errorf("operation %d: %w", i, err)
+			}
+		case "move", "copy":
+			if op.from, err = parsePointer(op.From); err != nil {
+				return nil, fmt.Errorf("operation %d: from: %w", i, err)
+			}
+		case "remove":
+		default:
+			return nil, fmt.Errorf("operation %d: unknown op %q", i, op.Op)
+		}
+	}
+	return ops, nil
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into its reference tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path %q must start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+// applyJSONPatch applies the operations in order; doc may be modified.
+func applyJSONPatch(doc any, ops []patchOperation) (any, error) {
+	var err error
+	for i, op := range ops {
+		switch op.Op {
+		case "add":
+			doc, err = addValue(doc, op.path, op.value)
+		case "remove":
+			doc, _, err = removeValue(doc, op.path)
+		case "replace":
+			if doc, _, err = removeValue(doc, op.path); err == nil {
+				doc, err = addValue(doc, op.path, op.value)
+			}
+		case "move":
+			if isPrefix(op.from, op.path) && len(op.from) < len(op.path) {
+				return nil, unprocessablePatch("operation %d: cannot move %s into itself", i, op.From)
+			}
+			var value any
+			if doc, value, err = removeValue(doc, op.from); err == nil {
+				doc, err = addValue(doc, op.path, value)
+			}
+		case "copy":
+			var value any
+			if value, err = getValue(doc, op.from); err == nil {
+				doc, err = addValue(doc, op.path, deepCopy(value))
+			}
+		case "test":
+			var value any
+			if value, err = getValue(doc, op.path); err == nil && !reflect.DeepEqual(value, op.value) {
+				return nil, &patchError{status: http.StatusConflict, message: fmt.Sprintf("operation %d: test of %s failed", i, op.Path)}
+			}
+		}
+		if err != nil {
+			return nil, unprocessablePatch("operation %d: %v", i, err)
+		}
+	}
+	return doc, nil
+}
+
+func isPrefix(prefix, path []string) bool {
+	return len(prefix) <= len(path) && slices.Equal(prefix, path[:len(prefix)])
+}
+
+func getValue(doc any, path []string) (any, error) {
+	for _, token := range path {
+		switch container := doc.(type) {
+		case map[string]any:
+			value, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q does not exist", token)
+			}
+			doc = value
+		case []any:
+			i, err := arrayIndex(token, len(container))
+			if err != nil {
+				return nil, err
+			}
+			doc = container[i]
+		default:
+			return nil, fmt.Errorf("cannot look up %q in a scalar", token)
+		}
+	}
+	return doc, nil
+}
+
+// updateParent calls change with the container holding the last token of
+// path and stores the container it returns in place of the old one.
+func updateParent(doc any, path []string, change func(container any, token string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return change(doc, path[0])
+	}
+	child, err := getValue(doc, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	child, err = updateParent(child, path[1:], change)
+	if err != nil {
+		return nil, err
+	}
+	switch container := doc.(type) {
+	case map[string]any:
+		container[path[0]] = child
+	case []any:
+		i, _ := arrayIndex(path[0], len(container))
+		container[i] = child
+	}
+	return doc, nil
+}
+
+func addValue(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return updateParent(doc, path, func(container any, token string) (any, error) {
+		switch container := container.(type) {
+		case map[string]any:
+			container[token] = value
+			return container, nil
+		case []any:
+			i := len(container)
+			if token != "-" {
+				var err error
+				if i, err = arrayIndex(token, len(container)+1); err != nil {
+					return nil, err
+				}
+			}
+			return slices.Insert(container, i, value), nil
+		}
+		return nil, fmt.Errorf("cannot add %q to a scalar", token)
+	})
+}
+
+func removeValue(doc any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, doc, nil
+	}
+	var removed any
+	doc, err := updateParent(doc, path, func(container any, token string) (any, error) {
+		switch container := container.(type) {
+		case map[string]any:
+			value, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q does not exist", token)
+			}
+			removed = value
+			delete(container, token)
+			return container, nil
+		case []any:
+			i, err := arrayIndex(token, len(container))
+			if err != nil {
+				return nil, err
+			}
+			removed = container[i]
+			return slices.Delete(container, i, i+1), nil
+		}
+		return nil, fmt.Errorf("cannot remove %q from a scalar", token)
+	})
+	return doc, removed, err
+}
+
+// arrayIndex parses an array index below limit.
+func arrayIndex(token string, limit int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i >= limit || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("index %q is out of range", token)
+	}
+	return i, nil
+}
+
+func deepCopy(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		copied := make(map[string]any, len(value))
+		for k, v := range value {
+			copied[k] = deepCopy(v)
+		}
+		return copied
+	case []any:
+		copied := make([]any, len(value))
+		for i, v := range value {
+			copied[i] = deepCopy(v)
+		}
+		return copied
+	}
+	return value
+}
+
+// applyMergePatch applies an RFC 7396 JSON Merge Patch; target may be modified.
+func applyMergePatch(target, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = map[string]any{}
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+		} else {
+			targetObject[name] = applyMergePatch(targetObject[name], value)
+		}
+	}
+	return targetObject
+}
+
+// patchCustomer applies a patch to the JSON representation of a customer.
+// The fields set by the server must not change.
+func patchCustomer(current api.Customer, patch func(doc any) (any, error)) (api.Customer, error) {
+	data, err := json.Marshal(current)
+	if err != nil {
+		return api.Customer{}, err
+	}
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return api.Customer{}, err
+	}
+	if doc, err = patch(doc); err != nil {
+		return api.Customer{}, err
+	}
+	if data, err = json.Marshal(doc); err != nil {
+		return api.Customer{}, err
+	}
+
+	var patched api.Customer
+	if err := json.Unmarshal(data, &patched); err != nil {
+		return api.Customer{}, unprocessablePatch("patched customer is invalid: %v", describeJSONError(err))
+	}
+	for field, changed := range map[string]bool{
+		"id":                !reflect.DeepEqual(patched.ID, current.ID),
+		"version":           patched.Version != current.Version,
+		"created_at":        !equalTimes(patched.CreatedAt, current.CreatedAt),
+		"deleted_at":        patched.DeletedAt != nil,
+		"contact_count":     patched.ContactCount != current.ContactCount,
+		"last_contacted_at": !equalTimes(patched.LastContactedAt, current.LastContactedAt),
+	} {
+		if changed {
+			return api.Customer{}, unprocessablePatch("%s cannot be changed", field)
+		}
+	}
+	return patched, nil
+}
+
+func equalTimes(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
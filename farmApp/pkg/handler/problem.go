@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"farmApp/pkg/api"
+	"farmApp/pkg/persistence"
+	"log"
+	"net/http"
+	"regexp"
+)
+
+// RequestIDHeader carries the ID that identifies a request in the logs and
+// in problem responses.
+const RequestIDHeader = "X-Request-ID"
+
+// Problem types with a meaning beyond their status code.
+const (
+	problemValidation      = "/problems/validation"
+	problemVersionConflict = "/problems/version-conflict"
+	problemPatchTestFailed = "/problems/patch-test-failed"
+)
+
+// problemNamespace is the XML namespace of problem details (RFC 7807,
+// appendix A).
+const problemNamespace = "urn:ietf:rfc:7807"
+
+const internalErrorDetail = "The request could not be completed. Please quote the request ID when reporting this."
+
+// clientRequestID matches request IDs a client may choose itself.
+var clientRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+type requestIDKey struct{}
+
+// RequestID is a middleware that gives every request an ID, taken from the
+// X-Request-ID header if the client sent a usable one, and returns it in
+// the X-Request-ID response header.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !clientRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// RequestIDFromContext returns the ID set by the RequestID middleware, or "".
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// WriteProblem answers with an about:blank problem for the status code.
+func WriteProblem(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	writeProblem(w, r, api.Problem{Status: status, Detail: detail})
+}
+
+// writeProblem sends the problem with the request ID, filling in the type
+// and title if they are missing. Clients that negotiated XML get
+// application/problem+xml, all others application/problem+json.
+func writeProblem(w http.ResponseWriter, r *http.Request, problem api.Problem) {
+	problem = completeProblem(problem)
+	problem.RequestID = RequestIDFromContext(r.Context())
+	if responseFormat(r) == formatXML {
+		body, _ := json.Marshal(problem)
+		w.Header().Set("Content-Type", "application/problem+xml")
+		w.WriteHeader(problem.Status)
+		writeXML(w, "problem", []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: problemNamespace}}, body)
+		return
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// completeProblem defaults the type to about:blank and the title to the
+// text of the status code.
+func completeProblem(problem api.Problem) api.Problem {
+	if problem.Type == "" {
+		problem.Type = "about:blank"
+	}
+	if problem.Title == "" {
+		problem.Title = http.StatusText(problem.Status)
+	}
+	return problem
+}
+
+// problemFor describes an error returned by validation or a repository.
+// Unexpected errors become a 500 whose text is only logged, since it may
+// reveal details of the database.
+func problemFor(r *http.Request, err error) api.Problem {
+	var invalid *api.ValidationError
+	switch {
+	case errors.As(err, &invalid):
+		return api.Problem{Type: problemValidation, Title: "Validation failed", Status: http.StatusUnprocessableEntity,
+			Detail: invalid.Message, Errors: invalid.Errors}
+	case errors.Is(err, persistence.ErrNotFound):
+		return api.Problem{Status: http.StatusNotFound, Detail: "Customer not found"}
+	case errors.Is(err, persistence.ErrInteractionNotFound):
+		return api.Problem{Status: http.StatusNotFound, Detail: "Interaction not found"}
+	case errors.Is(err, persistence.ErrAddressNotFound):
+		return api.Problem{Status: http.StatusNotFound, Detail: "Address not found"}
+	case errors.Is(err, persistence.ErrFarmNotFound):
+		return api.Problem{Status: http.StatusNotFound, Detail: "Farm not found"}
+	case errors.Is(err, persistence.ErrFarmContactNotFound):
+		return api.Problem{Status: http.StatusNotFound, Detail: "Customer is not a contact of this farm"}
+	case errors.Is(err, persistence.ErrProductNotFound):
+		return api.Problem{Status: http.StatusNotFound, Detail: "Product not found"}
+	case errors.Is(err, persistence.ErrOrderNotFound):
+		return api.Problem{Status: http.StatusNotFound, Detail: "Order not found"}
+	case errors.Is(err, persistence.ErrTagNotFound):
+		return api.Problem{Status: http.StatusNotFound, Detail: "Tag not found"}
+	case errors.Is(err, persistence.ErrCustomerTagNotFound):
+		return api.Problem{Status: http.StatusNotFound, Detail: "Customer does not have this tag"}
+	case errors.Is(err, persistence.ErrTagExists):
+		return api.Problem{Status: http.StatusConflict, Detail: "A tag with this name already exists. Merge the tags instead."}
+	case errors.Is(err, persistence.ErrOrderLocked):
+		return api.Problem{Status: http.StatusConflict, Detail: err.Error()}
+	case errors.Is(err, persistence.ErrFarmHasContacts):
+		return api.Problem{Status: http.StatusConflict,
+			Detail: "The farm still has contacts. Unlink them first, or delete it with cascade=unlink or cascade=trash."}
+	case errors.Is(err, persistence.ErrInvalidCursor):
+		return api.Problem{Status: http.StatusBadRequest, Detail: err.Error()}
+	case errors.Is(err, persistence.ErrVersionConflict):
+		return api.Problem{Type: problemVersionConflict, Title: "Version conflict", Status: http.StatusPreconditionFailed,
+			Detail: "The customer was modified by another request. Fetch it again and retry with its new ETag."}
+	case errors.Is(err, persistence.ErrBatchAborted):
+		return api.Problem{Status: http.StatusFailedDependency, Detail: err.Error()}
+	case errors.Is(err, persistence.ErrSelfMerge), errors.Is(err, persistence.ErrTagSelfMerge):
+		return api.Problem{Status: http.StatusBadRequest, Detail: err.Error()}
+	case errors.Is(err, errMissingID):
+		return api.Problem{Status: http.StatusBadRequest, Detail: err.Error()}
+	case errors.Is(err, errMissingVersion):
+		return api.Problem{Status: http.StatusPreconditionRequired, Detail: err.Error()}
+	}
+	log.Printf("Request %s failed: %v", RequestIDFromContext(r.Context()), err)
+	return api.Problem{Status: http.StatusInternalServerError, Detail: internalErrorDetail}
+}
+
+// handleRepositoryError answers with the problem describing a validation
+// or repository error.
+func handleRepositoryError(w http.ResponseWriter, r *http.Request, err error) {
+	writeProblem(w, r, problemFor(r, err))
+}
+
+// handleError answers with a problem describing err. The text of server
+// errors is only logged, since it may reveal details of the database.
+func handleError(w http.ResponseWriter, r *http.Request, err error, statusCode int) {
+	if statusCode >= http.StatusInternalServerError {
+		log.Printf("Request %s failed: %v", RequestIDFromContext(r.Context()), err)
+		WriteProblem(w, r, statusCode, internalErrorDetail)
+		return
+	}
+	WriteProblem(w, r, statusCode, err.Error())
+}
+
+// InternalServerError logs err and answers 500 without revealing it.
+func InternalServerError(w http.ResponseWriter, r *http.Request, err error) {
+	handleError(w, r, err, http.StatusInternalServerError)
+}
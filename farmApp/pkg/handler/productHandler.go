@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"errors"
+	"farmApp/pkg/api"
+	"farmApp/pkg/persistence"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ProductHandler serves the product catalog.
+type ProductHandler struct {
+	repo persistence.ProductRepository
+}
+
+func NewProductHandler(repo persistence.ProductRepository) *ProductHandler {
+	return &ProductHandler{repo: repo}
+}
+
+// @Summary Get all products
+// @Description Get the product catalog ordered by name
+// @Tags products
+// @Produce json,xml,application/yaml,text/csv
+// @Param category query string false "Only products of this category" Enums(seed, feed, fertilizer, equipment, other)
+// @Success 200 {array} api.Product
+// @Failure 400 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /products [get]
+func (h *ProductHandler) GetProducts(w http.ResponseWriter, r *http.Request) {
+	category := r.URL.Query().Get("category")
+	if category != "" && !slices.Contains(api.Categories, category) {
+		handleError(w, r, errors.New("category must be one of "+strings.Join(api.Categories, ", ")), http.StatusBadRequest)
+		return
+	}
+
+	products, err := h.repo.ListProducts(r.Context(), category)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	encodeResponse(w, r, products)
+}
+
+// @Summary Get a product
+// @Description Get a product by ID
+// @Tags products
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Product ID"
+// @Success 200 {object} api.Product
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /products/{id} [get]
+func (h *ProductHandler) GetProduct(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	product, err := h.repo.GetProduct(r.Context(), id)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	encodeResponse(w, r, product)
+}
+
+// @Summary Add a new product
+// @Description Add a product to the catalog
+// @Tags products
+// @Accept json,xml,application/yaml,text/csv
+// @Produce json,xml,application/yaml,text/csv
+// @Param product body api.Product true "Product"
+// @Success 201 {object} api.Product
+// @Header 201 {string} Location "URL of the product"
+// @Failure 400 {object} api.Problem
+// @Failure 422 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /products [post]
+func (h *ProductHandler) AddProduct(w http.ResponseWriter, r *http.Request) {
+	product, ok := decodeProduct(w, r)
+	if !ok {
+		return
+	}
+
+	product, err := h.repo.CreateProduct(r.Context(), product)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/products/"+strconv.Itoa(*product.ID))
+	writeResponse(w, r, http.StatusCreated, product)
+}
+
+// @Summary Update a product
+// @Description Replace a product. Existing orders keep the name and price they were placed with.
+// @Tags products
+// @Accept json,xml,application/yaml,text/csv
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Product ID"
+// @Param product body api.Product true "Product"
+// @Success 200 {object} api.Product
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 422 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /products/{id} [put]
+func (h *ProductHandler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	product, ok := decodeProduct(w, r)
+	if !ok {
+		return
+	}
+
+	product, err = h.repo.UpdateProduct(r.Context(), id, product)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	encodeResponse(w, r, product)
+}
+
+// @Summary Delete a product
+// @Description Remove a product from the catalog. Existing orders keep their lines.
+// @Tags products
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Product ID"
+// @Success 204
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /products/{id} [delete]
+func (h *ProductHandler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.DeleteProduct(r.Context(), id); err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeProduct reads and validates a product from the request body,
+// answering the request if it is invalid.
+func decodeProduct(w http.ResponseWriter, r *http.Request) (api.Product, bool) {
+	var product api.Product
+	if err := decodeBody(r, &product); err != nil {
+		handleBodyError(w, r, err)
+		return product, false
+	}
+	if err := product.Validate(); err != nil {
+		handleRepositoryError(w, r, err)
+		return product, false
+	}
+	return product, true
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"errors"
+	"farmApp/pkg/persistence"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// SearchHandler serves the full-text search over customers.
+type SearchHandler struct {
+	repo persistence.SearchRepository
+}
+
+func NewSearchHandler(repo persistence.SearchRepository) *SearchHandler {
+	return &SearchHandler{repo: repo}
+}
+
+// @Summary Search customers
+// @Description Find customers whose name, role, email or phone contain words starting with every word of q, ignoring case and diacritics. Umlauts also match their transliteration, so "Müller" finds "Mueller". Results are ranked with matches in the name first and carry the matching fields as HTML with <mark> around the matched words.
+// @Tags customers
+// @Produce json,xml,application/yaml,text/csv
+// @Param q query string true "Search words"
+// @Param limit query int false "Maximum number of results (default 20, at most 100)"
+// @Success 200 {array} api.SearchResult
+// @Failure 400 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /customers/search [get]
+func (h *SearchHandler) SearchCustomers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		handleError(w, r, errors.New("q is required"), http.StatusBadRequest)
+		return
+	}
+	limit := defaultSearchLimit
+	if value := query.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxSearchLimit {
+			handleError(w, r, fmt.Errorf("limit must be between 1 and %d", maxSearchLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	results, err := h.repo.Search(r.Context(), q, limit)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	encodeResponse(w, r, results)
+}
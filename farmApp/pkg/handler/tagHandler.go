@@ -0,0 +1,282 @@
+package handler
+
+import (
+	"errors"
+	"farmApp/pkg/api"
+	"farmApp/pkg/persistence"
+	"net/http"
+	"strconv"
+)
+
+// TagHandler serves tags and their assignment to customers.
+type TagHandler struct {
+	repo persistence.TagRepository
+}
+
+func NewTagHandler(repo persistence.TagRepository) *TagHandler {
+	return &TagHandler{repo: repo}
+}
+
+// @Summary Get all tags
+// @Description Get all tags ordered by name, each with its number of customers
+// @Tags tags
+// @Produce json,xml,application/yaml,text/csv
+// @Success 200 {array} api.Tag
+// @Failure 500 {object} api.Problem
+// @Router /tags [get]
+func (h *TagHandler) GetTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := h.repo.ListTags(r.Context())
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	encodeResponse(w, r, tags)
+}
+
+// @Summary Get a tag
+// @Description Get a tag by ID
+// @Tags tags
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Tag ID"
+// @Success 200 {object} api.Tag
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /tags/{id} [get]
+func (h *TagHandler) GetTag(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	tag, err := h.repo.GetTag(r.Context(), id)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	encodeResponse(w, r, tag)
+}
+
+// @Summary Add a new tag
+// @Description Add a tag. Names are unique ignoring case.
+// @Tags tags
+// @Accept json,xml,application/yaml,text/csv
+// @Produce json,xml,application/yaml,text/csv
+// @Param tag body api.Tag true "Tag"
+// @Success 201 {object} api.Tag
+// @Header 201 {string} Location "URL of the tag"
+// @Failure 400 {object} api.Problem
+// @Failure 409 {object} api.Problem
+// @Failure 422 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /tags [post]
+func (h *TagHandler) AddTag(w http.ResponseWriter, r *http.Request) {
+	tag, ok := decodeTag(w, r)
+	if !ok {
+		return
+	}
+
+	tag, err := h.repo.CreateTag(r.Context(), tag.Name)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/tags/"+strconv.Itoa(tag.ID))
+	writeResponse(w, r, http.StatusCreated, tag)
+}
+
+// @Summary Rename a tag
+// @Description Rename a tag on all its customers. Renaming it to the name of another tag fails; merge the tags instead.
+// @Tags tags
+// @Accept json,xml,application/yaml,text/csv
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Tag ID"
+// @Param tag body api.Tag true "Tag"
+// @Success 200 {object} api.Tag
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 409 {object} api.Problem
+// @Failure 422 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /tags/{id} [put]
+func (h *TagHandler) RenameTag(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	tag, ok := decodeTag(w, r)
+	if !ok {
+		return
+	}
+
+	tag, err = h.repo.RenameTag(r.Context(), id, tag.Name)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	encodeResponse(w, r, tag)
+}
+
+// @Summary Delete a tag
+// @Description Remove a tag from all customers and delete it
+// @Tags tags
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Tag ID"
+// @Success 204
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /tags/{id} [delete]
+func (h *TagHandler) DeleteTag(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.DeleteTag(r.Context(), id); err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary Merge two tags
+// @Description Give the customers of the source tag the tag in the path and delete the source tag
+// @Tags tags
+// @Accept json,xml,application/yaml,text/csv
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "ID of the tag that is kept"
+// @Param merge body api.TagMergeRequest true "Source tag"
+// @Success 200 {object} api.Tag
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /tags/{id}/merge [post]
+func (h *TagHandler) MergeTag(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	var request api.TagMergeRequest
+	if err := decodeBody(r, &request); err != nil {
+		handleBodyError(w, r, err)
+		return
+	}
+	if request.SourceID == 0 {
+		handleError(w, r, errors.New("source_id is required"), http.StatusBadRequest)
+		return
+	}
+
+	tag, err := h.repo.MergeTags(r.Context(), id, request.SourceID)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	encodeResponse(w, r, tag)
+}
+
+// @Summary Get the tags of a customer
+// @Description Get the tags of a customer ordered by name
+// @Tags tags
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Customer ID"
+// @Success 200 {array} api.Tag
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /customers/{id}/tags [get]
+func (h *TagHandler) GetCustomerTags(w http.ResponseWriter, r *http.Request) {
+	customerID, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	tags, err := h.repo.ListCustomerTags(r.Context(), customerID)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	encodeResponse(w, r, tags)
+}
+
+// @Summary Tag a customer
+// @Description Give a customer the tag with the name, creating the tag if there is none. Tagging a customer twice changes nothing. The customer's version does not change.
+// @Tags tags
+// @Accept json,xml,application/yaml,text/csv
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Customer ID"
+// @Param tag body api.Tag true "Tag; only the name is read"
+// @Success 200 {object} api.Tag
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 422 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /customers/{id}/tags [post]
+func (h *TagHandler) TagCustomer(w http.ResponseWriter, r *http.Request) {
+	customerID, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	tag, ok := decodeTag(w, r)
+	if !ok {
+		return
+	}
+
+	tag, err = h.repo.TagCustomer(r.Context(), customerID, tag.Name)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	encodeResponse(w, r, tag)
+}
+
+// @Summary Remove a tag from a customer
+// @Description Remove a tag from a customer; the tag itself is kept
+// @Tags tags
+// @Produce json,xml,application/yaml,text/csv
+// @Param id path int true "Customer ID"
+// @Param tagId path int true "Tag ID"
+// @Success 204
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /customers/{id}/tags/{tagId} [delete]
+func (h *TagHandler) UntagCustomer(w http.ResponseWriter, r *http.Request) {
+	customerID, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	tagID, err := pathID(r, "tagId")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.repo.UntagCustomer(r.Context(), customerID, tagID); err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeTag reads and validates the tag in the request body. It writes the
+// error response and returns false if the tag cannot be used.
+func decodeTag(w http.ResponseWriter, r *http.Request) (api.Tag, bool) {
+	var tag api.Tag
+	if err := decodeBody(r, &tag); err != nil {
+		handleBodyError(w, r, err)
+		return tag, false
+	}
+	if err := tag.Validate(); err != nil {
+		handleRepositoryError(w, r, err)
+		return tag, false
+	}
+	return tag, true
+}
@@ -0,0 +1,286 @@
+package handler
+
+import (
+	"bufio"
+	"errors"
+	"farmApp/pkg/api"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// vcardType is the media type of vCards (RFC 6350).
+const vcardType = "text/vcard"
+
+// maxVCardLine is the length in octets at which vCard lines are folded.
+const maxVCardLine = 75
+
+// writeVCard writes a customer as a vCard 4.0 with the role as its TITLE.
+func writeVCard(w io.Writer, customer api.Customer) error {
+	lines := []string{"BEGIN:VCARD", "VERSION:4.0"}
+	if customer.ID != nil {
+		lines = append(lines, "UID:urn:farmapp:customer:"+strconv.Itoa(*customer.ID))
+	}
+	// The name is kept as one string, so all of it goes in the family name.
+	lines = append(lines, "FN:"+escapeVCardText(customer.Name), "N:"+escapeVCardText(customer.Name)+";;;;")
+	if customer.Role != "" {
+		lines = append(lines, "TITLE:"+escapeVCardText(customer.Role))
+	}
+	if customer.Email != "" {
+		lines = append(lines, "EMAIL:"+escapeVCardText(customer.Email))
+	}
+	if customer.Phone != "" {
+		lines = append(lines, "TEL;VALUE=text:"+escapeVCardText(customer.Phone))
+	}
+	lines = append(lines, "END:VCARD")
+
+	for _, line := range lines {
+		if _, err := io.WriteString(w, foldVCardLine(line)+"\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var vcardEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\r\n", `\n`, "\n", `\n`)
+
+func escapeVCardText(value string) string {
+	return vcardEscaper.Replace(value)
+}
+
+// foldVCardLine splits lines longer than maxVCardLine octets, without
+// splitting a UTF-8 sequence.
+func foldVCardLine(line string) string {
+	var b strings.Builder
+	limit := maxVCardLine
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		// The leading space counts towards the next line.
+		limit = maxVCardLine - 1
+	}
+	b.WriteString(line)
+	return b.String()
+}
+
+// vcardProperty is one content line of a vCard.
+type vcardProperty struct {
+	name   string
+	params map[string][]string
+	value  string
+}
+
+// preferred reports whether the property is marked as the preferred one
+// with PREF=1 (vCard 4.0) or TYPE=pref (vCard 3.0).
+func (p vcardProperty) preferred() bool {
+	for _, value := range p.params["PREF"] {
+		if value == "1" {
+			return true
+		}
+	}
+	for _, value := range p.params["TYPE"] {
+		if strings.EqualFold(value, "pref") {
+			return true
+		}
+	}
+	return false
+}
+
+// vcard is a parsed vCard with the line it starts on.
+type vcard struct {
+	line       int
+	properties []vcardProperty
+}
+
+// first returns the value of the preferred or else the first property
+// with the given name.
+func (c vcard) first(name string) string {
+	value, found := "", false
+	for _, p := range c.properties {
+		if p.name != name {
+			continue
+		}
+		if p.preferred() {
+			return p.value
+		}
+		if !found {
+			value, found = p.value, true
+		}
+	}
+	return value
+}
+
+// customer maps the vCard to a customer. Versions 3.0 and 4.0 are read
+// alike; ROLE is used if there is no TITLE.
+func (c vcard) customer() api.Customer {
+	name := unescapeVCardText(c.first("FN"))
+	if name == "" {
+		// N is family;given;additional;prefixes;suffixes.
+		parts := splitVCardValue(c.first("N"), ';')
+		for len(parts) < 2 {
+			parts = append(parts, "")
+		}
+		name = strings.TrimSpace(unescapeVCardText(parts[0]) + " " + unescapeVCardText(parts[1]))
+	}
+	role := c.first("TITLE")
+	if role == "" {
+		role = c.first("ROLE")
+	}
+	phone := unescapeVCardText(c.first("TEL"))
+	phone = strings.TrimPrefix(phone, "tel:")
+	return api.Customer{
+		Name:  strings.TrimSpace(name),
+		Role:  strings.TrimSpace(unescapeVCardText(role)),
+		Email: strings.TrimSpace(strings.TrimPrefix(unescapeVCardText(c.first("EMAIL")), "mailto:")),
+		Phone: strings.TrimSpace(phone),
+	}
+}
+
+// readVCards parses every vCard of a file.
+func readVCards(file io.Reader) ([]vcard, error) {
+	lines, err := unfoldVCardLines(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var cards []vcard
+	var current *vcard
+	for _, l := range lines {
+		if strings.TrimSpace(l.text) == "" {
+			continue
+		}
+		property, err := parseVCardLine(l.text)
+		if err != nil {
+			return nil, fmt.Errorf("the file is not a valid vCard: line %d: %v", l.number, err)
+		}
+		switch {
+		case property.name == "BEGIN" && strings.EqualFold(property.value, "VCARD"):
+			if current != nil {
+				return nil, fmt.Errorf("the file is not a valid vCard: line %d: BEGIN:VCARD inside a vCard", l.number)
+			}
+			current = &vcard{line: l.number}
+		case current == nil:
+			return nil, fmt.Errorf("the file is not a valid vCard: line %d: %s outside of BEGIN:VCARD and END:VCARD", l.number, property.name)
+		case property.name == "END" && strings.EqualFold(property.value, "VCARD"):
+			cards = append(cards, *current)
+			current = nil
+		default:
+			current.properties = append(current.properties, property)
+		}
+	}
+	if current != nil {
+		return nil, fmt.Errorf("the file is not a valid vCard: the vCard starting on line %d has no END:VCARD", current.line)
+	}
+	return cards, nil
+}
+
+// vcardLine is an unfolded content line and the line it starts on.
+type vcardLine struct {
+	number int
+	text   string
+}
+
+// unfoldVCardLines joins lines that start with a space or tab to the line
+// before them.
+func unfoldVCardLines(file io.Reader) ([]vcardLine, error) {
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxImportSize)
+	var lines []vcardLine
+	number := 0
+	for scanner.Scan() {
+		number++
+		text := strings.TrimSuffix(scanner.Text(), "\r")
+		if number == 1 {
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+		if len(lines) > 0 && (strings.HasPrefix(text, " ") || strings.HasPrefix(text, "\t")) {
+			lines[len(lines)-1].text += text[1:]
+			continue
+		}
+		lines = append(lines, vcardLine{number: number, text: text})
+	}
+	return lines, scanner.Err()
+}
+
+// parseVCardLine splits a content line into its name without group,
+// parameters and raw value.
+func parseVCardLine(line string) (vcardProperty, error) {
+	// The value starts at the first colon that is not in a quoted parameter.
+	colon, quoted := -1, false
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return vcardProperty{}, errors.New("missing colon")
+	}
+
+	parts := splitVCardValue(line[:colon], ';')
+	name := strings.ToUpper(parts[0])
+	if _, after, found := strings.Cut(name, "."); found {
+		name = after
+	}
+	if name == "" {
+		return vcardProperty{}, errors.New("missing property name")
+	}
+	property := vcardProperty{name: name, params: map[string][]string{}, value: line[colon+1:]}
+	for _, param := range parts[1:] {
+		key, value, found := strings.Cut(param, "=")
+		if !found {
+			// vCard 2.1 lists types without TYPE=.
+			key, value = "TYPE", param
+		}
+		key = strings.ToUpper(key)
+		for _, v := range splitVCardValue(value, ',') {
+			property.params[key] = append(property.params[key], strings.Trim(v, `"`))
+		}
+	}
+	return property, nil
+}
+
+// splitVCardValue splits at separators that are neither escaped nor quoted.
+func splitVCardValue(value string, separator byte) []string {
+	var parts []string
+	start, quoted := 0, false
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case separator:
+			if !quoted {
+				parts = append(parts, value[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, value[start:])
+}
+
+func unescapeVCardText(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+1 < len(value) {
+			i++
+			if value[i] == 'n' || value[i] == 'N' {
+				b.WriteByte('\n')
+			} else {
+				b.WriteByte(value[i])
+			}
+			continue
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"errors"
+	"farmApp/pkg/api"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// @Summary Get a customer as a vCard
+// @Description Get a customer as a vCard 4.0 with the name, the role as TITLE, the email and the phone number.
+// @Tags customers
+// @Produce text/vcard
+// @Param id path int true "Customer ID"
+// @Success 200 {string} string "vCard"
+// @Header 200 {string} ETag "Customer version"
+// @Failure 400 {object} api.Problem
+// @Failure 404 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /customers/{id}.vcf [get]
+func (h *CustomerHandler) GetCustomerVCard(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	customer, err := h.repo.Get(r.Context(), id)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+
+	setETag(w, customer.Version)
+	startVCards(w, fmt.Sprintf("customer-%d.vcf", id))
+	writeVCards(w, r, []api.Customer{customer})
+}
+
+// @Summary Export customers as vCards
+// @Description Get all customers matching the filters of GET /customers as one vCard 4.0 file, for importing into a phone's address book. The file is written page by page, so a failure after the first page ends it early.
+// @Tags customers
+// @Produce text/vcard
+// @Param role query string false "Role, ignoring case"
+// @Param contacted query bool false "Contacted flag"
+// @Param name query string false "Substring of the name, ignoring case"
+// @Param email query string false "Substring of the email, ignoring case"
+// @Param created_after query string false "Created at or after this RFC 3339 time or YYYY-MM-DD date"
+// @Param created_before query string false "Created before this RFC 3339 time or YYYY-MM-DD date"
+// @Param address query string false "Substring of the street, house number, postal code or city of an address, ignoring case"
+// @Param postal_code query string false "Beginning of the postal code of an address"
+// @Param city query string false "City of an address, ignoring case"
+// @Param country query string false "ISO 3166-1 alpha-2 country code of an address"
+// @Param address_type query string false "Type of an address (billing, delivery, farm_yard)"
+// @Param tags query string false "Comma-separated tag names, ignoring case"
+// @Param tags_match query string false "Whether customers need any (default) or all of the tags" Enums(any, all)
+// @Param sort query string false "Comma-separated fields (id, name, role, email, phone, contacted, created_at), prefixed with - for descending"
+// @Success 200 {string} string "vCards"
+// @Failure 400 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /customers/export.vcf [get]
+func (h *CustomerHandler) ExportVCards(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r)
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	// Only the first page can still be answered with a problem; every page
+	// is written before the next one is read, so the export is never held
+	// in memory as a whole.
+	opts.Limit, opts.Cursor = maxPageSize, ""
+	page, err := h.repo.List(r.Context(), opts)
+	if err != nil {
+		handleRepositoryError(w, r, err)
+		return
+	}
+	startVCards(w, "customers.vcf")
+	for {
+		if !writeVCards(w, r, page.Customers) || page.NextCursor == "" {
+			return
+		}
+		opts.Cursor = page.NextCursor
+		if page, err = h.repo.List(r.Context(), opts); err != nil {
+			// The status has been sent, so the client only sees a short file.
+			log.Printf("Request %s failed: listing customers for vCards: %v", RequestIDFromContext(r.Context()), err)
+			return
+		}
+	}
+}
+
+// startVCards sends the headers of a vCard file to be saved under fileName.
+func startVCards(w http.ResponseWriter, fileName string) {
+	w.Header().Set("Content-Type", vcardType+"; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+}
+
+// writeVCards writes the customers as vCards and reports whether that
+// succeeded.
+func writeVCards(w http.ResponseWriter, r *http.Request, customers []api.Customer) bool {
+	for _, customer := range customers {
+		if err := writeVCard(w, customer); err != nil {
+			// The status has been sent, so the client only sees a short file.
+			log.Printf("Request %s failed: writing vCards: %v", RequestIDFromContext(r.Context()), err)
+			return false
+		}
+	}
+	return true
+}
+
+// @Summary Import customers from vCards
+// @Description Create a customer from every vCard (version 3.0 or 4.0) of a file, sent as the request body or as the "file" field of a multipart form. FN (or else N) becomes the name, TITLE (or else ROLE) the role, and the preferred or first EMAIL and TEL the email and phone. Modes, dry runs and the response are as for the CSV import; row is the line on which a vCard starts.
+// @Tags customers
+// @Accept text/vcard,multipart/form-data
+// @Produce json,xml,application/yaml,text/csv
+// @Param mode query string false "How failures are handled" Enums(all-or-nothing, best-effort)
+// @Param dry_run query bool false "Only validate the vCards and report the customers that would be created"
+// @Success 200 {object} api.ImportResponse "Every vCard succeeded"
+// @Success 207 {object} api.ImportResponse "Some vCards failed"
+// @Failure 400 {object} api.Problem
+// @Failure 413 {object} api.Problem
+// @Failure 500 {object} api.Problem
+// @Router /customers/import/vcard [post]
+func (h *ImportHandler) ImportVCards(w http.ResponseWriter, r *http.Request) {
+	mode, dryRun, err := parseImportMode(r)
+	if err != nil {
+		handleError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	file, err := importFile(w, r)
+	if err != nil {
+		handleImportError(w, r, err)
+		return
+	}
+	cards, err := readVCards(file)
+	if err != nil {
+		handleImportError(w, r, err)
+		return
+	}
+	switch {
+	case len(cards) == 0:
+		handleError(w, r, errors.New("the file has no vCards"), http.StatusBadRequest)
+		return
+	case len(cards) > maxImportRows:
+		handleImportError(w, r, errTooManyRows)
+		return
+	}
+
+	lines := make([]int, len(cards))
+	customers := make([]api.Customer, len(cards))
+	problems := make([]error, len(cards))
+	for i, card := range cards {
+		lines[i], customers[i] = card.line, card.customer()
+		problems[i] = customers[i].Validate()
+	}
+	h.run(w, r, mode, dryRun, lines, customers, problems)
+}
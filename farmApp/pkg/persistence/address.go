@@ -0,0 +1,241 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"farmApp/pkg/api"
+	"sort"
+)
+
+// ErrAddressNotFound is returned when a customer has no address with the
+// given ID.
+var ErrAddressNotFound = errors.New("address not found")
+
+// AddressRepository stores the postal addresses of customers. Addresses do
+// not change the customer's version or history.
+//
+// All methods return ErrNotFound if the customer does not exist or is in
+// the trash.
+type AddressRepository interface {
+	// ListAddresses returns the addresses of a customer ordered by type, then
+	// by ID.
+	ListAddresses(ctx context.Context, customerID int) ([]api.Address, error)
+	GetAddress(ctx context.Context, customerID, id int) (api.Address, error)
+	AddAddress(ctx context.Context, customerID int, address api.Address) (api.Address, error)
+	UpdateAddress(ctx context.Context, customerID, id int, address api.Address) (api.Address, error)
+	DeleteAddress(ctx context.Context, customerID, id int) error
+}
+
+const addressColumns = "id, customer_id, type, street, house_number, postal_code, city, country"
+
+func scanAddress(row rowScanner) (api.Address, error) {
+	var address api.Address
+	err := row.Scan(&address.ID, &address.CustomerID, &address.Type, &address.Street, &address.HouseNumber,
+		&address.PostalCode, &address.City, &address.Country)
+	return address, err
+}
+
+func (s *SQLStore) ListAddresses(ctx context.Context, customerID int) ([]api.Address, error) {
+	c := s.conn()
+	if _, err := checkVersion(ctx, c, customerID, AnyVersion); err != nil {
+		return nil, err
+	}
+	rows, err := c.query(ctx, "SELECT "+addressColumns+" FROM customer_address WHERE customer_id = ? ORDER BY type, id",
+		customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	addresses := []api.Address{}
+	for rows.Next() {
+		address, err := scanAddress(rows)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses, rows.Err()
+}
+
+func (s *SQLStore) GetAddress(ctx context.Context, customerID, id int) (api.Address, error) {
+	c := s.conn()
+	if _, err := checkVersion(ctx, c, customerID, AnyVersion); err != nil {
+		return api.Address{}, err
+	}
+	address, err := scanAddress(c.queryRow(ctx,
+		"SELECT "+addressColumns+" FROM customer_address WHERE id = ? AND customer_id = ?", id, customerID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return address, ErrAddressNotFound
+	}
+	return address, err
+}
+
+func (s *SQLStore) AddAddress(ctx context.Context, customerID int, address api.Address) (api.Address, error) {
+	var added api.Address
+	err := s.withTx(ctx, func(c conn) error {
+		if _, err := checkVersion(ctx, c, customerID, AnyVersion); err != nil {
+			return err
+		}
+		var err error
+		added, err = scanAddress(c.queryRow(ctx,
+			"INSERT INTO customer_address (customer_id, type, street, house_number, postal_code, city, country, "+
+				"street_key, house_number_key, postal_code_key, city_key) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING "+addressColumns,
+			customerID, address.Type, address.Street, address.HouseNumber, address.PostalCode, address.City, address.Country,
+			textKey(address.Street), textKey(address.HouseNumber), textKey(address.PostalCode), textKey(address.City)))
+		return err
+	})
+	return added, err
+}
+
+func (s *SQLStore) UpdateAddress(ctx context.Context, customerID, id int, address api.Address) (api.Address, error) {
+	var updated api.Address
+	err := s.withTx(ctx, func(c conn) error {
+		if _, err := checkVersion(ctx, c, customerID, AnyVersion); err != nil {
+			return err
+		}
+		var err error
+		updated, err = scanAddress(c.queryRow(ctx,
+			"UPDATE customer_address SET type = ?, street = ?, house_number = ?, postal_code = ?, city = ?, country = ?, "+
+				"street_key = ?, house_number_key = ?, postal_code_key = ?, city_key = ? "+
+				"WHERE id = ? AND customer_id = ? RETURNING "+addressColumns,
+			address.Type, address.Street, address.HouseNumber, address.PostalCode, address.City, address.Country,
+			textKey(address.Street), textKey(address.HouseNumber), textKey(address.PostalCode), textKey(address.City),
+			id, customerID))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrAddressNotFound
+		}
+		return err
+	})
+	return updated, err
+}
+
+func (s *SQLStore) DeleteAddress(ctx context.Context, customerID, id int) error {
+	return s.withTx(ctx, func(c conn) error {
+		if _, err := checkVersion(ctx, c, customerID, AnyVersion); err != nil {
+			return err
+		}
+		result, err := c.exec(ctx, "DELETE FROM customer_address WHERE id = ? AND customer_id = ?", id, customerID)
+		if err != nil {
+			return err
+		}
+		if err := requireAffected(result); err != nil {
+			return ErrAddressNotFound
+		}
+		return nil
+	})
+}
+
+func (m *MemoryStore) ListAddresses(ctx context.Context, customerID int) ([]api.Address, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, err := m.checkVersion(customerID, AnyVersion); err != nil {
+		return nil, err
+	}
+	addresses := m.addressesOf(customerID)
+	sort.Slice(addresses, func(i, j int) bool {
+		if addresses[i].Type != addresses[j].Type {
+			return addresses[i].Type < addresses[j].Type
+		}
+		return addresses[i].ID < addresses[j].ID
+	})
+	return addresses, nil
+}
+
+func (m *MemoryStore) GetAddress(ctx context.Context, customerID, id int) (api.Address, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, err := m.checkVersion(customerID, AnyVersion); err != nil {
+		return api.Address{}, err
+	}
+	for _, address := range m.addresses {
+		if address.ID == id && address.CustomerID == customerID {
+			return address, nil
+		}
+	}
+	return api.Address{}, ErrAddressNotFound
+}
+
+func (m *MemoryStore) AddAddress(ctx context.Context, customerID int, address api.Address) (api.Address, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.checkVersion(customerID, AnyVersion); err != nil {
+		return api.Address{}, err
+	}
+	m.lastAddressID++
+	address.ID = m.lastAddressID
+	address.CustomerID = customerID
+	m.addresses = append(m.addresses, address)
+	return address, nil
+}
+
+func (m *MemoryStore) UpdateAddress(ctx context.Context, customerID, id int, address api.Address) (api.Address, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.checkVersion(customerID, AnyVersion); err != nil {
+		return api.Address{}, err
+	}
+	for i := range m.addresses {
+		if m.addresses[i].ID == id && m.addresses[i].CustomerID == customerID {
+			address.ID, address.CustomerID = id, customerID
+			m.addresses[i] = address
+			return address, nil
+		}
+	}
+	return api.Address{}, ErrAddressNotFound
+}
+
+func (m *MemoryStore) DeleteAddress(ctx context.Context, customerID, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.checkVersion(customerID, AnyVersion); err != nil {
+		return err
+	}
+	for i, address := range m.addresses {
+		if address.ID == id && address.CustomerID == customerID {
+			m.addresses = append(m.addresses[:i], m.addresses[i+1:]...)
+			return nil
+		}
+	}
+	return ErrAddressNotFound
+}
+
+// addressesOf returns the addresses of a customer in the order they were
+// added. The caller must hold m.mu.
+func (m *MemoryStore) addressesOf(customerID int) []api.Address {
+	addresses := []api.Address{}
+	for _, address := range m.addresses {
+		if address.CustomerID == customerID {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// moveAddresses gives the addresses of one customer to another. The caller
+// must hold m.mu.
+func (m *MemoryStore) moveAddresses(fromID, toID int) {
+	for i := range m.addresses {
+		if m.addresses[i].CustomerID == fromID {
+			m.addresses[i].CustomerID = toID
+		}
+	}
+}
+
+// deleteAddresses forgets the addresses of a purged customer. The caller
+// must hold m.mu.
+func (m *MemoryStore) deleteAddresses(customerID int) {
+	kept := m.addresses[:0]
+	for _, address := range m.addresses {
+		if address.CustomerID != customerID {
+			kept = append(kept, address)
+		}
+	}
+	m.addresses = kept
+}
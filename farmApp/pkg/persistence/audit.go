@@ -0,0 +1,215 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"farmApp/pkg/api"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Audited operations.
+const (
+	OpCreate  = "create"
+	OpUpdate  = "update"
+	OpDelete  = "delete"
+	OpRestore = "restore"
+	OpPurge   = "purge"
+	OpMerge   = "merge"
+)
+
+// SystemActor is recorded for changes made outside of a request, such as seeding.
+const SystemActor = "system"
+
+// AuditRepository reads the change history written by every customer mutation.
+type AuditRepository interface {
+	// History returns the changes to one customer, newest first. It returns
+	// ErrNotFound if the customer never existed.
+	History(ctx context.Context, customerID int) ([]api.AuditEntry, error)
+	// ListAudit returns the changes matching the filter, newest first.
+	ListAudit(ctx context.Context, filter AuditFilter) ([]api.AuditEntry, error)
+}
+
+// AuditFilter selects audit entries. Zero values match everything.
+type AuditFilter struct {
+	CustomerID int
+	Actor      string
+	Operation  string
+	Since      time.Time
+	Until      time.Time
+	Limit      int
+}
+
+type actorKey struct{}
+
+// WithActor returns a context whose writes are recorded as made by actor.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor set by WithActor, or SystemActor.
+func ActorFromContext(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return SystemActor
+}
+
+// auditValues returns the audited fields of a customer.
+func auditValues(customer api.Customer) map[string]any {
+	return map[string]any{
+		"name":      customer.Name,
+		"role":      customer.Role,
+		"email":     customer.Email,
+		"phone":     customer.Phone,
+		"contacted": customer.Contacted,
+		// Derived from the interactions, but part of the customer's state.
+		"contact_count":     customer.ContactCount,
+		"last_contacted_at": customer.LastContactedAt,
+	}
+}
+
+// newAuditEntry describes the change from before to after. A nil before is a
+// creation, a nil after a deletion; for updates only changed fields are kept.
+func newAuditEntry(ctx context.Context, customerID int, operation string, before, after *api.Customer) api.AuditEntry {
+	entry := api.AuditEntry{
+		CustomerID: customerID,
+		Actor:      ActorFromContext(ctx),
+		OccurredAt: now(),
+		Operation:  operation,
+	}
+	if before != nil {
+		entry.Before = auditValues(*before)
+	}
+	if after != nil {
+		entry.After = auditValues(*after)
+	}
+	if before != nil && after != nil {
+		for field, value := range entry.Before {
+			if reflect.DeepEqual(value, entry.After[field]) {
+				delete(entry.Before, field)
+				delete(entry.After, field)
+			}
+		}
+	}
+	return entry
+}
+
+// matches reports whether the entry is selected by the filter.
+func (f AuditFilter) matches(entry api.AuditEntry) bool {
+	return (f.CustomerID == 0 || entry.CustomerID == f.CustomerID) &&
+		(f.Actor == "" || entry.Actor == f.Actor) &&
+		(f.Operation == "" || entry.Operation == f.Operation) &&
+		(f.Since.IsZero() || !entry.OccurredAt.Before(f.Since)) &&
+		(f.Until.IsZero() || entry.OccurredAt.Before(f.Until))
+}
+
+func recordAudit(ctx context.Context, c conn, entry api.AuditEntry) error {
+	before, err := marshalAuditValues(entry.Before)
+	if err != nil {
+		return err
+	}
+	after, err := marshalAuditValues(entry.After)
+	if err != nil {
+		return err
+	}
+	_, err = c.exec(ctx, "INSERT INTO customer_audit (customer_id, actor, occurred_at, operation, before_values, after_values) "+
+		"VALUES (?, ?, ?, ?, ?, ?)",
+		entry.CustomerID, entry.Actor, entry.OccurredAt, entry.Operation, before, after)
+	return err
+}
+
+func marshalAuditValues(values map[string]any) (sql.NullString, error) {
+	if values == nil {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(values)
+	return sql.NullString{String: string(data), Valid: true}, err
+}
+
+func unmarshalAuditValues(data sql.NullString) (map[string]any, error) {
+	if !data.Valid {
+		return nil, nil
+	}
+	var values map[string]any
+	err := json.Unmarshal([]byte(data.String), &values)
+	return values, err
+}
+
+func (s *SQLStore) History(ctx context.Context, customerID int) ([]api.AuditEntry, error) {
+	entries, err := s.ListAudit(ctx, AuditFilter{CustomerID: customerID})
+	if err != nil || len(entries) > 0 {
+		return entries, err
+	}
+
+	var exists bool
+	err = s.conn().queryRow(ctx, "SELECT EXISTS (SELECT 1 FROM customer WHERE id = ?)", customerID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return entries, nil
+}
+
+func (s *SQLStore) ListAudit(ctx context.Context, filter AuditFilter) ([]api.AuditEntry, error) {
+	var conditions []string
+	var args []any
+	if filter.CustomerID != 0 {
+		conditions = append(conditions, "customer_id = ?")
+		args = append(args, filter.CustomerID)
+	}
+	if filter.Actor != "" {
+		conditions = append(conditions, "actor = ?")
+		args = append(args, filter.Actor)
+	}
+	if filter.Operation != "" {
+		conditions = append(conditions, "operation = ?")
+		args = append(args, filter.Operation)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "occurred_at >= ?")
+		args = append(args, filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "occurred_at < ?")
+		args = append(args, filter.Until.UTC())
+	}
+
+	query := "SELECT id, customer_id, actor, occurred_at, operation, before_values, after_values FROM customer_audit"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY occurred_at DESC, id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := s.conn().query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []api.AuditEntry{}
+	for rows.Next() {
+		var entry api.AuditEntry
+		var before, after sql.NullString
+		if err := rows.Scan(&entry.ID, &entry.CustomerID, &entry.Actor, &entry.OccurredAt, &entry.Operation,
+			&before, &after); err != nil {
+			return nil, err
+		}
+		if entry.Before, err = unmarshalAuditValues(before); err != nil {
+			return nil, err
+		}
+		if entry.After, err = unmarshalAuditValues(after); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
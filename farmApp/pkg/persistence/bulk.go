@@ -0,0 +1,171 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"farmApp/pkg/api"
+	"maps"
+)
+
+// ErrBatchAborted is the result of an item that succeeded on its own but
+// was rolled back because another item of an AllOrNothing batch failed.
+var ErrBatchAborted = errors.New("not applied because another item of the batch failed")
+
+// BulkMode decides what happens to a batch when some of its items fail.
+type BulkMode int
+
+const (
+	// AllOrNothing rolls back the whole batch if any item fails.
+	AllOrNothing BulkMode = iota
+	// BestEffort keeps the items that succeeded.
+	BestEffort
+)
+
+// BulkUpdate replaces the customer with the given ID if it still has the
+// expected version (or AnyVersion is passed).
+type BulkUpdate struct {
+	ID       int
+	Version  int
+	Customer api.Customer
+}
+
+// BulkDelete moves the customer with the given ID to the trash if it still
+// has the expected version (or AnyVersion is passed).
+type BulkDelete struct {
+	ID      int
+	Version int
+}
+
+// BulkResult is the outcome of one item of a batch, at the same index as
+// the item.
+type BulkResult struct {
+	// Customer is the created or updated customer; it is empty for deletes
+	// and failed items.
+	Customer api.Customer
+	// Err is nil if the item was applied.
+	Err error
+}
+
+// BulkRepository applies batches of customer changes in one transaction.
+// Items are applied in order and each one is audited like a single change.
+// A failed item is reported in its BulkResult; the returned error is only
+// set if the batch as a whole could not be run.
+type BulkRepository interface {
+	CreateMany(ctx context.Context, customers []api.Customer, mode BulkMode) ([]BulkResult, error)
+	UpdateMany(ctx context.Context, updates []BulkUpdate, mode BulkMode) ([]BulkResult, error)
+	DeleteMany(ctx context.Context, deletes []BulkDelete, mode BulkMode) ([]BulkResult, error)
+}
+
+// errBatchFailed makes withTx roll back an AllOrNothing batch.
+var errBatchFailed = errors.New("batch failed")
+
+// abortBatch marks the results of the items that succeeded as rolled back.
+func abortBatch(results []BulkResult) {
+	for i := range results {
+		if results[i].Err == nil {
+			results[i] = BulkResult{Err: ErrBatchAborted}
+		}
+	}
+}
+
+// runBatch applies n items in one transaction, each inside a savepoint so
+// that a failed item can be undone without losing the others.
+func (s *SQLStore) runBatch(ctx context.Context, n int, mode BulkMode, apply func(c conn, i int) (api.Customer, error)) ([]BulkResult, error) {
+	results := make([]BulkResult, n)
+	err := s.withTx(ctx, func(c conn) error {
+		failed := false
+		for i := range results {
+			if _, err := c.exec(ctx, "SAVEPOINT batch_item"); err != nil {
+				return err
+			}
+			customer, err := apply(c, i)
+			if err != nil {
+				if _, err := c.exec(ctx, "ROLLBACK TO SAVEPOINT batch_item"); err != nil {
+					return err
+				}
+				results[i].Err = err
+				failed = true
+				continue
+			}
+			if _, err := c.exec(ctx, "RELEASE SAVEPOINT batch_item"); err != nil {
+				return err
+			}
+			results[i].Customer = customer
+		}
+		if failed && mode == AllOrNothing {
+			return errBatchFailed
+		}
+		return nil
+	})
+	if errors.Is(err, errBatchFailed) {
+		abortBatch(results)
+		return results, nil
+	}
+	return results, err
+}
+
+func (s *SQLStore) CreateMany(ctx context.Context, customers []api.Customer, mode BulkMode) ([]BulkResult, error) {
+	return s.runBatch(ctx, len(customers), mode, func(c conn, i int) (api.Customer, error) {
+		return insertCustomer(ctx, c, customers[i])
+	})
+}
+
+func (s *SQLStore) UpdateMany(ctx context.Context, updates []BulkUpdate, mode BulkMode) ([]BulkResult, error) {
+	return s.runBatch(ctx, len(updates), mode, func(c conn, i int) (api.Customer, error) {
+		update := updates[i]
+		return updateCustomer(ctx, c, update.ID, update.Version, func(api.Customer) (api.Customer, error) {
+			return update.Customer, nil
+		})
+	})
+}
+
+func (s *SQLStore) DeleteMany(ctx context.Context, deletes []BulkDelete, mode BulkMode) ([]BulkResult, error) {
+	return s.runBatch(ctx, len(deletes), mode, func(c conn, i int) (api.Customer, error) {
+		return api.Customer{}, deleteCustomer(ctx, c, deletes[i].ID, deletes[i].Version)
+	})
+}
+
+// runBatch mirrors the SQL store's runBatch. An item never changes the
+// store when it fails, so only AllOrNothing batches need a snapshot to
+// roll back to.
+func (m *MemoryStore) runBatch(n int, mode BulkMode, apply func(i int) (api.Customer, error)) []BulkResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var snapshot MemoryStore
+	if mode == AllOrNothing {
+		snapshot = MemoryStore{customers: maps.Clone(m.customers), lastID: m.lastID, audit: m.audit, lastAuditID: m.lastAuditID}
+	}
+	results := make([]BulkResult, n)
+	failed := false
+	for i := range results {
+		results[i].Customer, results[i].Err = apply(i)
+		failed = failed || results[i].Err != nil
+	}
+	if failed && mode == AllOrNothing {
+		m.customers, m.lastID, m.audit, m.lastAuditID = snapshot.customers, snapshot.lastID, snapshot.audit, snapshot.lastAuditID
+		abortBatch(results)
+	}
+	return results
+}
+
+func (m *MemoryStore) CreateMany(ctx context.Context, customers []api.Customer, mode BulkMode) ([]BulkResult, error) {
+	return m.runBatch(len(customers), mode, func(i int) (api.Customer, error) {
+		return m.create(ctx, customers[i]), nil
+	}), nil
+}
+
+func (m *MemoryStore) UpdateMany(ctx context.Context, updates []BulkUpdate, mode BulkMode) ([]BulkResult, error) {
+	return m.runBatch(len(updates), mode, func(i int) (api.Customer, error) {
+		update := updates[i]
+		return m.update(ctx, update.ID, update.Version, func(api.Customer) (api.Customer, error) {
+			return update.Customer, nil
+		})
+	}), nil
+}
+
+func (m *MemoryStore) DeleteMany(ctx context.Context, deletes []BulkDelete, mode BulkMode) ([]BulkResult, error) {
+	return m.runBatch(len(deletes), mode, func(i int) (api.Customer, error) {
+		return api.Customer{}, m.delete(ctx, deletes[i].ID, deletes[i].Version)
+	}), nil
+}
@@ -1,201 +1,470 @@
 package persistence
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"farmApp/pkg/api"
+	"fmt"
 	"log"
+	"math"
+	"net/url"
 	"os"
+	"strings"
+	"time"
 )
 
-var db *sql.DB
+// SeedMode selects which initial data is inserted into an empty database.
+type SeedMode string
 
-func CreateFarmDB() {
-	CreateDB("./farmCustomers.db")
+const (
+	SeedNone SeedMode = "none"
+	SeedDemo SeedMode = "demo"
+)
+
+// ParseSeedMode validates the value of the --seed option.
+func ParseSeedMode(value string) (SeedMode, error) {
+	switch mode := SeedMode(value); mode {
+	case SeedNone, SeedDemo:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown seed mode %q (want demo or none)", value)
 }
 
-func CreateDB(databaseName string) {
-	deleteDB(databaseName)
-	var err error
-	db, err = sql.Open("sqlite3", databaseName)
-	if err != nil {
-		log.Fatal(err)
+// Options controls how a database is opened.
+type Options struct {
+	// Reset deletes the database file before opening it.
+	Reset bool
+	// Seed selects the data inserted when the customer table is empty.
+	Seed SeedMode
+	// SkipMigrations leaves the schema untouched, e.g. for the migrate command.
+	SkipMigrations bool
+}
+
+// demoCustomers are inserted by --seed=demo.
+var demoCustomers = []api.Customer{
+	{Name: "Bauer Klaus", Role: "Farmer", Email: "klaus.bauer@farm.de", Phone: "01234 567890", Contacted: true},
+	{Name: "Bauerin Anna", Role: "Owner", Email: "anna.bauerin@farm.de", Phone: "01234 567891", Contacted: false},
+	{Name: "Müller Hans", Role: "Worker", Email: "hans.mueller@farm.de", Phone: "01234 567892", Contacted: true},
+	{Name: "Schmidt Peter", Role: "Manager", Email: "peter.schmidt@farm.de", Phone: "01234 567893", Contacted: false},
+	{Name: "Fischer Maria", Role: "Assistant", Email: "maria.fischer@farm.de", Phone: "01234 567894", Contacted: true},
+	{Name: "Weber Karl", Role: "Technician", Email: "karl.weber@farm.de", Phone: "01234 567895", Contacted: false},
+	{Name: "Meyer Lisa", Role: "Accountant", Email: "lisa.meyer@farm.de", Phone: "01234 567896", Contacted: true},
+	{Name: "Wagner Thomas", Role: "Driver", Email: "thomas.wagner@farm.de", Phone: "01234 567897", Contacted: false},
+	{Name: "Becker Laura", Role: "Secretary", Email: "laura.becker@farm.de", Phone: "01234 567898", Contacted: true},
+	{Name: "Hoffmann Frank", Role: "Guard", Email: "frank.hoffmann@farm.de", Phone: "01234 567899", Contacted: false},
+}
+
+// SQLStore is a CustomerRepository backed by a SQLite or PostgreSQL database.
+type SQLStore struct {
+	db      *sql.DB
+	dialect *dialect
+}
+
+func newSQLStore(db *sql.DB, d *dialect) *SQLStore {
+	return &SQLStore{db: db, dialect: d}
+}
+
+// MemoryDSN selects the in-memory store instead of a database.
+const MemoryDSN = "memory"
+
+// Open opens the store named by dsn: MemoryDSN for an in-memory store,
+// otherwise a database as described for OpenDB.
+func Open(dsn string, opts Options) (Store, error) {
+	if dsn == MemoryDSN {
+		store := NewMemoryStore()
+		if opts.Seed == SeedDemo {
+			log.Println("Seeding demo customers into the in-memory store...")
+			if _, err := store.CreateMany(context.Background(), demoCustomers, AllOrNothing); err != nil {
+				return nil, err
+			}
+		}
+		return store, nil
 	}
-	defer func(db *sql.DB) {
-		err := db.Close()
+	return OpenDB(dsn, opts)
+}
+
+// CreateDB creates a fresh database seeded with the demo customers,
+// deleting any existing data first.
+func CreateDB(dsn string) (*SQLStore, error) {
+	return OpenDB(dsn, Options{Reset: true, Seed: SeedDemo})
+}
+
+// OpenDB opens the database named by dsn, keeping existing data unless
+// opts.Reset is set. A postgres:// URL selects PostgreSQL, anything else
+// is the path of a SQLite file.
+func OpenDB(dsn string, opts Options) (*SQLStore, error) {
+	d := dialectFor(dsn)
+	if d == sqliteDialect {
+		path, params, err := parseSQLiteDSN(dsn)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
-	}(db)
+		if opts.Reset {
+			if err := deleteDB(path); err != nil {
+				return nil, err
+			}
+		}
+		// Wait for concurrent writers instead of failing with "database is
+		// locked", unless the DSN asks for something else.
+		for key, value := range map[string]string{"_busy_timeout": "5000", "_txlock": "immediate"} {
+			if !params.Has(key) {
+				params.Set(key, value)
+			}
+		}
+		dsn = "file:" + path + "?" + params.Encode()
+	}
+	db, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	store := newSQLStore(db, d)
+	if err = store.initDB(opts); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
 
-	err = initDB(databaseName)
+// Close releases the underlying database handle.
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// parseSQLiteDSN splits a SQLite DSN such as "farm.db" or
+// "file:farm.db?mode=rwc" into the file path and its query parameters.
+func parseSQLiteDSN(dsn string) (string, url.Values, error) {
+	path, query, _ := strings.Cut(strings.TrimPrefix(dsn, "file:"), "?")
+	params, err := url.ParseQuery(query)
 	if err != nil {
-		log.Fatal(err)
+		return "", nil, fmt.Errorf("invalid database parameters in %q: %w", dsn, err)
 	}
+	return path, params, nil
 }
 
-func deleteDB(dataSourceName string) {
+func deleteDB(dataSourceName string) error {
 	err := os.Remove(dataSourceName)
 	if err != nil && !os.IsNotExist(err) {
-		log.Fatal("Failed to delete the database:", err)
+		return fmt.Errorf("failed to delete the database: %w", err)
 	}
 	log.Println("Database deleted successfully.")
+	return nil
 }
 
-func initDB(dataSourceName string) error {
-	var err error
-	db, err = sql.Open("sqlite3", dataSourceName)
-	if err != nil {
-		return err
-	}
-	if err = db.Ping(); err != nil {
+func (s *SQLStore) initDB(opts Options) error {
+	if err := s.db.Ping(); err != nil {
 		return err
 	}
 
-	if err = createCustomersTable(); err != nil {
-		return err
+	// A server database cannot be deleted like a file, so reset it by
+	// rolling back every migration instead.
+	if opts.Reset && s.dialect != sqliteDialect {
+		if err := s.Rollback(context.Background(), math.MaxInt); err != nil {
+			return err
+		}
+		log.Println("Database reset successfully.")
 	}
 
-	if err = insertInitialCustomers(); err != nil {
+	if opts.SkipMigrations {
+		return nil
+	}
+	if err := s.Migrate(context.Background()); err != nil {
 		return err
 	}
 
+	if opts.Seed == SeedDemo {
+		return s.insertInitialCustomers()
+	}
 	return nil
 }
 
-func createCustomersTable() error {
-	query := `
-    CREATE TABLE IF NOT EXISTS customer (
-        id INTEGER PRIMARY KEY AUTOINCREMENT,
-        name TEXT,
-        role TEXT,
-        email TEXT,
-        phone TEXT,
-        contacted BOOLEAN
-    );`
-	return execQuery(query)
-}
-
-func execQuery(query string) error {
-	_, err := db.Exec(query)
-	return err
-}
-
-func insertInitialCustomers() error {
+func (s *SQLStore) insertInitialCustomers() error {
 	var count int
-	err := db.QueryRow("SELECT COUNT(*) FROM customer").Scan(&count)
+	err := s.conn().queryRow(context.Background(), "SELECT COUNT(*) FROM customer").Scan(&count)
 	if err != nil {
 		return err
 	}
 
 	if count == 0 {
-		log.Println("No customers found. Inserting initial data...")
-		customers := []api.Customer{
-			{Name: "Bauer Klaus", Role: "Farmer", Email: "klaus.bauer@farm.de", Phone: "01234 567890", Contacted: true},
-			{Name: "Bauerin Anna", Role: "Owner", Email: "anna.bauerin@farm.de", Phone: "01234 567891", Contacted: false},
-			{Name: "Müller Hans", Role: "Worker", Email: "hans.mueller@farm.de", Phone: "01234 567892", Contacted: true},
-			{Name: "Schmidt Peter", Role: "Manager", Email: "peter.schmidt@farm.de", Phone: "01234 567893", Contacted: false},
-			{Name: "Fischer Maria", Role: "Assistant", Email: "maria.fischer@farm.de", Phone: "01234 567894", Contacted: true},
-			{Name: "Weber Karl", Role: "Technician", Email: "karl.weber@farm.de", Phone: "01234 567895", Contacted: false},
-			{Name: "Meyer Lisa", Role: "Accountant", Email: "lisa.meyer@farm.de", Phone: "01234 567896", Contacted: true},
-			{Name: "Wagner Thomas", Role: "Driver", Email: "thomas.wagner@farm.de", Phone: "01234 567897", Contacted: false},
-			{Name: "Becker Laura", Role: "Secretary", Email: "laura.becker@farm.de", Phone: "01234 567898", Contacted: true},
-			{Name: "Hoffmann Frank", Role: "Guard", Email: "frank.hoffmann@farm.de", Phone: "01234 567899", Contacted: false},
-		}
-
-		err := bulkInsertCustomers(customers)
-		if err != nil {
+		log.Println("No customers found. Seeding demo customers...")
+		if _, err := s.CreateMany(context.Background(), demoCustomers, AllOrNothing); err != nil {
 			return err
 		}
-		log.Println("Inserted initial customers.")
+		log.Println("Inserted demo customers.")
 	} else {
-		log.Println("Customers already exist in the database.")
+		log.Println("Customers already exist in the database. Skipping demo seed.")
 	}
 	return nil
 }
 
-func bulkInsertCustomers(customers []api.Customer) error {
-	tx, err := db.Begin()
-	if err != nil {
-		return err
-	}
-
-	stmt, err := tx.Prepare("INSERT INTO customer (name, role, email, phone, contacted) VALUES (?, ?, ?, ?, ?)")
-	if err != nil {
-		err := tx.Rollback()
-		if err != nil {
-			return err
-		}
-		return err
-	}
-	defer func(stmt *sql.Stmt) {
-		err := stmt.Close()
-		if err != nil {
+const customerColumns = "id, name, role, email, phone, contacted, last_contacted_at, contact_count, created_at, deleted_at, version"
 
-		}
-	}(stmt)
+// rowScanner is implemented by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
 
-	for _, customer := range customers {
-		_, err = stmt.Exec(customer.Name, customer.Role, customer.Email, customer.Phone, customer.Contacted)
-		if err != nil {
-			err := tx.Rollback()
-			if err != nil {
-				return err
-			}
-			return err
-		}
-	}
+func scanCustomer(row rowScanner) (api.Customer, error) {
+	var customer api.Customer
+	err := row.Scan(&customer.ID, &customer.Name, &customer.Role, &customer.Email, &customer.Phone, &customer.Contacted,
+		&customer.LastContactedAt, &customer.ContactCount, &customer.CreatedAt, &customer.DeletedAt, &customer.Version)
+	return customer, err
+}
 
-	return tx.Commit()
+func (s *SQLStore) queryCustomers(ctx context.Context, query string, args ...any) ([]api.Customer, error) {
+	return queryCustomers(ctx, s.conn(), query, args...)
 }
 
-func GetCustomers() ([]api.Customer, error) {
-	rows, err := db.Query("SELECT id, name, role, email, phone, contacted FROM customer")
+func queryCustomers(ctx context.Context, c conn, query string, args ...any) ([]api.Customer, error) {
+	rows, err := c.query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var customers []api.Customer
+	customers := []api.Customer{}
 	for rows.Next() {
-		var customer api.Customer
-		if err := rows.Scan(&customer.ID, &customer.Name, &customer.Role, &customer.Email, &customer.Phone, &customer.Contacted); err != nil {
+		customer, err := scanCustomer(rows)
+		if err != nil {
 			return nil, err
 		}
 		customers = append(customers, customer)
 	}
-	return customers, nil
+	return customers, rows.Err()
 }
 
-func GetCustomerByID(id int) (api.Customer, error) {
-	var customer api.Customer
-	err := db.QueryRow("SELECT id, name, role, email, phone, contacted FROM customer WHERE id = ?", id).Scan(
-		&customer.ID, &customer.Name, &customer.Role, &customer.Email, &customer.Phone, &customer.Contacted)
+func (s *SQLStore) List(ctx context.Context, opts ListOptions) (CustomerPage, error) {
+	keys := withIDTiebreak(opts.Sort)
+	after, err := decodeCursor(opts.Cursor, keys)
+	if err != nil {
+		return CustomerPage{}, err
+	}
+
+	conditions, args := opts.Filter.sqlConditions()
+	conditions = append([]string{"deleted_at IS NULL"}, conditions...)
+
+	var total int
+	err = s.conn().queryRow(ctx, "SELECT COUNT(*) FROM customer WHERE "+strings.Join(conditions, " AND "), args...).
+		Scan(&total)
+	if err != nil {
+		return CustomerPage{}, err
+	}
+
+	if after != nil {
+		condition, afterArgs := sqlAfter(keys, after)
+		conditions = append(conditions, condition)
+		args = append(args, afterArgs...)
+	}
+	query := "SELECT " + customerColumns + " FROM customer WHERE " + strings.Join(conditions, " AND ") + sqlOrderBy(keys)
+	if opts.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, opts.Limit+1)
+	}
+	customers, err := s.queryCustomers(ctx, query, args...)
 	if err != nil {
-		return customer, err
+		return CustomerPage{}, err
+	}
+	return newPage(customers, keys, opts.Limit, total), nil
+}
+
+func (s *SQLStore) Get(ctx context.Context, id int) (api.Customer, error) {
+	customer, err := scanCustomer(s.conn().queryRow(ctx,
+		"SELECT "+customerColumns+" FROM customer WHERE id = ? AND deleted_at IS NULL", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return customer, ErrNotFound
 	}
-	return customer, nil
+	return customer, err
 }
 
-func AddCustomer(customer api.Customer) (int, error) {
-	result, err := db.Exec("INSERT INTO customer (name, role, email, phone, contacted) VALUES (?, ?, ?, ?, ?)",
-		customer.Name, customer.Role, customer.Email, customer.Phone, customer.Contacted)
+func (s *SQLStore) Create(ctx context.Context, customer api.Customer) (int, error) {
+	var created api.Customer
+	err := s.withTx(ctx, func(c conn) error {
+		var err error
+		created, err = insertCustomer(ctx, c, customer)
+		return err
+	})
 	if err != nil {
 		return 0, err
 	}
-	id, err := result.LastInsertId()
+	return *created.ID, nil
+}
+
+func insertCustomer(ctx context.Context, c conn, customer api.Customer) (api.Customer, error) {
+	created, err := scanCustomer(c.queryRow(ctx,
+		"INSERT INTO customer (name, role, email, phone, contacted, created_at, name_key, role_key, email_key) "+
+			"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING "+customerColumns,
+		customer.Name, customer.Role, customer.Email, customer.Phone, customer.Contacted, now(),
+		textKey(customer.Name), textKey(customer.Role), textKey(customer.Email)))
 	if err != nil {
-		return 0, err
+		return created, err
+	}
+	return created, recordAudit(ctx, c, newAuditEntry(ctx, *created.ID, OpCreate, nil, &created))
+}
+
+func (s *SQLStore) Update(ctx context.Context, id, version int, customer api.Customer) (api.Customer, error) {
+	return s.Patch(ctx, id, version, func(api.Customer) (api.Customer, error) { return customer, nil })
+}
+
+func (s *SQLStore) Patch(ctx context.Context, id, version int, change func(api.Customer) (api.Customer, error)) (api.Customer, error) {
+	var updated api.Customer
+	err := s.withTx(ctx, func(c conn) error {
+		var err error
+		updated, err = updateCustomer(ctx, c, id, version, change)
+		return err
+	})
+	return updated, err
+}
+
+func updateCustomer(ctx context.Context, c conn, id, version int, change func(api.Customer) (api.Customer, error)) (api.Customer, error) {
+	current, err := checkVersion(ctx, c, id, version)
+	if err != nil {
+		return api.Customer{}, err
+	}
+	customer, err := change(current)
+	if err != nil {
+		return api.Customer{}, err
+	}
+	updated, err := storeCustomer(ctx, c, current, customer)
+	if err != nil {
+		return api.Customer{}, err
+	}
+	return updated, recordAudit(ctx, c, newAuditEntry(ctx, id, OpUpdate, &current, &updated))
+}
+
+// storeCustomer overwrites the fields of current, which must still have its
+// version, with those of customer. A customer with interactions stays
+// contacted.
+func storeCustomer(ctx context.Context, c conn, current, customer api.Customer) (api.Customer, error) {
+	updated, err := scanCustomer(c.queryRow(ctx,
+		"UPDATE customer SET name = ?, role = ?, email = ?, phone = ?, contacted = (? OR contact_count > 0), "+
+			"name_key = ?, role_key = ?, email_key = ?, version = version + 1 WHERE id = ? AND version = ? RETURNING "+customerColumns,
+		customer.Name, customer.Role, customer.Email, customer.Phone, customer.Contacted,
+		textKey(customer.Name), textKey(customer.Role), textKey(customer.Email), *current.ID, current.Version))
+	if errors.Is(err, sql.ErrNoRows) {
+		return api.Customer{}, ErrVersionConflict
+	}
+	return updated, err
+}
+
+func (s *SQLStore) Delete(ctx context.Context, id, version int) error {
+	return s.withTx(ctx, func(c conn) error {
+		return deleteCustomer(ctx, c, id, version)
+	})
+}
+
+func deleteCustomer(ctx context.Context, c conn, id, version int) error {
+	current, err := checkVersion(ctx, c, id, version)
+	if err != nil {
+		return err
+	}
+	if err := trashCustomer(ctx, c, current); err != nil {
+		return err
+	}
+	return recordAudit(ctx, c, newAuditEntry(ctx, id, OpDelete, &current, nil))
+}
+
+// trashCustomer moves current, which must still have its version, to the trash.
+func trashCustomer(ctx context.Context, c conn, current api.Customer) error {
+	result, err := c.exec(ctx, "UPDATE customer SET deleted_at = ?, version = version + 1 WHERE id = ? AND version = ?",
+		now(), *current.ID, current.Version)
+	if err != nil {
+		return err
+	}
+	if err := requireAffected(result); err != nil {
+		return ErrVersionConflict
 	}
-	return int(id), nil
+	return nil
+}
+
+// checkVersion returns a customer that is not in the trash, or
+// ErrVersionConflict if its version differs from the expected one.
+func checkVersion(ctx context.Context, c conn, id, version int) (api.Customer, error) {
+	current, err := scanCustomer(c.queryRow(ctx,
+		"SELECT "+customerColumns+" FROM customer WHERE id = ? AND deleted_at IS NULL", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return current, ErrNotFound
+	}
+	if err != nil {
+		return current, err
+	}
+	if version != AnyVersion && version != current.Version {
+		return current, ErrVersionConflict
+	}
+	return current, nil
+}
+
+func (s *SQLStore) ListTrash(ctx context.Context) ([]api.Customer, error) {
+	return s.queryCustomers(ctx,
+		"SELECT "+customerColumns+" FROM customer WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC, id")
+}
+
+func (s *SQLStore) Restore(ctx context.Context, id int) error {
+	return s.withTx(ctx, func(c conn) error {
+		restored, err := scanCustomer(c.queryRow(ctx,
+			"UPDATE customer SET deleted_at = NULL, version = version + 1 WHERE id = ? AND deleted_at IS NOT NULL "+
+				"RETURNING "+customerColumns, id))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, c, newAuditEntry(ctx, id, OpRestore, nil, &restored))
+	})
+}
+
+func (s *SQLStore) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
+	var purged int
+	err := s.withTx(ctx, func(c conn) error {
+		customers, err := queryCustomers(ctx, c,
+			"SELECT "+customerColumns+" FROM customer WHERE deleted_at < ?", deletedBefore.UTC())
+		if err != nil {
+			return err
+		}
+		for _, customer := range customers {
+			if _, err := c.exec(ctx, "DELETE FROM customer_interaction WHERE customer_id = ?", *customer.ID); err != nil {
+				return err
+			}
+			if _, err := c.exec(ctx, "DELETE FROM customer_address WHERE customer_id = ?", *customer.ID); err != nil {
+				return err
+			}
+			if _, err := c.exec(ctx, "DELETE FROM customer_farm WHERE customer_id = ?", *customer.ID); err != nil {
+				return err
+			}
+			if err := deleteOrders(ctx, c, " WHERE customer_id = ?", *customer.ID); err != nil {
+				return err
+			}
+			if _, err := c.exec(ctx, "DELETE FROM customer_tag WHERE customer_id = ?", *customer.ID); err != nil {
+				return err
+			}
+			if _, err := c.exec(ctx, "DELETE FROM customer WHERE id = ?", *customer.ID); err != nil {
+				return err
+			}
+			if err := recordAudit(ctx, c, newAuditEntry(ctx, *customer.ID, OpPurge, &customer, nil)); err != nil {
+				return err
+			}
+		}
+		purged = len(customers)
+		return nil
+	})
+	return purged, err
 }
 
-func UpdateCustomer(id int, customer api.Customer) error {
-	_, err := db.Exec("UPDATE customer SET name = ?, role = ?, email = ?, phone = ?, contacted = ? WHERE id = ?",
-		customer.Name, customer.Role, customer.Email, customer.Phone, customer.Contacted, id)
-	return err
+// now returns the current time as stored by every backend: in UTC with
+// the microsecond precision of PostgreSQL.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
 }
 
-func DeleteCustomer(id int) error {
-	_, err := db.Exec("DELETE FROM customer WHERE id = ?", id)
-	return err
+// requireAffected maps a statement that touched no rows to ErrNotFound.
+func requireAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
 }
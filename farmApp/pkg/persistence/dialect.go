@@ -0,0 +1,153 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/mattn/go-sqlite3"
+)
+
+// dialect captures the differences between the supported SQL databases.
+type dialect struct {
+	name string
+	// driver is the database/sql driver name.
+	driver string
+	// migrations is the directory below migrations/ holding the schema scripts.
+	migrations string
+	// numberedParams replaces ? placeholders with $1, $2, ...
+	numberedParams bool
+}
+
+var (
+	sqliteDialect   = &dialect{name: "sqlite", driver: "sqlite3", migrations: "sqlite"}
+	postgresDialect = &dialect{name: "postgres", driver: "pgx", migrations: "postgres", numberedParams: true}
+)
+
+// dialectFor picks the dialect from the DSN: postgres:// URLs select
+// PostgreSQL, anything else is treated as a SQLite file name.
+func dialectFor(dsn string) *dialect {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return postgresDialect
+	}
+	return sqliteDialect
+}
+
+// rebind rewrites the ? placeholders used throughout this package. Question
+// marks inside string literals, quoted identifiers, comments and
+// dollar-quoted function bodies are left alone, so migration scripts pass
+// through unchanged.
+func (d *dialect) rebind(query string) string {
+	if !d.numberedParams || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for i := 0; i < len(query); {
+		if end := quotedEnd(query, i); end > i {
+			b.WriteString(query[i:end])
+			i = end
+			continue
+		}
+		if query[i] == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+		} else {
+			b.WriteByte(query[i])
+		}
+		i++
+	}
+	return b.String()
+}
+
+// quotedEnd returns the index just past the quoted region or comment that
+// starts at query[i], or i if none starts there. An unterminated region
+// runs to the end of the query.
+func quotedEnd(query string, i int) int {
+	rest := query[i:]
+	var opening, closing string
+	switch {
+	case rest[0] == '\'' || rest[0] == '"':
+		// A doubled quote inside the region is an escaped quote, which
+		// scanning from one closing quote to the next handles as well.
+		opening, closing = rest[:1], rest[:1]
+	case strings.HasPrefix(rest, "--"):
+		opening, closing = "--", "\n"
+	case strings.HasPrefix(rest, "/*"):
+		opening, closing = "/*", "*/"
+	case rest[0] == '$':
+		opening = dollarQuoteTag.FindString(rest)
+		closing = opening
+	}
+	if opening == "" {
+		return i
+	}
+	end := strings.Index(rest[len(opening):], closing)
+	if end < 0 {
+		return len(query)
+	}
+	return i + len(opening) + end + len(closing)
+}
+
+// dollarQuoteTag matches the opening of a PostgreSQL dollar-quoted string
+// such as $$ or $body$.
+var dollarQuoteTag = regexp.MustCompile(`^\$[A-Za-z_]*\$`)
+
+// isUniqueViolation reports whether err is a violated UNIQUE constraint in
+// either database.
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	}
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// conn runs queries written with ? placeholders against a database or transaction.
+type conn struct {
+	q queryer
+	d *dialect
+}
+
+func (c conn) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return c.q.ExecContext(ctx, c.d.rebind(query), args...)
+}
+
+func (c conn) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return c.q.QueryContext(ctx, c.d.rebind(query), args...)
+}
+
+func (c conn) queryRow(ctx context.Context, query string, args ...any) *sql.Row {
+	return c.q.QueryRowContext(ctx, c.d.rebind(query), args...)
+}
+
+// conn returns a conn that runs outside of any transaction.
+func (s *SQLStore) conn() conn {
+	return conn{q: s.db, d: s.dialect}
+}
+
+// withTx runs fn in a transaction that is committed if fn succeeds.
+func (s *SQLStore) withTx(ctx context.Context, fn func(c conn) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(conn{q: tx, d: s.dialect}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,476 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"farmApp/pkg/api"
+	"fmt"
+	"sort"
+)
+
+var (
+	// ErrFarmNotFound is returned when a farm does not exist.
+	ErrFarmNotFound = errors.New("farm not found")
+	// ErrFarmContactNotFound is returned when a customer is not linked to a farm.
+	ErrFarmContactNotFound = errors.New("customer is not a contact of the farm")
+	// ErrFarmHasContacts is returned when a farm with contacts is deleted
+	// with CascadeRestrict.
+	ErrFarmHasContacts = errors.New("farm still has contacts")
+)
+
+// FarmCascade decides what happens to the contacts of a deleted farm.
+type FarmCascade string
+
+const (
+	// CascadeRestrict refuses to delete a farm that has contacts.
+	CascadeRestrict FarmCascade = "restrict"
+	// CascadeUnlink removes the farm's links and keeps its contacts.
+	CascadeUnlink FarmCascade = "unlink"
+	// CascadeTrash also moves the contacts that are linked to no other farm
+	// to the trash.
+	CascadeTrash FarmCascade = "trash"
+)
+
+// ParseFarmCascade validates the value of the cascade query parameter.
+func ParseFarmCascade(value string) (FarmCascade, error) {
+	switch cascade := FarmCascade(value); cascade {
+	case CascadeRestrict, CascadeUnlink, CascadeTrash:
+		return cascade, nil
+	}
+	return "", fmt.Errorf("cascade must be %s, %s or %s", CascadeRestrict, CascadeUnlink, CascadeTrash)
+}
+
+// FarmRepository stores farms and links customers to them with a role per
+// farm. Links do not change the customer's version or history, and links of
+// customers in the trash are kept until they are purged.
+type FarmRepository interface {
+	// ListFarms returns all farms ordered by name.
+	ListFarms(ctx context.Context) ([]api.Farm, error)
+	GetFarm(ctx context.Context, id int) (api.Farm, error)
+	CreateFarm(ctx context.Context, farm api.Farm) (api.Farm, error)
+	UpdateFarm(ctx context.Context, id int, farm api.Farm) (api.Farm, error)
+	// DeleteFarm removes a farm and its links. Contacts it moves to the
+	// trash are recorded in the audit log.
+	DeleteFarm(ctx context.Context, id int, cascade FarmCascade) error
+
+	// ListFarmContacts returns the customers linked to a farm ordered by
+	// name, leaving out those in the trash.
+	ListFarmContacts(ctx context.Context, farmID int) ([]api.FarmContact, error)
+	// ListCustomerFarms returns the farms of a customer ordered by name, or
+	// ErrNotFound if the customer does not exist or is in the trash.
+	ListCustomerFarms(ctx context.Context, customerID int) ([]api.CustomerFarm, error)
+	// LinkFarm makes a customer a contact of a farm, or changes its role
+	// there.
+	LinkFarm(ctx context.Context, farmID, customerID int, role string) (api.FarmContact, error)
+	UnlinkFarm(ctx context.Context, farmID, customerID int) error
+}
+
+const farmColumns = "id, name, address, size_hectares, type, created_at"
+
+func scanFarm(row rowScanner) (api.Farm, error) {
+	var farm api.Farm
+	err := row.Scan(&farm.ID, &farm.Name, &farm.Address, &farm.SizeHectares, &farm.Type, &farm.CreatedAt)
+	return farm, err
+}
+
+func (s *SQLStore) ListFarms(ctx context.Context) ([]api.Farm, error) {
+	rows, err := s.conn().query(ctx, "SELECT "+farmColumns+" FROM farm ORDER BY name, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	farms := []api.Farm{}
+	for rows.Next() {
+		farm, err := scanFarm(rows)
+		if err != nil {
+			return nil, err
+		}
+		farms = append(farms, farm)
+	}
+	return farms, rows.Err()
+}
+
+func (s *SQLStore) GetFarm(ctx context.Context, id int) (api.Farm, error) {
+	return getFarm(ctx, s.conn(), id)
+}
+
+func getFarm(ctx context.Context, c conn, id int) (api.Farm, error) {
+	farm, err := scanFarm(c.queryRow(ctx, "SELECT "+farmColumns+" FROM farm WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return farm, ErrFarmNotFound
+	}
+	return farm, err
+}
+
+func (s *SQLStore) CreateFarm(ctx context.Context, farm api.Farm) (api.Farm, error) {
+	return scanFarm(s.conn().queryRow(ctx,
+		"INSERT INTO farm (name, address, size_hectares, type, created_at) VALUES (?, ?, ?, ?, ?) RETURNING "+farmColumns,
+		farm.Name, farm.Address, farm.SizeHectares, farm.Type, now()))
+}
+
+func (s *SQLStore) UpdateFarm(ctx context.Context, id int, farm api.Farm) (api.Farm, error) {
+	updated, err := scanFarm(s.conn().queryRow(ctx,
+		"UPDATE farm SET name = ?, address = ?, size_hectares = ?, type = ? WHERE id = ? RETURNING "+farmColumns,
+		farm.Name, farm.Address, farm.SizeHectares, farm.Type, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return updated, ErrFarmNotFound
+	}
+	return updated, err
+}
+
+func (s *SQLStore) DeleteFarm(ctx context.Context, id int, cascade FarmCascade) error {
+	return s.withTx(ctx, func(c conn) error {
+		if _, err := getFarm(ctx, c, id); err != nil {
+			return err
+		}
+		contacts, err := queryCustomers(ctx, c, "SELECT "+qualifiedColumns("c", customerColumns)+
+			" FROM customer_farm cf JOIN customer c ON c.id = cf.customer_id WHERE cf.farm_id = ? AND c.deleted_at IS NULL", id)
+		if err != nil {
+			return err
+		}
+		if cascade == CascadeRestrict && len(contacts) > 0 {
+			return ErrFarmHasContacts
+		}
+		if cascade == CascadeTrash {
+			for _, contact := range contacts {
+				var otherFarms int
+				err := c.queryRow(ctx, "SELECT COUNT(*) FROM customer_farm WHERE customer_id = ? AND farm_id <> ?",
+					*contact.ID, id).Scan(&otherFarms)
+				if err != nil {
+					return err
+				}
+				if otherFarms > 0 {
+					continue
+				}
+				if err := trashCustomer(ctx, c, contact); err != nil {
+					return err
+				}
+				if err := recordAudit(ctx, c, newAuditEntry(ctx, *contact.ID, OpDelete, &contact, nil)); err != nil {
+					return err
+				}
+			}
+		}
+		if _, err := c.exec(ctx, "DELETE FROM customer_farm WHERE farm_id = ?", id); err != nil {
+			return err
+		}
+		_, err = c.exec(ctx, "DELETE FROM farm WHERE id = ?", id)
+		return err
+	})
+}
+
+func (s *SQLStore) ListFarmContacts(ctx context.Context, farmID int) ([]api.FarmContact, error) {
+	c := s.conn()
+	if _, err := getFarm(ctx, c, farmID); err != nil {
+		return nil, err
+	}
+	rows, err := c.query(ctx, "SELECT "+qualifiedColumns("c", customerColumns)+", cf.role "+
+		"FROM customer_farm cf JOIN customer c ON c.id = cf.customer_id "+
+		"WHERE cf.farm_id = ? AND c.deleted_at IS NULL ORDER BY c.name, c.id", farmID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contacts := []api.FarmContact{}
+	for rows.Next() {
+		var contact api.FarmContact
+		if contact.Customer, err = scanCustomer(trailingColumns{rows, []any{&contact.Role}}); err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, contact)
+	}
+	return contacts, rows.Err()
+}
+
+func (s *SQLStore) ListCustomerFarms(ctx context.Context, customerID int) ([]api.CustomerFarm, error) {
+	c := s.conn()
+	if _, err := checkVersion(ctx, c, customerID, AnyVersion); err != nil {
+		return nil, err
+	}
+	rows, err := c.query(ctx, "SELECT "+qualifiedColumns("f", farmColumns)+", cf.role "+
+		"FROM customer_farm cf JOIN farm f ON f.id = cf.farm_id WHERE cf.customer_id = ? ORDER BY f.name, f.id", customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	farms := []api.CustomerFarm{}
+	for rows.Next() {
+		var farm api.CustomerFarm
+		if farm.Farm, err = scanFarm(trailingColumns{rows, []any{&farm.Role}}); err != nil {
+			return nil, err
+		}
+		farms = append(farms, farm)
+	}
+	return farms, rows.Err()
+}
+
+func (s *SQLStore) LinkFarm(ctx context.Context, farmID, customerID int, role string) (api.FarmContact, error) {
+	contact := api.FarmContact{Role: role}
+	err := s.withTx(ctx, func(c conn) error {
+		if _, err := getFarm(ctx, c, farmID); err != nil {
+			return err
+		}
+		var err error
+		if contact.Customer, err = checkVersion(ctx, c, customerID, AnyVersion); err != nil {
+			return err
+		}
+		_, err = c.exec(ctx, "INSERT INTO customer_farm (customer_id, farm_id, role) VALUES (?, ?, ?) "+
+			"ON CONFLICT (customer_id, farm_id) DO UPDATE SET role = excluded.role", customerID, farmID, role)
+		return err
+	})
+	return contact, err
+}
+
+func (s *SQLStore) UnlinkFarm(ctx context.Context, farmID, customerID int) error {
+	return s.withTx(ctx, func(c conn) error {
+		if _, err := getFarm(ctx, c, farmID); err != nil {
+			return err
+		}
+		if _, err := checkVersion(ctx, c, customerID, AnyVersion); err != nil {
+			return err
+		}
+		result, err := c.exec(ctx, "DELETE FROM customer_farm WHERE customer_id = ? AND farm_id = ?", customerID, farmID)
+		if err != nil {
+			return err
+		}
+		if err := requireAffected(result); err != nil {
+			return ErrFarmContactNotFound
+		}
+		return nil
+	})
+}
+
+// moveFarmLinks gives the farms of a merged customer to the customer it was
+// merged into. Where both were linked to a farm, the target keeps its role.
+func moveFarmLinks(ctx context.Context, c conn, fromID, toID int) error {
+	_, err := c.exec(ctx, "UPDATE customer_farm SET customer_id = ? WHERE customer_id = ? "+
+		"AND farm_id NOT IN (SELECT farm_id FROM customer_farm WHERE customer_id = ?)", toID, fromID, toID)
+	if err != nil {
+		return err
+	}
+	_, err = c.exec(ctx, "DELETE FROM customer_farm WHERE customer_id = ?", fromID)
+	return err
+}
+
+// farmLink identifies a link in the MemoryStore.
+type farmLink struct {
+	customerID, farmID int
+}
+
+func (m *MemoryStore) ListFarms(ctx context.Context) ([]api.Farm, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	farms := []api.Farm{}
+	for _, farm := range m.farms {
+		farms = append(farms, cloneFarm(farm))
+	}
+	sortFarms(farms, func(i int) api.Farm { return farms[i] })
+	return farms, nil
+}
+
+func (m *MemoryStore) GetFarm(ctx context.Context, id int) (api.Farm, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	farm, ok := m.farms[id]
+	if !ok {
+		return api.Farm{}, ErrFarmNotFound
+	}
+	return cloneFarm(farm), nil
+}
+
+func (m *MemoryStore) CreateFarm(ctx context.Context, farm api.Farm) (api.Farm, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastFarmID++
+	id := m.lastFarmID
+	createdAt := now()
+	farm.ID, farm.CreatedAt = &id, &createdAt
+	m.farms[id] = farm
+	return cloneFarm(farm), nil
+}
+
+func (m *MemoryStore) UpdateFarm(ctx context.Context, id int, farm api.Farm) (api.Farm, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.farms[id]
+	if !ok {
+		return api.Farm{}, ErrFarmNotFound
+	}
+	farm.ID, farm.CreatedAt = current.ID, current.CreatedAt
+	m.farms[id] = farm
+	return cloneFarm(farm), nil
+}
+
+func (m *MemoryStore) DeleteFarm(ctx context.Context, id int, cascade FarmCascade) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.farms[id]; !ok {
+		return ErrFarmNotFound
+	}
+	var contacts []int
+	for link := range m.farmLinks {
+		if link.farmID == id && m.customers[link.customerID].DeletedAt == nil {
+			contacts = append(contacts, link.customerID)
+		}
+	}
+	if cascade == CascadeRestrict && len(contacts) > 0 {
+		return ErrFarmHasContacts
+	}
+	if cascade == CascadeTrash {
+		sort.Ints(contacts)
+		for _, customerID := range contacts {
+			if m.otherFarms(customerID, id) == 0 {
+				if err := m.delete(ctx, customerID, AnyVersion); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for link := range m.farmLinks {
+		if link.farmID == id {
+			delete(m.farmLinks, link)
+		}
+	}
+	delete(m.farms, id)
+	return nil
+}
+
+// otherFarms counts the farms other than farmID a customer is linked to.
+// The caller must hold m.mu.
+func (m *MemoryStore) otherFarms(customerID, farmID int) int {
+	n := 0
+	for link := range m.farmLinks {
+		if link.customerID == customerID && link.farmID != farmID {
+			n++
+		}
+	}
+	return n
+}
+
+func (m *MemoryStore) ListFarmContacts(ctx context.Context, farmID int) ([]api.FarmContact, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.farms[farmID]; !ok {
+		return nil, ErrFarmNotFound
+	}
+	contacts := []api.FarmContact{}
+	for link, role := range m.farmLinks {
+		if customer := m.customers[link.customerID]; link.farmID == farmID && customer.DeletedAt == nil {
+			contacts = append(contacts, api.FarmContact{Role: role, Customer: cloneCustomer(customer)})
+		}
+	}
+	sort.Slice(contacts, func(i, j int) bool {
+		a, b := contacts[i].Customer, contacts[j].Customer
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return *a.ID < *b.ID
+	})
+	return contacts, nil
+}
+
+func (m *MemoryStore) ListCustomerFarms(ctx context.Context, customerID int) ([]api.CustomerFarm, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, err := m.checkVersion(customerID, AnyVersion); err != nil {
+		return nil, err
+	}
+	farms := []api.CustomerFarm{}
+	for link, role := range m.farmLinks {
+		if link.customerID == customerID {
+			farms = append(farms, api.CustomerFarm{Role: role, Farm: cloneFarm(m.farms[link.farmID])})
+		}
+	}
+	sortFarms(farms, func(i int) api.Farm { return farms[i].Farm })
+	return farms, nil
+}
+
+func (m *MemoryStore) LinkFarm(ctx context.Context, farmID, customerID int, role string) (api.FarmContact, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.farms[farmID]; !ok {
+		return api.FarmContact{}, ErrFarmNotFound
+	}
+	customer, err := m.checkVersion(customerID, AnyVersion)
+	if err != nil {
+		return api.FarmContact{}, err
+	}
+	m.farmLinks[farmLink{customerID, farmID}] = role
+	return api.FarmContact{Role: role, Customer: cloneCustomer(customer)}, nil
+}
+
+func (m *MemoryStore) UnlinkFarm(ctx context.Context, farmID, customerID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.farms[farmID]; !ok {
+		return ErrFarmNotFound
+	}
+	if _, err := m.checkVersion(customerID, AnyVersion); err != nil {
+		return err
+	}
+	link := farmLink{customerID, farmID}
+	if _, ok := m.farmLinks[link]; !ok {
+		return ErrFarmContactNotFound
+	}
+	delete(m.farmLinks, link)
+	return nil
+}
+
+// moveFarmLinks mirrors the SQL store's moveFarmLinks. The caller must hold
+// m.mu.
+func (m *MemoryStore) moveFarmLinks(fromID, toID int) {
+	for link, role := range m.farmLinks {
+		if link.customerID != fromID {
+			continue
+		}
+		delete(m.farmLinks, link)
+		moved := farmLink{toID, link.farmID}
+		if _, ok := m.farmLinks[moved]; !ok {
+			m.farmLinks[moved] = role
+		}
+	}
+}
+
+// deleteFarmLinks forgets the links of a purged customer. The caller must
+// hold m.mu.
+func (m *MemoryStore) deleteFarmLinks(customerID int) {
+	for link := range m.farmLinks {
+		if link.customerID == customerID {
+			delete(m.farmLinks, link)
+		}
+	}
+}
+
+// sortFarms orders a slice holding farms by name like the SQL store.
+func sortFarms[T any](farms []T, farm func(i int) api.Farm) {
+	sort.Slice(farms, func(i, j int) bool {
+		a, b := farm(i), farm(j)
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return *a.ID < *b.ID
+	})
+}
+
+// cloneFarm copies the pointer fields so callers cannot modify the stored farm through them.
+func cloneFarm(farm api.Farm) api.Farm {
+	id := *farm.ID
+	farm.ID = &id
+	if farm.CreatedAt != nil {
+		createdAt := *farm.CreatedAt
+		farm.CreatedAt = &createdAt
+	}
+	return farm
+}
@@ -0,0 +1,150 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// IdempotencyRepository remembers the first response to requests sent with
+// an idempotency key, so that retries are answered without being repeated.
+// Keys belong to the actor in the context; different actors may use the
+// same key.
+type IdempotencyRepository interface {
+	// ReserveKey claims key for a request with the given fingerprint until
+	// ttl has passed, and holds it for the running request until lease has
+	// passed. If the key is already claimed and has not expired, it returns
+	// the stored response and false; its Status is 0 while the first
+	// request is still running. A key whose lease passed without a response
+	// being stored is taken over by the new request.
+	ReserveKey(ctx context.Context, key, fingerprint string, ttl, lease time.Duration) (StoredResponse, bool, error)
+	// CompleteKey stores the response to the request that reserved key.
+	CompleteKey(ctx context.Context, key string, response StoredResponse) error
+	// ReleaseKey forgets a reserved key, so that the request can be retried.
+	ReleaseKey(ctx context.Context, key string) error
+}
+
+// StoredResponse is the response remembered for an idempotency key.
+type StoredResponse struct {
+	// Fingerprint identifies the request the response was sent for.
+	Fingerprint string
+	Status      int
+	Header      map[string]string
+	Body        []byte
+}
+
+func (s *SQLStore) ReserveKey(ctx context.Context, key, fingerprint string, ttl, lease time.Duration) (StoredResponse, bool, error) {
+	actor := ActorFromContext(ctx)
+	var stored StoredResponse
+	reserved := false
+	err := s.withTx(ctx, func(c conn) error {
+		reservedAt := now()
+		if _, err := c.exec(ctx, "DELETE FROM idempotency_key WHERE expires_at <= ?", reservedAt); err != nil {
+			return err
+		}
+		result, err := c.exec(ctx, "INSERT INTO idempotency_key (actor, idempotency_key, fingerprint, expires_at, locked_until) "+
+			"VALUES (?, ?, ?, ?, ?) ON CONFLICT (actor, idempotency_key) DO NOTHING",
+			actor, key, fingerprint, reservedAt.Add(ttl), reservedAt.Add(lease))
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 1 {
+			reserved = true
+			return err
+		}
+		result, err = c.exec(ctx, "UPDATE idempotency_key SET fingerprint = ?, expires_at = ?, locked_until = ? "+
+			"WHERE actor = ? AND idempotency_key = ? AND status = 0 AND (locked_until IS NULL OR locked_until <= ?)",
+			fingerprint, reservedAt.Add(ttl), reservedAt.Add(lease), actor, key, reservedAt)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 1 {
+			reserved = true
+			return err
+		}
+
+		var header sql.NullString
+		err = c.queryRow(ctx, "SELECT fingerprint, status, header, body FROM idempotency_key WHERE actor = ? AND idempotency_key = ?",
+			actor, key).Scan(&stored.Fingerprint, &stored.Status, &header, &stored.Body)
+		if err != nil {
+			return err
+		}
+		if header.Valid {
+			return json.Unmarshal([]byte(header.String), &stored.Header)
+		}
+		return nil
+	})
+	return stored, reserved, err
+}
+
+func (s *SQLStore) CompleteKey(ctx context.Context, key string, response StoredResponse) error {
+	header, err := json.Marshal(response.Header)
+	if err != nil {
+		return err
+	}
+	result, err := s.conn().exec(ctx, "UPDATE idempotency_key SET status = ?, header = ?, body = ? WHERE actor = ? AND idempotency_key = ?",
+		response.Status, string(header), response.Body, ActorFromContext(ctx), key)
+	if err != nil {
+		return err
+	}
+	return requireAffected(result)
+}
+
+func (s *SQLStore) ReleaseKey(ctx context.Context, key string) error {
+	_, err := s.conn().exec(ctx, "DELETE FROM idempotency_key WHERE actor = ? AND idempotency_key = ?", ActorFromContext(ctx), key)
+	return err
+}
+
+// idempotencyKey identifies a key in the MemoryStore.
+type idempotencyKey struct {
+	actor, key string
+}
+
+// reservedKey is a key held by the MemoryStore.
+type reservedKey struct {
+	response    StoredResponse
+	expiresAt   time.Time
+	lockedUntil time.Time
+}
+
+func (m *MemoryStore) ReserveKey(ctx context.Context, key, fingerprint string, ttl, lease time.Duration) (StoredResponse, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reservedAt := now()
+	for k, reserved := range m.idempotencyKeys {
+		if !reserved.expiresAt.After(reservedAt) {
+			delete(m.idempotencyKeys, k)
+		}
+	}
+	k := idempotencyKey{ActorFromContext(ctx), key}
+	if reserved, ok := m.idempotencyKeys[k]; ok && (reserved.response.Status != 0 || reserved.lockedUntil.After(reservedAt)) {
+		return reserved.response, false, nil
+	}
+	m.idempotencyKeys[k] = reservedKey{StoredResponse{Fingerprint: fingerprint}, reservedAt.Add(ttl), reservedAt.Add(lease)}
+	return StoredResponse{}, true, nil
+}
+
+func (m *MemoryStore) CompleteKey(ctx context.Context, key string, response StoredResponse) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := idempotencyKey{ActorFromContext(ctx), key}
+	reserved, ok := m.idempotencyKeys[k]
+	if !ok {
+		return ErrNotFound
+	}
+	response.Fingerprint = reserved.response.Fingerprint
+	reserved.response = response
+	m.idempotencyKeys[k] = reserved
+	return nil
+}
+
+func (m *MemoryStore) ReleaseKey(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.idempotencyKeys, idempotencyKey{ActorFromContext(ctx), key})
+	return nil
+}
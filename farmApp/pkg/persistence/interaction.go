@@ -0,0 +1,268 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"farmApp/pkg/api"
+	"sort"
+	"time"
+)
+
+// ErrInteractionNotFound is returned when a customer has no interaction
+// with the given ID.
+var ErrInteractionNotFound = errors.New("interaction not found")
+
+// InteractionRepository records the contacts with customers. Recording or
+// deleting an interaction updates the customer's ContactCount and
+// LastContactedAt, and recording one marks the customer as contacted. Like
+// any other change of the customer, this increments its version and is
+// recorded in its history.
+//
+// All methods return ErrNotFound if the customer does not exist or is in
+// the trash.
+type InteractionRepository interface {
+	// ListInteractions returns the interactions with a customer, most
+	// recent first.
+	ListInteractions(ctx context.Context, customerID int) ([]api.Interaction, error)
+	GetInteraction(ctx context.Context, customerID, id int) (api.Interaction, error)
+	AddInteraction(ctx context.Context, customerID int, interaction api.Interaction) (api.Interaction, error)
+	DeleteInteraction(ctx context.Context, customerID, id int) error
+}
+
+const interactionColumns = "id, customer_id, channel, occurred_at, author, notes"
+
+func scanInteraction(row rowScanner) (api.Interaction, error) {
+	var interaction api.Interaction
+	err := row.Scan(&interaction.ID, &interaction.CustomerID, &interaction.Channel, &interaction.OccurredAt,
+		&interaction.Author, &interaction.Notes)
+	return interaction, err
+}
+
+func (s *SQLStore) ListInteractions(ctx context.Context, customerID int) ([]api.Interaction, error) {
+	c := s.conn()
+	if _, err := checkVersion(ctx, c, customerID, AnyVersion); err != nil {
+		return nil, err
+	}
+	rows, err := c.query(ctx, "SELECT "+interactionColumns+" FROM customer_interaction WHERE customer_id = ? "+
+		"ORDER BY occurred_at DESC, id DESC", customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	interactions := []api.Interaction{}
+	for rows.Next() {
+		interaction, err := scanInteraction(rows)
+		if err != nil {
+			return nil, err
+		}
+		interactions = append(interactions, interaction)
+	}
+	return interactions, rows.Err()
+}
+
+func (s *SQLStore) GetInteraction(ctx context.Context, customerID, id int) (api.Interaction, error) {
+	c := s.conn()
+	if _, err := checkVersion(ctx, c, customerID, AnyVersion); err != nil {
+		return api.Interaction{}, err
+	}
+	interaction, err := scanInteraction(c.queryRow(ctx,
+		"SELECT "+interactionColumns+" FROM customer_interaction WHERE id = ? AND customer_id = ?", id, customerID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return interaction, ErrInteractionNotFound
+	}
+	return interaction, err
+}
+
+func (s *SQLStore) AddInteraction(ctx context.Context, customerID int, interaction api.Interaction) (api.Interaction, error) {
+	var added api.Interaction
+	err := s.withTx(ctx, func(c conn) error {
+		customer, err := checkVersion(ctx, c, customerID, AnyVersion)
+		if err != nil {
+			return err
+		}
+		added, err = scanInteraction(c.queryRow(ctx,
+			"INSERT INTO customer_interaction (customer_id, channel, occurred_at, author, notes) VALUES (?, ?, ?, ?, ?) "+
+				"RETURNING "+interactionColumns,
+			customerID, interaction.Channel, interactionTime(interaction.OccurredAt), interaction.Author, interaction.Notes))
+		if err != nil {
+			return err
+		}
+		return updateContacts(ctx, c, customer)
+	})
+	return added, err
+}
+
+func (s *SQLStore) DeleteInteraction(ctx context.Context, customerID, id int) error {
+	return s.withTx(ctx, func(c conn) error {
+		customer, err := checkVersion(ctx, c, customerID, AnyVersion)
+		if err != nil {
+			return err
+		}
+		result, err := c.exec(ctx, "DELETE FROM customer_interaction WHERE id = ? AND customer_id = ?", id, customerID)
+		if err != nil {
+			return err
+		}
+		if err := requireAffected(result); err != nil {
+			return ErrInteractionNotFound
+		}
+		return updateContacts(ctx, c, customer)
+	})
+}
+
+// contactsUpdate recomputes the contact fields of a customer from its
+// interactions. A customer with interactions is marked as contacted. It
+// takes the customer ID four times.
+const contactsUpdate = "UPDATE customer SET " +
+	"contact_count = (SELECT COUNT(*) FROM customer_interaction WHERE customer_id = ?), " +
+	"last_contacted_at = (SELECT MAX(occurred_at) FROM customer_interaction WHERE customer_id = ?), " +
+	"contacted = (contacted OR EXISTS (SELECT 1 FROM customer_interaction WHERE customer_id = ?))"
+
+// updateContacts recomputes the contact fields of current after its
+// interactions changed, as a new version recorded in its history.
+func updateContacts(ctx context.Context, c conn, current api.Customer) error {
+	id := *current.ID
+	updated, err := scanCustomer(c.queryRow(ctx, contactsUpdate+", version = version + 1 WHERE id = ? RETURNING "+customerColumns,
+		id, id, id, id))
+	if err != nil {
+		return err
+	}
+	return recordAudit(ctx, c, newAuditEntry(ctx, id, OpUpdate, &current, &updated))
+}
+
+// refreshContacts recomputes the contact fields without a new version, for
+// operations such as Merge that change the version and history themselves.
+func refreshContacts(ctx context.Context, c conn, customerID int) error {
+	_, err := c.exec(ctx, contactsUpdate+" WHERE id = ?", customerID, customerID, customerID, customerID)
+	return err
+}
+
+// interactionTime stores times like now does.
+func interactionTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Microsecond)
+}
+
+func (m *MemoryStore) ListInteractions(ctx context.Context, customerID int) ([]api.Interaction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, err := m.checkVersion(customerID, AnyVersion); err != nil {
+		return nil, err
+	}
+	interactions := []api.Interaction{}
+	for _, interaction := range m.interactions {
+		if interaction.CustomerID == customerID {
+			interactions = append(interactions, interaction)
+		}
+	}
+	sort.Slice(interactions, func(i, j int) bool {
+		if !interactions[i].OccurredAt.Equal(interactions[j].OccurredAt) {
+			return interactions[i].OccurredAt.After(interactions[j].OccurredAt)
+		}
+		return interactions[i].ID > interactions[j].ID
+	})
+	return interactions, nil
+}
+
+func (m *MemoryStore) GetInteraction(ctx context.Context, customerID, id int) (api.Interaction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, err := m.checkVersion(customerID, AnyVersion); err != nil {
+		return api.Interaction{}, err
+	}
+	for _, interaction := range m.interactions {
+		if interaction.ID == id && interaction.CustomerID == customerID {
+			return interaction, nil
+		}
+	}
+	return api.Interaction{}, ErrInteractionNotFound
+}
+
+func (m *MemoryStore) AddInteraction(ctx context.Context, customerID int, interaction api.Interaction) (api.Interaction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	customer, err := m.checkVersion(customerID, AnyVersion)
+	if err != nil {
+		return api.Interaction{}, err
+	}
+	m.lastInteractionID++
+	interaction.ID = m.lastInteractionID
+	interaction.CustomerID = customerID
+	interaction.OccurredAt = interactionTime(interaction.OccurredAt)
+	m.interactions = append(m.interactions, interaction)
+	m.updateContacts(ctx, customer)
+	return interaction, nil
+}
+
+func (m *MemoryStore) DeleteInteraction(ctx context.Context, customerID, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	customer, err := m.checkVersion(customerID, AnyVersion)
+	if err != nil {
+		return err
+	}
+	for i, interaction := range m.interactions {
+		if interaction.ID == id && interaction.CustomerID == customerID {
+			m.interactions = append(m.interactions[:i], m.interactions[i+1:]...)
+			m.updateContacts(ctx, customer)
+			return nil
+		}
+	}
+	return ErrInteractionNotFound
+}
+
+// updateContacts mirrors the SQL store's updateContacts. The caller must
+// hold m.mu.
+func (m *MemoryStore) updateContacts(ctx context.Context, current api.Customer) {
+	id := *current.ID
+	m.refreshContacts(id)
+	updated := m.customers[id]
+	updated.Version++
+	m.customers[id] = updated
+	m.record(newAuditEntry(ctx, id, OpUpdate, &current, &updated))
+}
+
+// refreshContacts mirrors the SQL store's refreshContacts. The caller must
+// hold m.mu.
+func (m *MemoryStore) refreshContacts(customerID int) {
+	customer := m.customers[customerID]
+	customer.ContactCount, customer.LastContactedAt = 0, nil
+	for _, interaction := range m.interactions {
+		if interaction.CustomerID != customerID {
+			continue
+		}
+		customer.ContactCount++
+		if customer.LastContactedAt == nil || interaction.OccurredAt.After(*customer.LastContactedAt) {
+			occurredAt := interaction.OccurredAt
+			customer.LastContactedAt = &occurredAt
+		}
+	}
+	customer.Contacted = customer.Contacted || customer.ContactCount > 0
+	m.customers[customerID] = customer
+}
+
+// moveInteractions gives the interactions of one customer to another. The
+// caller must hold m.mu.
+func (m *MemoryStore) moveInteractions(fromID, toID int) {
+	for i := range m.interactions {
+		if m.interactions[i].CustomerID == fromID {
+			m.interactions[i].CustomerID = toID
+		}
+	}
+}
+
+// deleteInteractions forgets the interactions of a purged customer. The
+// caller must hold m.mu.
+func (m *MemoryStore) deleteInteractions(customerID int) {
+	kept := m.interactions[:0]
+	for _, interaction := range m.interactions {
+		if interaction.CustomerID != customerID {
+			kept = append(kept, interaction)
+		}
+	}
+	m.interactions = kept
+}
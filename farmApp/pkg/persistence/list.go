@@ -0,0 +1,430 @@
+package persistence
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"farmApp/pkg/api"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCursor is returned for a cursor that was not produced by List
+// with the same sort order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListOptions selects a page of customers.
+type ListOptions struct {
+	// Limit is the maximum page size; zero means no limit.
+	Limit int
+	// Cursor continues after the page that returned it as NextCursor.
+	Cursor string
+	Filter CustomerFilter
+	// Sort orders the customers; ties, and an empty Sort, are ordered by ID.
+	Sort []SortKey
+}
+
+// CustomerFilter narrows a customer listing. Zero values match everything;
+// text comparisons ignore case.
+type CustomerFilter struct {
+	Role      string
+	Contacted *bool
+	// Name and Email match substrings.
+	Name  string
+	Email string
+	// CreatedAfter is inclusive, CreatedBefore exclusive.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// The address fields select customers with at least one address
+	// matching all of them. Address matches a substring of the street,
+	// house number, postal code or city, PostalCode the beginning of the
+	// postal code.
+	Address     string
+	PostalCode  string
+	City        string
+	Country     string
+	AddressType string
+	// Tags selects customers with any of the tags, or with all of them if
+	// AllTags is set. Tag names are compared ignoring case.
+	Tags    []string
+	AllTags bool
+}
+
+// SortKey orders customers by one field.
+type SortKey struct {
+	Field      string
+	Descending bool
+}
+
+// CustomerPage is one page of a customer listing.
+type CustomerPage struct {
+	Customers []api.Customer
+	// NextCursor fetches the following page; it is empty on the last page.
+	NextCursor string
+	// Total is the number of matching customers on all pages.
+	Total int
+}
+
+// sortField describes a field customers can be sorted by.
+type sortField struct {
+	column string
+	value  func(api.Customer) any
+	// decode reads a value of the field back from a cursor.
+	decode func(json.RawMessage) (any, error)
+}
+
+var sortFields = map[string]sortField{
+	"id":         {"id", func(c api.Customer) any { return *c.ID }, decodeAs[int]},
+	"name":       {"name", func(c api.Customer) any { return c.Name }, decodeAs[string]},
+	"role":       {"role", func(c api.Customer) any { return c.Role }, decodeAs[string]},
+	"email":      {"email", func(c api.Customer) any { return c.Email }, decodeAs[string]},
+	"phone":      {"phone", func(c api.Customer) any { return c.Phone }, decodeAs[string]},
+	"contacted":  {"contacted", func(c api.Customer) any { return c.Contacted }, decodeAs[bool]},
+	"created_at": {"created_at", func(c api.Customer) any { return *c.CreatedAt }, decodeAs[time.Time]},
+}
+
+func decodeAs[T any](data json.RawMessage) (any, error) {
+	var value T
+	err := json.Unmarshal(data, &value)
+	return value, err
+}
+
+// ParseSort parses a comma-separated list of fields, each optionally
+// prefixed with "-" for descending order, e.g. "role,-created_at".
+func ParseSort(value string) ([]SortKey, error) {
+	var keys []SortKey
+	if value == "" {
+		return keys, nil
+	}
+	for _, field := range strings.Split(value, ",") {
+		key := SortKey{Field: strings.TrimSpace(field)}
+		if name, found := strings.CutPrefix(key.Field, "-"); found {
+			key = SortKey{Field: name, Descending: true}
+		}
+		if _, ok := sortFields[key.Field]; !ok {
+			return nil, fmt.Errorf("cannot sort by %q", key.Field)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// withIDTiebreak makes the order total by adding the ID as the last key.
+func withIDTiebreak(keys []SortKey) []SortKey {
+	for _, key := range keys {
+		if key.Field == "id" {
+			return keys
+		}
+	}
+	return append(append([]SortKey(nil), keys...), SortKey{Field: "id"})
+}
+
+func formatSort(keys []SortKey) string {
+	fields := make([]string, len(keys))
+	for i, key := range keys {
+		fields[i] = key.Field
+		if key.Descending {
+			fields[i] = "-" + key.Field
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+// cursor holds the sort key values of the last customer of a page.
+type cursor struct {
+	Sort   string            `json:"s"`
+	Values []json.RawMessage `json:"v"`
+}
+
+func encodeCursor(keys []SortKey, last api.Customer) string {
+	c := cursor{Sort: formatSort(keys)}
+	for _, key := range keys {
+		value, _ := json.Marshal(sortFields[key.Field].value(last))
+		c.Values = append(c.Values, value)
+	}
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor returns the sort key values stored in the cursor, or nil for
+// an empty cursor.
+func decodeCursor(value string, keys []SortKey) ([]any, error) {
+	if value == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Sort != formatSort(keys) || len(c.Values) != len(keys) {
+		return nil, ErrInvalidCursor
+	}
+
+	values := make([]any, len(keys))
+	for i, key := range keys {
+		if values[i], err = sortFields[key.Field].decode(c.Values[i]); err != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
+	return values, nil
+}
+
+// newPage trims customers, fetched with one extra row beyond the limit, to a
+// page and sets the cursor for the next one.
+func newPage(customers []api.Customer, keys []SortKey, limit, total int) CustomerPage {
+	page := CustomerPage{Customers: customers, Total: total}
+	if limit > 0 && len(customers) > limit {
+		page.Customers = customers[:limit]
+		page.NextCursor = encodeCursor(keys, page.Customers[limit-1])
+	}
+	if page.Customers == nil {
+		page.Customers = []api.Customer{}
+	}
+	return page
+}
+
+// sqlConditions returns the WHERE conditions and arguments for the filter.
+func (f CustomerFilter) sqlConditions() ([]string, []any) {
+	var conditions []string
+	var args []any
+	if f.Role != "" {
+		conditions = append(conditions, "role_key = ?")
+		args = append(args, textKey(f.Role))
+	}
+	if f.Contacted != nil {
+		conditions = append(conditions, "contacted = ?")
+		args = append(args, *f.Contacted)
+	}
+	if f.Name != "" {
+		conditions = append(conditions, `name_key LIKE ? ESCAPE '\'`)
+		args = append(args, containsPattern(f.Name))
+	}
+	if f.Email != "" {
+		conditions = append(conditions, `email_key LIKE ? ESCAPE '\'`)
+		args = append(args, containsPattern(f.Email))
+	}
+	if !f.CreatedAfter.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, f.CreatedAfter.UTC())
+	}
+	if !f.CreatedBefore.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, f.CreatedBefore.UTC())
+	}
+	if addressConditions, addressArgs := f.sqlAddressConditions(); len(addressConditions) > 0 {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM customer_address a WHERE a.customer_id = customer.id AND "+
+			strings.Join(addressConditions, " AND ")+")")
+		args = append(args, addressArgs...)
+	}
+	if keys := f.tagKeys(); len(keys) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(keys)), ", ")
+		tagged := "FROM customer_tag ct JOIN tag t ON t.id = ct.tag_id " +
+			"WHERE ct.customer_id = customer.id AND t.name_key IN (" + placeholders + ")"
+		for _, key := range keys {
+			args = append(args, key)
+		}
+		if f.AllTags {
+			conditions = append(conditions, "(SELECT COUNT(*) "+tagged+") = ?")
+			args = append(args, len(keys))
+		} else {
+			conditions = append(conditions, "EXISTS (SELECT 1 "+tagged+")")
+		}
+	}
+	return conditions, args
+}
+
+// tagKeys returns the distinct compared names of the filter's tags.
+func (f CustomerFilter) tagKeys() []string {
+	var keys []string
+	for _, name := range f.Tags {
+		if key := tagKey(name); key != "" && !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// sqlAddressConditions returns the conditions on one customer_address row a.
+func (f CustomerFilter) sqlAddressConditions() ([]string, []any) {
+	var conditions []string
+	var args []any
+	if f.Address != "" {
+		pattern := containsPattern(f.Address)
+		conditions = append(conditions, `(a.street_key LIKE ? ESCAPE '\' OR a.house_number_key LIKE ? ESCAPE '\' `+
+			`OR a.postal_code_key LIKE ? ESCAPE '\' OR a.city_key LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern, pattern, pattern)
+	}
+	if f.PostalCode != "" {
+		conditions = append(conditions, `a.postal_code_key LIKE ? ESCAPE '\'`)
+		args = append(args, prefixPattern(f.PostalCode))
+	}
+	if f.City != "" {
+		conditions = append(conditions, "a.city_key = ?")
+		args = append(args, textKey(f.City))
+	}
+	if f.Country != "" {
+		conditions = append(conditions, "a.country = ?")
+		args = append(args, strings.ToUpper(f.Country))
+	}
+	if f.AddressType != "" {
+		conditions = append(conditions, "a.type = ?")
+		args = append(args, strings.ToLower(f.AddressType))
+	}
+	return conditions, args
+}
+
+// containsPattern builds a LIKE pattern matching value anywhere in a key column.
+func containsPattern(value string) string {
+	return "%" + prefixPattern(value)
+}
+
+// prefixPattern builds a LIKE pattern matching the beginning of a key column.
+func prefixPattern(value string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(textKey(value))
+	return escaped + "%"
+}
+
+// textKey folds a value for the text filters, which ignore case. The SQL
+// stores keep it in *_key columns, since SQLite's LOWER only folds ASCII
+// letters and the stores must agree.
+func textKey(value string) string {
+	return strings.ToLower(value)
+}
+
+// matches reports whether the customer with the given addresses and tag
+// keys is selected by the filter.
+func (f CustomerFilter) matches(c api.Customer, addresses []api.Address, tagKeys []string) bool {
+	return (f.Role == "" || textKey(c.Role) == textKey(f.Role)) &&
+		(f.Contacted == nil || c.Contacted == *f.Contacted) &&
+		(f.Name == "" || strings.Contains(textKey(c.Name), textKey(f.Name))) &&
+		(f.Email == "" || strings.Contains(textKey(c.Email), textKey(f.Email))) &&
+		(f.CreatedAfter.IsZero() || !c.CreatedAt.Before(f.CreatedAfter)) &&
+		(f.CreatedBefore.IsZero() || c.CreatedAt.Before(f.CreatedBefore)) &&
+		f.matchesAnyAddress(addresses) &&
+		f.matchesTags(tagKeys)
+}
+
+// matchesTags mirrors the tag condition of sqlConditions.
+func (f CustomerFilter) matchesTags(tagKeys []string) bool {
+	keys := f.tagKeys()
+	if len(keys) == 0 {
+		return true
+	}
+	found := 0
+	for _, key := range keys {
+		if slices.Contains(tagKeys, key) {
+			found++
+		}
+	}
+	if f.AllTags {
+		return found == len(keys)
+	}
+	return found > 0
+}
+
+// matchesAnyAddress mirrors sqlAddressConditions.
+func (f CustomerFilter) matchesAnyAddress(addresses []api.Address) bool {
+	if f.Address == "" && f.PostalCode == "" && f.City == "" && f.Country == "" && f.AddressType == "" {
+		return true
+	}
+	contains := func(value string) bool {
+		return strings.Contains(textKey(value), textKey(f.Address))
+	}
+	for _, a := range addresses {
+		if (f.Address == "" || contains(a.Street) || contains(a.HouseNumber) || contains(a.PostalCode) || contains(a.City)) &&
+			strings.HasPrefix(textKey(a.PostalCode), textKey(f.PostalCode)) &&
+			(f.City == "" || textKey(a.City) == textKey(f.City)) &&
+			(f.Country == "" || strings.EqualFold(a.Country, f.Country)) &&
+			(f.AddressType == "" || strings.EqualFold(a.Type, f.AddressType)) {
+			return true
+		}
+	}
+	return false
+}
+
+// sqlOrderBy returns the ORDER BY clause for the keys.
+func sqlOrderBy(keys []SortKey) string {
+	columns := make([]string, len(keys))
+	for i, key := range keys {
+		columns[i] = sortFields[key.Field].column
+		if key.Descending {
+			columns[i] += " DESC"
+		}
+	}
+	return " ORDER BY " + strings.Join(columns, ", ")
+}
+
+// sqlAfter returns the condition selecting the rows that follow the given
+// sort key values: (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ..., with < for
+// descending keys.
+func sqlAfter(keys []SortKey, values []any) (string, []any) {
+	var alternatives []string
+	var args []any
+	for i, key := range keys {
+		var terms []string
+		for j := 0; j < i; j++ {
+			terms = append(terms, sortFields[keys[j].Field].column+" = ?")
+			args = append(args, values[j])
+		}
+		op := " > ?"
+		if key.Descending {
+			op = " < ?"
+		}
+		terms = append(terms, sortFields[key.Field].column+op)
+		args = append(args, values[i])
+		alternatives = append(alternatives, "("+strings.Join(terms, " AND ")+")")
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")", args
+}
+
+// compareCustomers orders two customers by the keys.
+func compareCustomers(keys []SortKey, a, b api.Customer) int {
+	for _, key := range keys {
+		field := sortFields[key.Field]
+		if c := compareValues(field.value(a), field.value(b)); c != 0 {
+			if key.Descending {
+				return -c
+			}
+			return c
+		}
+	}
+	return 0
+}
+
+// isAfter reports whether the customer follows the sort key values of a cursor.
+func isAfter(keys []SortKey, c api.Customer, values []any) bool {
+	for i, key := range keys {
+		cmp := compareValues(sortFields[key.Field].value(c), values[i])
+		if key.Descending {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp > 0
+		}
+	}
+	return false
+}
+
+func compareValues(a, b any) int {
+	switch a := a.(type) {
+	case int:
+		return cmp.Compare(a, b.(int))
+	case string:
+		return strings.Compare(a, b.(string))
+	case bool:
+		if a == b.(bool) {
+			return 0
+		}
+		if a {
+			return 1
+		}
+		return -1
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	}
+	panic(fmt.Sprintf("cannot compare %T", a))
+}
@@ -0,0 +1,284 @@
+package persistence
+
+import (
+	"context"
+	"farmApp/pkg/api"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a thread-safe CustomerRepository that keeps all customers
+// in memory. It assigns IDs like the SQLite store: starting at 1 and never
+// reusing the ID of a deleted customer.
+type MemoryStore struct {
+	mu          sync.RWMutex
+	customers   map[int]api.Customer
+	lastID      int
+	audit       []api.AuditEntry
+	lastAuditID int
+
+	interactions      []api.Interaction
+	lastInteractionID int
+
+	addresses     []api.Address
+	lastAddressID int
+
+	farms      map[int]api.Farm
+	lastFarmID int
+	// farmLinks holds the role of each customer on each of its farms.
+	farmLinks map[farmLink]string
+
+	products      map[int]api.Product
+	lastProductID int
+	orders        map[int]api.Order
+	lastOrderID   int
+
+	tags         map[int]api.Tag
+	lastTagID    int
+	customerTags map[customerTag]struct{}
+
+	idempotencyKeys map[idempotencyKey]reservedKey
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{customers: map[int]api.Customer{}, farms: map[int]api.Farm{}, farmLinks: map[farmLink]string{},
+		products: map[int]api.Product{}, orders: map[int]api.Order{}, tags: map[int]api.Tag{},
+		customerTags: map[customerTag]struct{}{}, idempotencyKeys: map[idempotencyKey]reservedKey{}}
+}
+
+// Close is a no-op; it lets MemoryStore satisfy Store.
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// create stores the customer under the next ID. The caller must hold m.mu.
+func (m *MemoryStore) create(ctx context.Context, customer api.Customer) api.Customer {
+	m.lastID++
+	id := m.lastID
+	createdAt := now()
+	customer.ID = &id
+	customer.CreatedAt = &createdAt
+	customer.DeletedAt = nil
+	customer.LastContactedAt = nil
+	customer.ContactCount = 0
+	customer.Version = 1
+	m.customers[id] = customer
+	m.record(newAuditEntry(ctx, id, OpCreate, nil, &customer))
+	return cloneCustomer(customer)
+}
+
+// record appends an audit entry. The caller must hold m.mu.
+func (m *MemoryStore) record(entry api.AuditEntry) {
+	m.lastAuditID++
+	entry.ID = m.lastAuditID
+	m.audit = append(m.audit, entry)
+}
+
+func (m *MemoryStore) List(ctx context.Context, opts ListOptions) (CustomerPage, error) {
+	keys := withIDTiebreak(opts.Sort)
+	after, err := decodeCursor(opts.Cursor, keys)
+	if err != nil {
+		return CustomerPage{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var customers []api.Customer
+	total := 0
+	for id, customer := range m.customers {
+		if customer.DeletedAt != nil || !opts.Filter.matches(customer, m.addressesOf(id), m.tagKeysOf(id)) {
+			continue
+		}
+		total++
+		if after == nil || isAfter(keys, customer, after) {
+			customers = append(customers, cloneCustomer(customer))
+		}
+	}
+	sort.Slice(customers, func(i, j int) bool { return compareCustomers(keys, customers[i], customers[j]) < 0 })
+	if opts.Limit > 0 && len(customers) > opts.Limit+1 {
+		customers = customers[:opts.Limit+1]
+	}
+	return newPage(customers, keys, opts.Limit, total), nil
+}
+
+func (m *MemoryStore) Get(ctx context.Context, id int) (api.Customer, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	customer, ok := m.customers[id]
+	if !ok || customer.DeletedAt != nil {
+		return api.Customer{}, ErrNotFound
+	}
+	return cloneCustomer(customer), nil
+}
+
+func (m *MemoryStore) Create(ctx context.Context, customer api.Customer) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return *m.create(ctx, customer).ID, nil
+}
+
+func (m *MemoryStore) Update(ctx context.Context, id, version int, customer api.Customer) (api.Customer, error) {
+	return m.Patch(ctx, id, version, func(api.Customer) (api.Customer, error) { return customer, nil })
+}
+
+func (m *MemoryStore) Patch(ctx context.Context, id, version int, change func(api.Customer) (api.Customer, error)) (api.Customer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.update(ctx, id, version, change)
+}
+
+// update mirrors the SQL store's updateCustomer. The caller must hold m.mu.
+func (m *MemoryStore) update(ctx context.Context, id, version int, change func(api.Customer) (api.Customer, error)) (api.Customer, error) {
+	existing, err := m.checkVersion(id, version)
+	if err != nil {
+		return api.Customer{}, err
+	}
+	customer, err := change(cloneCustomer(existing))
+	if err != nil {
+		return api.Customer{}, err
+	}
+	customer.ID = &id
+	customer.Contacted = customer.Contacted || existing.ContactCount > 0
+	customer.LastContactedAt = existing.LastContactedAt
+	customer.ContactCount = existing.ContactCount
+	customer.CreatedAt = existing.CreatedAt
+	customer.DeletedAt = nil
+	customer.Version = existing.Version + 1
+	m.customers[id] = customer
+	m.record(newAuditEntry(ctx, id, OpUpdate, &existing, &customer))
+	return cloneCustomer(customer), nil
+}
+
+func (m *MemoryStore) Delete(ctx context.Context, id, version int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.delete(ctx, id, version)
+}
+
+// delete mirrors the SQL store's deleteCustomer. The caller must hold m.mu.
+func (m *MemoryStore) delete(ctx context.Context, id, version int) error {
+	customer, err := m.checkVersion(id, version)
+	if err != nil {
+		return err
+	}
+	before := customer
+	deletedAt := now()
+	customer.DeletedAt = &deletedAt
+	customer.Version++
+	m.customers[id] = customer
+	m.record(newAuditEntry(ctx, id, OpDelete, &before, nil))
+	return nil
+}
+
+// checkVersion mirrors the SQL store's checkVersion. The caller must hold m.mu.
+func (m *MemoryStore) checkVersion(id, version int) (api.Customer, error) {
+	customer, ok := m.customers[id]
+	if !ok || customer.DeletedAt != nil {
+		return api.Customer{}, ErrNotFound
+	}
+	if version != AnyVersion && version != customer.Version {
+		return api.Customer{}, ErrVersionConflict
+	}
+	return customer, nil
+}
+
+func (m *MemoryStore) ListTrash(ctx context.Context) ([]api.Customer, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	customers := []api.Customer{}
+	for _, customer := range m.customers {
+		if customer.DeletedAt != nil {
+			customers = append(customers, cloneCustomer(customer))
+		}
+	}
+	sort.Slice(customers, func(i, j int) bool {
+		if !customers[i].DeletedAt.Equal(*customers[j].DeletedAt) {
+			return customers[i].DeletedAt.After(*customers[j].DeletedAt)
+		}
+		return *customers[i].ID < *customers[j].ID
+	})
+	return customers, nil
+}
+
+func (m *MemoryStore) Restore(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	customer, ok := m.customers[id]
+	if !ok || customer.DeletedAt == nil {
+		return ErrNotFound
+	}
+	customer.DeletedAt = nil
+	customer.Version++
+	m.customers[id] = customer
+	m.record(newAuditEntry(ctx, id, OpRestore, nil, &customer))
+	return nil
+}
+
+func (m *MemoryStore) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	purged := 0
+	for id, customer := range m.customers {
+		if customer.DeletedAt != nil && customer.DeletedAt.Before(deletedBefore) {
+			delete(m.customers, id)
+			m.deleteInteractions(id)
+			m.deleteAddresses(id)
+			m.deleteFarmLinks(id)
+			m.deleteOrders(id)
+			m.deleteCustomerTags(id)
+			m.record(newAuditEntry(ctx, id, OpPurge, &customer, nil))
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (m *MemoryStore) History(ctx context.Context, customerID int) ([]api.AuditEntry, error) {
+	// Every customer in this store has at least its creation in the log.
+	entries, err := m.ListAudit(ctx, AuditFilter{CustomerID: customerID})
+	if err == nil && len(entries) == 0 {
+		return nil, ErrNotFound
+	}
+	return entries, err
+}
+
+func (m *MemoryStore) ListAudit(ctx context.Context, filter AuditFilter) ([]api.AuditEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entries := []api.AuditEntry{}
+	for i := len(m.audit) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(entries) == filter.Limit {
+			break
+		}
+		if filter.matches(m.audit[i]) {
+			entries = append(entries, m.audit[i])
+		}
+	}
+	return entries, nil
+}
+
+// cloneCustomer copies the pointer fields so callers cannot modify the stored customer through them.
+func cloneCustomer(customer api.Customer) api.Customer {
+	id := *customer.ID
+	customer.ID = &id
+	if customer.CreatedAt != nil {
+		createdAt := *customer.CreatedAt
+		customer.CreatedAt = &createdAt
+	}
+	if customer.DeletedAt != nil {
+		deletedAt := *customer.DeletedAt
+		customer.DeletedAt = &deletedAt
+	}
+	if customer.LastContactedAt != nil {
+		lastContactedAt := *customer.LastContactedAt
+		customer.LastContactedAt = &lastContactedAt
+	}
+	return customer
+}
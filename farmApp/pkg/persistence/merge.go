@@ -0,0 +1,347 @@
+package persistence
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"farmApp/pkg/api"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// ErrSelfMerge is returned when a customer is merged with itself.
+var ErrSelfMerge = errors.New("a customer cannot be merged with itself")
+
+// MergeRepository finds and combines duplicate customers.
+type MergeRepository interface {
+	// Duplicates returns the pairs of customers scoring at least minScore,
+	// most likely first.
+	Duplicates(ctx context.Context, minScore float64) ([]api.DuplicatePair, error)
+	// Merge passes the target and source customer to combine and stores the
+	// result as the target, moving the source to the trash, in one atomic
+	// step. Both must have the expected versions (or AnyVersion is passed).
+	// The histories of both customers record the merge.
+	Merge(ctx context.Context, targetID, targetVersion, sourceID, sourceVersion int,
+		combine func(target, source api.Customer) (api.Customer, error)) (api.Customer, error)
+}
+
+// Weights of the evidence for a duplicate, combined as independent
+// probabilities.
+const (
+	sameEmailWeight   = 0.8
+	samePhoneWeight   = 0.7
+	sameNameWeight    = 0.7
+	similarNameWeight = 0.5
+	sharedNameWeight  = 0.3
+	// similarNameRatio is the least share of matching characters for
+	// names to count as similar.
+	similarNameRatio = 0.8
+)
+
+// duplicateKeys are the normalized values of a customer that are compared.
+type duplicateKeys struct {
+	email string
+	phone string
+	// names holds the words of the name, transliterated and folded, sorted.
+	names []string
+}
+
+func newDuplicateKeys(c api.Customer) duplicateKeys {
+	keys := duplicateKeys{email: strings.ToLower(strings.TrimSpace(c.Email)), phone: normalizePhone(c.Phone)}
+	for _, span := range wordSpans(c.Name) {
+		keys.names = append(keys.names, fold(transliterate.Replace(strings.ToLower(c.Name[span[0]:span[1]]))))
+	}
+	slices.Sort(keys.names)
+	return keys
+}
+
+// normalizePhone keeps the digits of a phone number, writing German numbers
+// in international format the national way.
+func normalizePhone(phone string) string {
+	phone = strings.TrimSpace(phone)
+	digits := strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, phone)
+	if strings.HasPrefix(phone, "+") {
+		digits = "00" + digits
+	}
+	if rest, ok := strings.CutPrefix(digits, "0049"); ok {
+		digits = "0" + rest
+	}
+	if len(digits) < 5 {
+		return ""
+	}
+	return digits
+}
+
+// nameStem drops the German feminine suffix, so that "Bauerin" matches
+// "Bauer".
+func nameStem(name string) string {
+	if stem, ok := strings.CutSuffix(name, "in"); ok && len(stem) >= 3 {
+		return stem
+	}
+	return name
+}
+
+// compareDuplicates scores the evidence that a and b are duplicates.
+func compareDuplicates(a, b duplicateKeys) (float64, []string) {
+	unlikely := 1.0
+	var reasons []string
+	add := func(weight float64, reason string) {
+		unlikely *= 1 - weight
+		reasons = append(reasons, reason)
+	}
+
+	if a.email != "" && a.email == b.email {
+		add(sameEmailWeight, "same email")
+	}
+	if a.phone != "" && a.phone == b.phone {
+		add(samePhoneWeight, "same phone")
+	}
+	nameA, nameB := strings.Join(a.names, " "), strings.Join(b.names, " ")
+	switch {
+	case nameA == "" || nameB == "":
+	case nameA == nameB:
+		add(sameNameWeight, "same name")
+	case similarity(nameA, nameB) >= similarNameRatio:
+		add(similarNameWeight, "similar name")
+	default:
+		for _, name := range a.names {
+			if slices.ContainsFunc(b.names, func(other string) bool { return nameStem(name) == nameStem(other) }) {
+				add(sharedNameWeight, fmt.Sprintf("both names contain %q", nameStem(name)))
+				break
+			}
+		}
+	}
+	return math.Round((1-unlikely)*100) / 100, reasons
+}
+
+// similarity is one minus the edit distance of a and b relative to the
+// longer one.
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			substitution := previous[j-1]
+			if ra[i-1] != rb[j-1] {
+				substitution++
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, substitution)
+		}
+		previous, current = current, previous
+	}
+	return 1 - float64(previous[len(rb)])/float64(max(len(ra), len(rb), 1))
+}
+
+// groups returns the keys of the groups a customer is put in before
+// scoring: its email, its phone and the stem of every word of its name.
+// Customers that share no group are never compared, so names misspelt in
+// every word are not reported.
+func (k duplicateKeys) groups() []string {
+	var groups []string
+	if k.email != "" {
+		groups = append(groups, "email:"+k.email)
+	}
+	if k.phone != "" {
+		groups = append(groups, "phone:"+k.phone)
+	}
+	for _, name := range k.names {
+		groups = append(groups, "name:"+nameStem(name))
+	}
+	slices.Sort(groups)
+	return slices.Compact(groups)
+}
+
+// duplicateCandidates returns the index pairs of the customers sharing at
+// least one group, each pair once and in index order.
+func duplicateCandidates(keys []duplicateKeys) [][2]int {
+	groups := map[string][]int{}
+	for i, k := range keys {
+		for _, group := range k.groups() {
+			groups[group] = append(groups[group], i)
+		}
+	}
+
+	seen := map[[2]int]bool{}
+	var candidates [][2]int
+	for _, members := range groups {
+		for a := range members {
+			for _, j := range members[a+1:] {
+				pair := [2]int{members[a], j}
+				if !seen[pair] {
+					seen[pair] = true
+					candidates = append(candidates, pair)
+				}
+			}
+		}
+	}
+	slices.SortFunc(candidates, func(a, b [2]int) int {
+		return cmp.Or(cmp.Compare(a[0], b[0]), cmp.Compare(a[1], b[1]))
+	})
+	return candidates
+}
+
+// findDuplicates scores the pairs of customers that share an email, a
+// phone or a part of the name.
+func findDuplicates(customers []api.Customer, minScore float64) []api.DuplicatePair {
+	slices.SortFunc(customers, func(a, b api.Customer) int { return cmp.Compare(*a.ID, *b.ID) })
+	keys := make([]duplicateKeys, len(customers))
+	for i, customer := range customers {
+		keys[i] = newDuplicateKeys(customer)
+	}
+
+	pairs := []api.DuplicatePair{}
+	for _, candidate := range duplicateCandidates(keys) {
+		i, j := candidate[0], candidate[1]
+		score, reasons := compareDuplicates(keys[i], keys[j])
+		if len(reasons) > 0 && score >= minScore {
+			pairs = append(pairs, api.DuplicatePair{Customers: []api.Customer{customers[i], customers[j]}, Score: score, Reasons: reasons})
+		}
+	}
+	// The stable sort keeps pairs with equal scores in ID order.
+	slices.SortStableFunc(pairs, func(a, b api.DuplicatePair) int { return cmp.Compare(b.Score, a.Score) })
+	return pairs
+}
+
+// mergeAuditEntries records a merge in the histories of the target, whose
+// entry names the source, and of the source, whose entry names the target.
+func mergeAuditEntries(ctx context.Context, target, merged, source api.Customer) (api.AuditEntry, api.AuditEntry) {
+	into := newAuditEntry(ctx, *target.ID, OpMerge, &target, &merged)
+	into.After["merged_from"] = *source.ID
+	from := newAuditEntry(ctx, *source.ID, OpMerge, &source, nil)
+	from.After = map[string]any{"merged_into": *target.ID}
+	return into, from
+}
+
+func (s *SQLStore) Duplicates(ctx context.Context, minScore float64) ([]api.DuplicatePair, error) {
+	customers, err := s.queryCustomers(ctx, "SELECT "+customerColumns+" FROM customer WHERE deleted_at IS NULL")
+	if err != nil {
+		return nil, err
+	}
+	return findDuplicates(customers, minScore), nil
+}
+
+func (s *SQLStore) Merge(ctx context.Context, targetID, targetVersion, sourceID, sourceVersion int,
+	combine func(target, source api.Customer) (api.Customer, error)) (api.Customer, error) {
+	if targetID == sourceID {
+		return api.Customer{}, ErrSelfMerge
+	}
+	var merged api.Customer
+	err := s.withTx(ctx, func(c conn) error {
+		target, err := checkVersion(ctx, c, targetID, targetVersion)
+		if err != nil {
+			return err
+		}
+		source, err := checkVersion(ctx, c, sourceID, sourceVersion)
+		if err != nil {
+			return err
+		}
+		customer, err := combine(target, source)
+		if err != nil {
+			return err
+		}
+		if _, err := c.exec(ctx, "UPDATE customer_interaction SET customer_id = ? WHERE customer_id = ?", targetID, sourceID); err != nil {
+			return err
+		}
+		if err := refreshContacts(ctx, c, targetID); err != nil {
+			return err
+		}
+		if err := refreshContacts(ctx, c, sourceID); err != nil {
+			return err
+		}
+		if _, err := c.exec(ctx, "UPDATE customer_address SET customer_id = ? WHERE customer_id = ?", targetID, sourceID); err != nil {
+			return err
+		}
+		if err := moveFarmLinks(ctx, c, sourceID, targetID); err != nil {
+			return err
+		}
+		if _, err := c.exec(ctx, "UPDATE customer_order SET customer_id = ? WHERE customer_id = ?", targetID, sourceID); err != nil {
+			return err
+		}
+		if err := moveCustomerTags(ctx, c, sourceID, targetID); err != nil {
+			return err
+		}
+		if merged, err = storeCustomer(ctx, c, target, customer); err != nil {
+			return err
+		}
+		if err := trashCustomer(ctx, c, source); err != nil {
+			return err
+		}
+		into, from := mergeAuditEntries(ctx, target, merged, source)
+		if err := recordAudit(ctx, c, into); err != nil {
+			return err
+		}
+		return recordAudit(ctx, c, from)
+	})
+	return merged, err
+}
+
+func (m *MemoryStore) Duplicates(ctx context.Context, minScore float64) ([]api.DuplicatePair, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var customers []api.Customer
+	for _, customer := range m.customers {
+		if customer.DeletedAt == nil {
+			customers = append(customers, cloneCustomer(customer))
+		}
+	}
+	return findDuplicates(customers, minScore), nil
+}
+
+func (m *MemoryStore) Merge(ctx context.Context, targetID, targetVersion, sourceID, sourceVersion int,
+	combine func(target, source api.Customer) (api.Customer, error)) (api.Customer, error) {
+	if targetID == sourceID {
+		return api.Customer{}, ErrSelfMerge
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	target, err := m.checkVersion(targetID, targetVersion)
+	if err != nil {
+		return api.Customer{}, err
+	}
+	source, err := m.checkVersion(sourceID, sourceVersion)
+	if err != nil {
+		return api.Customer{}, err
+	}
+	merged, err := combine(cloneCustomer(target), cloneCustomer(source))
+	if err != nil {
+		return api.Customer{}, err
+	}
+	merged.ID, merged.CreatedAt, merged.DeletedAt = target.ID, target.CreatedAt, nil
+	merged.Version = target.Version + 1
+	m.customers[targetID] = merged
+
+	trashed := source
+	deletedAt := now()
+	trashed.DeletedAt = &deletedAt
+	trashed.Version++
+	m.customers[sourceID] = trashed
+
+	m.moveInteractions(sourceID, targetID)
+	m.refreshContacts(targetID)
+	m.refreshContacts(sourceID)
+	m.moveAddresses(sourceID, targetID)
+	m.moveFarmLinks(sourceID, targetID)
+	m.moveOrders(sourceID, targetID)
+	m.moveCustomerTags(sourceID, targetID)
+	merged = m.customers[targetID]
+
+	into, from := mergeAuditEntries(ctx, target, merged, source)
+	m.record(into)
+	m.record(from)
+	return cloneCustomer(merged), nil
+}
@@ -0,0 +1,317 @@
+package persistence
+
+import (
+	"context"
+	"embed"
+	"farmApp/pkg/api"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Migration is one numbered schema change with its up and down scripts.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+	// Requires names a database feature the migration needs, declared by a
+	// "-- requires: <feature>" first line of the up script. Such a migration
+	// is optional: it stays pending while the feature is missing, so nothing
+	// else may depend on it.
+	Requires string
+}
+
+// MigrationStatus reports whether a migration has been applied.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Requires  string
+	AppliedAt *time.Time
+}
+
+// requiresPrefix starts the line of an up script that declares Requires.
+const requiresPrefix = "-- requires:"
+
+// loadMigrations reads the NNNN_name.up.sql / NNNN_name.down.sql pairs in dir.
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		fileName := entry.Name()
+		base, direction, ok := splitMigrationName(fileName)
+		if !ok {
+			return nil, fmt.Errorf("unexpected migration file %q", fileName)
+		}
+		number, name, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(number)
+		if err != nil {
+			return nil, fmt.Errorf("migration file %q has no version number", fileName)
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, fileName))
+		if err != nil {
+			return nil, err
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(content)
+			firstLine, _, _ := strings.Cut(m.Up, "\n")
+			if feature, ok := strings.CutPrefix(firstLine, requiresPrefix); ok {
+				m.Requires = strings.TrimSpace(feature)
+			}
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d (%s) needs both an up and a down script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+func splitMigrationName(fileName string) (base, direction string, ok bool) {
+	for _, direction := range []string{"up", "down"} {
+		if base, found := strings.CutSuffix(fileName, "."+direction+".sql"); found {
+			return base, direction, true
+		}
+	}
+	return "", "", false
+}
+
+func (s *SQLStore) migrations() ([]Migration, error) {
+	return loadMigrations(migrationFiles, path.Join("migrations", s.dialect.migrations))
+}
+
+func (s *SQLStore) createMigrationsTable(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+    CREATE TABLE IF NOT EXISTS schema_migrations (
+        version INTEGER PRIMARY KEY,
+        name TEXT NOT NULL,
+        applied_at TIMESTAMP NOT NULL
+    );`)
+	return err
+}
+
+func (s *SQLStore) appliedMigrations(ctx context.Context) (map[int]time.Time, error) {
+	if err := s.createMigrationsTable(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := s.conn().query(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// MigrationStatus lists every known migration and when it was applied.
+func (s *SQLStore) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := s.migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		status[i] = MigrationStatus{Version: m.Version, Name: m.Name, Requires: m.Requires}
+		if appliedAt, ok := applied[m.Version]; ok {
+			status[i].AppliedAt = &appliedAt
+		}
+	}
+	return status, nil
+}
+
+// Migrate applies all pending migrations in version order, skipping those
+// that need a feature the database lacks, and then fills the filter keys of
+// rows stored without them.
+func (s *SQLStore) Migrate(ctx context.Context) error {
+	migrations, err := s.migrations()
+	if err != nil {
+		return err
+	}
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if m.Requires != "" {
+			supported, err := s.Supports(ctx, m.Requires)
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+			}
+			if !supported {
+				log.Printf("WARNING: skipping migration %04d_%s: the database does not support %s; %s",
+					m.Version, m.Name, m.Requires, featureHints[m.Requires])
+				continue
+			}
+		}
+		log.Printf("Applying migration %04d_%s", m.Version, m.Name)
+		err := s.runMigration(ctx, m.Up, func(c conn) error {
+			_, err := c.exec(ctx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+				m.Version, m.Name, time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+	return s.fillTextKeys(ctx)
+}
+
+// fillTextKeys sets the key columns of the text filters on rows stored
+// before those columns were added. SQL cannot fold them the way textKey
+// does, so this runs after the migrations.
+func (s *SQLStore) fillTextKeys(ctx context.Context) error {
+	return s.withTx(ctx, func(c conn) error {
+		var customers []api.Customer
+		rows, err := c.query(ctx, "SELECT id, name, role, email FROM customer WHERE name_key IS NULL")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var customer api.Customer
+			if err := rows.Scan(&customer.ID, &customer.Name, &customer.Role, &customer.Email); err != nil {
+				return err
+			}
+			customers = append(customers, customer)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		var addresses []api.Address
+		rows, err = c.query(ctx, "SELECT id, street, house_number, postal_code, city FROM customer_address WHERE city_key IS NULL")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var address api.Address
+			if err := rows.Scan(&address.ID, &address.Street, &address.HouseNumber, &address.PostalCode, &address.City); err != nil {
+				return err
+			}
+			addresses = append(addresses, address)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, customer := range customers {
+			if _, err := c.exec(ctx, "UPDATE customer SET name_key = ?, role_key = ?, email_key = ? WHERE id = ?",
+				textKey(customer.Name), textKey(customer.Role), textKey(customer.Email), *customer.ID); err != nil {
+				return err
+			}
+		}
+		for _, address := range addresses {
+			if _, err := c.exec(ctx, "UPDATE customer_address SET street_key = ?, house_number_key = ?, postal_code_key = ?, city_key = ? WHERE id = ?",
+				textKey(address.Street), textKey(address.HouseNumber), textKey(address.PostalCode), textKey(address.City), address.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Rollback reverts the given number of most recently applied migrations.
+func (s *SQLStore) Rollback(ctx context.Context, steps int) error {
+	status, err := s.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+	migrations, err := s.migrations()
+	if err != nil {
+		return err
+	}
+
+	for i := len(status) - 1; i >= 0 && steps > 0; i-- {
+		if status[i].AppliedAt == nil {
+			continue
+		}
+		m := migrations[i]
+		log.Printf("Rolling back migration %04d_%s", m.Version, m.Name)
+		err := s.runMigration(ctx, m.Down, func(c conn) error {
+			_, err := c.exec(ctx, "DELETE FROM schema_migrations WHERE version = ?", m.Version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("rollback %04d_%s: %w", m.Version, m.Name, err)
+		}
+		steps--
+	}
+	return nil
+}
+
+// featureHints tell how to get a feature named in a requires line, and what
+// is missing without it.
+var featureHints = map[string]string{
+	"fts5": "build with -tags sqlite_fts5, otherwise search scans every customer",
+}
+
+// Supports reports whether the database offers a feature named in a
+// migration's requires line.
+func (s *SQLStore) Supports(ctx context.Context, feature string) (bool, error) {
+	switch {
+	case feature == "fts5" && s.dialect == sqliteDialect:
+		// mattn/go-sqlite3 only compiles FTS5 with the sqlite_fts5 build tag.
+		var used bool
+		err := s.conn().queryRow(ctx, "SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&used)
+		return used, err
+	case feature == "fts5":
+		return false, nil
+	}
+	return false, fmt.Errorf("unknown database feature %q", feature)
+}
+
+// runMigration executes a migration script and its bookkeeping in one transaction.
+func (s *SQLStore) runMigration(ctx context.Context, script string, record func(c conn) error) error {
+	return s.withTx(ctx, func(c conn) error {
+		if _, err := c.exec(ctx, script); err != nil {
+			return err
+		}
+		return record(c)
+	})
+}
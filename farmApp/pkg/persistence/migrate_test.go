@@ -0,0 +1,106 @@
+package persistence
+
+import (
+	"context"
+	"farmApp/pkg/api"
+	"testing"
+)
+
+// Tests that all migrations can be applied, rolled back and applied again
+func TestMigrateRoundTrip(t *testing.T) {
+	testMigrateRoundTrip(t, openSQLiteTestStore(t))
+}
+
+func testMigrateRoundTrip(t *testing.T, store *SQLStore) {
+	ctx := context.Background()
+
+	status, err := store.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range status {
+		if m.AppliedAt == nil && !supports(t, store, m.Requires) {
+			continue
+		}
+		if m.AppliedAt == nil {
+			t.Errorf("migration %d was not applied on open", m.Version)
+		}
+	}
+
+	if err := store.Rollback(ctx, len(status)); err != nil {
+		t.Fatal(err)
+	}
+	status, err = store.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range status {
+		if m.AppliedAt != nil {
+			t.Errorf("migration %d is still applied after rollback", m.Version)
+		}
+	}
+
+	if err := store.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.List(ctx, ListOptions{}); err != nil {
+		t.Errorf("customer table is not usable after re-applying migrations: %v", err)
+	}
+}
+
+// Tests that migrating fills the filter keys of rows stored before they existed
+func TestMigrateFillsTextKeys(t *testing.T) {
+	ctx := context.Background()
+	store := openSQLiteTestStore(t)
+	id, err := store.Create(ctx, api.Customer{Name: "Özlem Müller"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddAddress(ctx, id, api.Address{Type: api.AddressBilling, Street: "Ölmühlweg", PostalCode: "80331",
+		City: "ÜBERSEE", Country: "DE"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Rollback(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	page, err := store.List(ctx, ListOptions{Filter: CustomerFilter{Name: "özlem", Address: "ölmühl", City: "übersee"}})
+	if err != nil || page.Total != 1 {
+		t.Errorf("List did not find a customer stored before the filter keys: got %+v, %v", page, err)
+	}
+}
+
+// supports reports whether the store can apply a migration with the given requirement.
+func supports(t *testing.T, store *SQLStore, feature string) bool {
+	if feature == "" {
+		return true
+	}
+	supported, err := store.Supports(context.Background(), feature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return supported
+}
+
+// Tests that migration files are paired, numbered and exist for every dialect
+func TestLoadMigrations(t *testing.T) {
+	count := -1
+	for _, d := range []*dialect{sqliteDialect, postgresDialect} {
+		migrations, err := loadMigrations(migrationFiles, "migrations/"+d.migrations)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, m := range migrations {
+			if m.Version != i+1 {
+				t.Errorf("%s migration versions are not contiguous: got %v want %v", d.name, m.Version, i+1)
+			}
+		}
+		if count >= 0 && len(migrations) != count {
+			t.Errorf("%s has a different number of migrations: got %v want %v", d.name, len(migrations), count)
+		}
+		count = len(migrations)
+	}
+}
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS customer (
+    id SERIAL PRIMARY KEY,
+    name TEXT,
+    role TEXT,
+    email TEXT,
+    phone TEXT,
+    contacted BOOLEAN
+);
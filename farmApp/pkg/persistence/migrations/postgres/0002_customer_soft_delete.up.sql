@@ -0,0 +1 @@
+ALTER TABLE customer ADD COLUMN deleted_at TIMESTAMPTZ;
@@ -0,0 +1 @@
+ALTER TABLE customer ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,5 @@
+ALTER TABLE customer ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+UPDATE customer SET created_at = a.created_at
+FROM (SELECT customer_id, MIN(occurred_at) AS created_at FROM customer_audit WHERE operation = 'create' GROUP BY customer_id) a
+WHERE a.customer_id = customer.id;
+CREATE INDEX customer_created_at ON customer (created_at);
@@ -0,0 +1,3 @@
+DROP INDEX customer_search;
+ALTER TABLE customer DROP COLUMN search;
+DROP FUNCTION search_fold(TEXT);
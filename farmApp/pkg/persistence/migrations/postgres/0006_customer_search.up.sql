@@ -0,0 +1,14 @@
+-- search_fold lowercases a value, strips diacritics and splits it into
+-- words, as the SQLite unicode61 tokenizer does.
+CREATE FUNCTION search_fold(value TEXT) RETURNS TEXT LANGUAGE sql IMMUTABLE AS $$
+    SELECT regexp_replace(
+        translate(lower(coalesce(value, '')), 'àáâãäåçèéêëìíîïñòóôõöùúûüýÿ', 'aaaaaaceeeeiiiinooooouuuuyy'),
+        '[^[:alnum:]]+', ' ', 'g')
+$$;
+ALTER TABLE customer ADD COLUMN search TSVECTOR GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', search_fold(name)), 'A') ||
+    setweight(to_tsvector('simple', search_fold(email)), 'B') ||
+    setweight(to_tsvector('simple', search_fold(role)), 'C') ||
+    setweight(to_tsvector('simple', search_fold(phone)), 'D')
+) STORED;
+CREATE INDEX customer_search ON customer USING GIN (search);
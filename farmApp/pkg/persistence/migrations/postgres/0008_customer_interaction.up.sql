@@ -0,0 +1,13 @@
+CREATE TABLE customer_interaction (
+    id SERIAL PRIMARY KEY,
+    customer_id INTEGER NOT NULL,
+    channel TEXT NOT NULL,
+    occurred_at TIMESTAMPTZ NOT NULL,
+    author TEXT NOT NULL,
+    notes TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX customer_interaction_customer_id ON customer_interaction (customer_id, occurred_at);
+-- Derived from the interactions, so that customers can be listed without
+-- counting them.
+ALTER TABLE customer ADD COLUMN contact_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE customer ADD COLUMN last_contacted_at TIMESTAMPTZ;
@@ -0,0 +1,28 @@
+CREATE TABLE product (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    category TEXT NOT NULL,
+    unit TEXT NOT NULL,
+    price_cents BIGINT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+-- ORDER is a keyword, hence the prefix.
+CREATE TABLE customer_order (
+    id SERIAL PRIMARY KEY,
+    customer_id INTEGER NOT NULL,
+    status TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX customer_order_customer_id ON customer_order (customer_id);
+-- Product name and price are copied, so that orders do not change with
+-- the catalog and survive deleted products.
+CREATE TABLE order_line (
+    order_id INTEGER NOT NULL,
+    line_no INTEGER NOT NULL,
+    product_id INTEGER NOT NULL,
+    product_name TEXT NOT NULL,
+    quantity INTEGER NOT NULL,
+    unit_price_cents BIGINT NOT NULL,
+    PRIMARY KEY (order_id, line_no)
+);
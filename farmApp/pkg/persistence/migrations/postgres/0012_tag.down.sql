@@ -0,0 +1,2 @@
+DROP TABLE customer_tag;
+DROP TABLE tag;
@@ -0,0 +1,3 @@
+-- locked_until ends the claim of a running request, so that a request that
+-- never stored its response does not block retries until the key expires.
+ALTER TABLE idempotency_key ADD COLUMN locked_until TIMESTAMPTZ;
@@ -0,0 +1,10 @@
+DROP INDEX customer_search;
+ALTER TABLE customer DROP COLUMN search;
+ALTER TABLE customer ADD COLUMN search TSVECTOR GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', search_fold(name)), 'A') ||
+    setweight(to_tsvector('simple', search_fold(email)), 'B') ||
+    setweight(to_tsvector('simple', search_fold(role)), 'C') ||
+    setweight(to_tsvector('simple', search_fold(phone)), 'D')
+) STORED;
+CREATE INDEX customer_search ON customer USING GIN (search);
+DROP FUNCTION search_words(TEXT);
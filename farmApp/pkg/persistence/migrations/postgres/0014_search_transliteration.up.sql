@@ -0,0 +1,17 @@
+-- search_words adds the transliterated spelling of words with umlauts to
+-- search_fold, so that "Mueller" finds "Müllerin" by prefix.
+CREATE FUNCTION search_words(value TEXT) RETURNS TEXT LANGUAGE sql IMMUTABLE AS $$
+    SELECT CASE WHEN transliterated = lower(coalesce(value, '')) THEN search_fold(value)
+        ELSE search_fold(value) || ' ' || search_fold(transliterated) END
+    FROM (SELECT replace(replace(replace(replace(lower(coalesce(value, '')),
+        'ä', 'ae'), 'ö', 'oe'), 'ü', 'ue'), 'ß', 'ss') AS transliterated) t
+$$;
+DROP INDEX customer_search;
+ALTER TABLE customer DROP COLUMN search;
+ALTER TABLE customer ADD COLUMN search TSVECTOR GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', search_words(name)), 'A') ||
+    setweight(to_tsvector('simple', search_words(email)), 'B') ||
+    setweight(to_tsvector('simple', search_words(role)), 'C') ||
+    setweight(to_tsvector('simple', search_words(phone)), 'D')
+) STORED;
+CREATE INDEX customer_search ON customer USING GIN (search);
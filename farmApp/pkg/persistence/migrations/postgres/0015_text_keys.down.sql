@@ -0,0 +1,7 @@
+ALTER TABLE customer_address DROP COLUMN city_key;
+ALTER TABLE customer_address DROP COLUMN postal_code_key;
+ALTER TABLE customer_address DROP COLUMN house_number_key;
+ALTER TABLE customer_address DROP COLUMN street_key;
+ALTER TABLE customer DROP COLUMN email_key;
+ALTER TABLE customer DROP COLUMN role_key;
+ALTER TABLE customer DROP COLUMN name_key;
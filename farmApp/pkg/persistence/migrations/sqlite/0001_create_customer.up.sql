@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS customer (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT,
+    role TEXT,
+    email TEXT,
+    phone TEXT,
+    contacted BOOLEAN
+);
@@ -0,0 +1,11 @@
+CREATE TABLE customer_audit (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    customer_id INTEGER NOT NULL,
+    actor TEXT NOT NULL,
+    occurred_at TIMESTAMP NOT NULL,
+    operation TEXT NOT NULL,
+    before_values TEXT,
+    after_values TEXT
+);
+CREATE INDEX customer_audit_customer_id ON customer_audit (customer_id);
+CREATE INDEX customer_audit_occurred_at ON customer_audit (occurred_at);
@@ -0,0 +1,2 @@
+DROP INDEX customer_created_at;
+ALTER TABLE customer DROP COLUMN created_at;
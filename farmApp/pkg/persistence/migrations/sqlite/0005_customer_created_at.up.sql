@@ -0,0 +1,9 @@
+ALTER TABLE customer ADD COLUMN created_at TIMESTAMP;
+-- Customers created since the audit log exists get their creation time from
+-- it, older ones the time of this migration. The format matches the one the
+-- Go driver writes, so values compare and round-trip consistently.
+UPDATE customer SET created_at = COALESCE(
+    (SELECT MIN(occurred_at) FROM customer_audit a WHERE a.customer_id = customer.id AND a.operation = 'create'),
+    strftime('%Y-%m-%d %H:%M:%S+00:00', 'now')
+);
+CREATE INDEX customer_created_at ON customer (created_at);
@@ -0,0 +1,4 @@
+DROP TRIGGER customer_fts_update;
+DROP TRIGGER customer_fts_delete;
+DROP TRIGGER customer_fts_insert;
+DROP TABLE customer_fts;
@@ -0,0 +1,3 @@
+ALTER TABLE customer DROP COLUMN last_contacted_at;
+ALTER TABLE customer DROP COLUMN contact_count;
+DROP TABLE customer_interaction;
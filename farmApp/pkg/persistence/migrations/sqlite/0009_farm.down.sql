@@ -0,0 +1,2 @@
+DROP TABLE customer_farm;
+DROP TABLE farm;
@@ -0,0 +1,16 @@
+CREATE TABLE farm (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    address TEXT NOT NULL DEFAULT '',
+    size_hectares REAL NOT NULL DEFAULT 0,
+    type TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+-- Links customers to the farms they work for, with their role there.
+CREATE TABLE customer_farm (
+    customer_id INTEGER NOT NULL,
+    farm_id INTEGER NOT NULL,
+    role TEXT NOT NULL,
+    PRIMARY KEY (customer_id, farm_id)
+);
+CREATE INDEX customer_farm_farm_id ON customer_farm (farm_id);
@@ -0,0 +1,28 @@
+CREATE TABLE product (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    category TEXT NOT NULL,
+    unit TEXT NOT NULL,
+    price_cents INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+-- ORDER is a keyword, hence the prefix.
+CREATE TABLE customer_order (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    customer_id INTEGER NOT NULL,
+    status TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+CREATE INDEX customer_order_customer_id ON customer_order (customer_id);
+-- Product name and price are copied, so that orders do not change with
+-- the catalog and survive deleted products.
+CREATE TABLE order_line (
+    order_id INTEGER NOT NULL,
+    line_no INTEGER NOT NULL,
+    product_id INTEGER NOT NULL,
+    product_name TEXT NOT NULL,
+    quantity INTEGER NOT NULL,
+    unit_price_cents INTEGER NOT NULL,
+    PRIMARY KEY (order_id, line_no)
+);
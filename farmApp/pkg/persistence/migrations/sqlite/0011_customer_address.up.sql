@@ -0,0 +1,12 @@
+CREATE TABLE customer_address (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    customer_id INTEGER NOT NULL,
+    type TEXT NOT NULL,
+    street TEXT NOT NULL,
+    house_number TEXT NOT NULL DEFAULT '',
+    postal_code TEXT NOT NULL,
+    city TEXT NOT NULL,
+    country TEXT NOT NULL DEFAULT 'DE'
+);
+CREATE INDEX customer_address_customer_id ON customer_address (customer_id);
+CREATE INDEX customer_address_postal_code ON customer_address (country, postal_code);
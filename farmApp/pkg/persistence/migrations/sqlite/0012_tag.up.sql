@@ -0,0 +1,13 @@
+-- name_key is the lower-cased name, so that names are unique ignoring case
+-- in both dialects.
+CREATE TABLE tag (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    name_key TEXT NOT NULL UNIQUE
+);
+CREATE TABLE customer_tag (
+    customer_id INTEGER NOT NULL,
+    tag_id INTEGER NOT NULL,
+    PRIMARY KEY (customer_id, tag_id)
+);
+CREATE INDEX customer_tag_tag_id ON customer_tag (tag_id);
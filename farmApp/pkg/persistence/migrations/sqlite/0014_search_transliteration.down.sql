@@ -0,0 +1,29 @@
+-- requires: fts5
+DROP TRIGGER customer_fts_update;
+DROP TRIGGER customer_fts_update_old;
+DROP TRIGGER customer_fts_delete;
+DROP TRIGGER customer_fts_insert;
+DROP TABLE customer_fts;
+DROP VIEW customer_search;
+
+CREATE VIRTUAL TABLE customer_fts USING fts5 (
+    name, role, email, phone,
+    content = 'customer', content_rowid = 'id',
+    tokenize = 'unicode61 remove_diacritics 2'
+);
+INSERT INTO customer_fts (customer_fts) VALUES ('rebuild');
+
+CREATE TRIGGER customer_fts_insert AFTER INSERT ON customer BEGIN
+    INSERT INTO customer_fts (rowid, name, role, email, phone)
+    VALUES (new.id, new.name, new.role, new.email, new.phone);
+END;
+CREATE TRIGGER customer_fts_delete AFTER DELETE ON customer BEGIN
+    INSERT INTO customer_fts (customer_fts, rowid, name, role, email, phone)
+    VALUES ('delete', old.id, old.name, old.role, old.email, old.phone);
+END;
+CREATE TRIGGER customer_fts_update AFTER UPDATE OF name, role, email, phone ON customer BEGIN
+    INSERT INTO customer_fts (customer_fts, rowid, name, role, email, phone)
+    VALUES ('delete', old.id, old.name, old.role, old.email, old.phone);
+    INSERT INTO customer_fts (rowid, name, role, email, phone)
+    VALUES (new.id, new.name, new.role, new.email, new.phone);
+END;
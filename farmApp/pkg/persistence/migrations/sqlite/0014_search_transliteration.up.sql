@@ -0,0 +1,47 @@
+-- requires: fts5
+-- The index holds every word with umlauts also in its transliterated
+-- spelling, so that "Mueller" finds "Müllerin" by prefix. customer_search
+-- supplies these values; the BEFORE triggers remove the old ones while the
+-- view still shows them.
+DROP TRIGGER customer_fts_update;
+DROP TRIGGER customer_fts_delete;
+DROP TRIGGER customer_fts_insert;
+DROP TABLE customer_fts;
+
+CREATE VIEW customer_search AS SELECT id,
+    CASE WHEN t_name = name THEN name ELSE name || ' ' || t_name END AS name,
+    CASE WHEN t_role = role THEN role ELSE role || ' ' || t_role END AS role,
+    CASE WHEN t_email = email THEN email ELSE email || ' ' || t_email END AS email,
+    CASE WHEN t_phone = phone THEN phone ELSE phone || ' ' || t_phone END AS phone
+FROM (
+    SELECT id, name, role, email, phone,
+        replace(replace(replace(replace(replace(replace(replace(name, 'ä', 'ae'), 'ö', 'oe'), 'ü', 'ue'), 'Ä', 'Ae'), 'Ö', 'Oe'), 'Ü', 'Ue'), 'ß', 'ss') AS t_name,
+        replace(replace(replace(replace(replace(replace(replace(role, 'ä', 'ae'), 'ö', 'oe'), 'ü', 'ue'), 'Ä', 'Ae'), 'Ö', 'Oe'), 'Ü', 'Ue'), 'ß', 'ss') AS t_role,
+        replace(replace(replace(replace(replace(replace(replace(email, 'ä', 'ae'), 'ö', 'oe'), 'ü', 'ue'), 'Ä', 'Ae'), 'Ö', 'Oe'), 'Ü', 'Ue'), 'ß', 'ss') AS t_email,
+        replace(replace(replace(replace(replace(replace(replace(phone, 'ä', 'ae'), 'ö', 'oe'), 'ü', 'ue'), 'Ä', 'Ae'), 'Ö', 'Oe'), 'Ü', 'Ue'), 'ß', 'ss') AS t_phone
+    FROM customer
+);
+
+CREATE VIRTUAL TABLE customer_fts USING fts5 (
+    name, role, email, phone,
+    content = 'customer_search', content_rowid = 'id',
+    tokenize = 'unicode61 remove_diacritics 2'
+);
+INSERT INTO customer_fts (customer_fts) VALUES ('rebuild');
+
+CREATE TRIGGER customer_fts_insert AFTER INSERT ON customer BEGIN
+    INSERT INTO customer_fts (rowid, name, role, email, phone)
+    SELECT id, name, role, email, phone FROM customer_search WHERE id = new.id;
+END;
+CREATE TRIGGER customer_fts_delete BEFORE DELETE ON customer BEGIN
+    INSERT INTO customer_fts (customer_fts, rowid, name, role, email, phone)
+    SELECT 'delete', id, name, role, email, phone FROM customer_search WHERE id = old.id;
+END;
+CREATE TRIGGER customer_fts_update_old BEFORE UPDATE OF name, role, email, phone ON customer BEGIN
+    INSERT INTO customer_fts (customer_fts, rowid, name, role, email, phone)
+    SELECT 'delete', id, name, role, email, phone FROM customer_search WHERE id = old.id;
+END;
+CREATE TRIGGER customer_fts_update AFTER UPDATE OF name, role, email, phone ON customer BEGIN
+    INSERT INTO customer_fts (rowid, name, role, email, phone)
+    SELECT id, name, role, email, phone FROM customer_search WHERE id = new.id;
+END;
@@ -0,0 +1,10 @@
+-- The *_key columns hold the lower-cased values the list filters compare,
+-- folded in Go since SQLite's LOWER only folds ASCII letters. Migrate fills
+-- them for existing rows.
+ALTER TABLE customer ADD COLUMN name_key TEXT;
+ALTER TABLE customer ADD COLUMN role_key TEXT;
+ALTER TABLE customer ADD COLUMN email_key TEXT;
+ALTER TABLE customer_address ADD COLUMN street_key TEXT;
+ALTER TABLE customer_address ADD COLUMN house_number_key TEXT;
+ALTER TABLE customer_address ADD COLUMN postal_code_key TEXT;
+ALTER TABLE customer_address ADD COLUMN city_key TEXT;
@@ -0,0 +1,473 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"farmApp/pkg/api"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrOrderNotFound is returned when an order does not exist.
+	ErrOrderNotFound = errors.New("order not found")
+	// ErrOrderLocked is returned when an order is changed in a way its
+	// status does not allow.
+	ErrOrderLocked = errors.New("order is locked")
+)
+
+// OrderFilter selects orders. Zero values match every order.
+type OrderFilter struct {
+	CustomerID int
+	Status     string
+}
+
+// OrderRepository stores the orders of customers. Orders stay with their
+// customer in the trash, move with it when it is merged into another one and
+// are removed when it is purged. While their customer is in the trash they
+// are left out of lists, and reading, updating or deleting them returns
+// ErrOrderNotFound.
+type OrderRepository interface {
+	// ListOrders returns the orders matching the filter, newest first.
+	ListOrders(ctx context.Context, filter OrderFilter) ([]api.Order, error)
+	GetOrder(ctx context.Context, id int) (api.Order, error)
+	// CreateOrder stores a new order, copying the name and price of each
+	// product into its line. An unknown customer or product is reported as
+	// an *api.ValidationError.
+	CreateOrder(ctx context.Context, order api.Order) (api.Order, error)
+	// UpdateOrder changes the status and lines of an order; its customer
+	// cannot change. The status must follow api.CanChangeStatus, and the
+	// lines can only change while the order is pending, otherwise
+	// ErrOrderLocked is returned. Lines ordering the same quantity of a
+	// product as before keep their name and price; new or changed lines are
+	// copied from the catalog.
+	UpdateOrder(ctx context.Context, id int, order api.Order) (api.Order, error)
+	// DeleteOrder removes a pending or cancelled order, or returns
+	// ErrOrderLocked.
+	DeleteOrder(ctx context.Context, id int) error
+}
+
+// checkStatusChange returns ErrOrderLocked unless current may become order,
+// or a *api.ValidationError if order belongs to another customer.
+func checkStatusChange(current, order api.Order) error {
+	if order.CustomerID != current.CustomerID {
+		return &api.ValidationError{Message: "order is invalid",
+			Errors: []api.FieldError{{Field: "customer_id", Message: "cannot be changed"}}}
+	}
+	if !api.CanChangeStatus(current.Status, order.Status) {
+		return fmt.Errorf("%w: a %s order cannot become %s", ErrOrderLocked, current.Status, order.Status)
+	}
+	if !sameLines(current.Lines, order.Lines) && current.Status != api.StatusPending {
+		return fmt.Errorf("%w: only pending orders can change their lines", ErrOrderLocked)
+	}
+	return nil
+}
+
+func checkDeletable(order api.Order) error {
+	if order.Status != api.StatusPending && order.Status != api.StatusCancelled {
+		return fmt.Errorf("%w: only pending or cancelled orders can be deleted", ErrOrderLocked)
+	}
+	return nil
+}
+
+// sameLines reports whether two lists order the same quantities of the same
+// products.
+func sameLines(a, b []api.OrderLine) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ProductID != b[i].ProductID || a[i].Quantity != b[i].Quantity {
+			return false
+		}
+	}
+	return true
+}
+
+// orderedAmount identifies the lines that order the same quantity of a product.
+type orderedAmount struct {
+	productID, quantity int
+}
+
+// priceLines copies the name and price of each product into its line. A
+// line ordering the same quantity of a product as a line of current keeps
+// that line's name and price instead.
+func priceLines(current, lines []api.OrderLine, product func(id int) (api.Product, error)) ([]api.OrderLine, error) {
+	unchanged := make(map[orderedAmount][]api.OrderLine)
+	for _, line := range current {
+		key := orderedAmount{line.ProductID, line.Quantity}
+		unchanged[key] = append(unchanged[key], line)
+	}
+
+	priced := make([]api.OrderLine, len(lines))
+	var errs []api.FieldError
+	for i, line := range lines {
+		key := orderedAmount{line.ProductID, line.Quantity}
+		if kept := unchanged[key]; len(kept) > 0 {
+			priced[i] = api.OrderLine{ProductID: line.ProductID, ProductName: kept[0].ProductName, Quantity: line.Quantity, UnitPriceCents: kept[0].UnitPriceCents}
+			unchanged[key] = kept[1:]
+			continue
+		}
+		p, err := product(line.ProductID)
+		if errors.Is(err, ErrProductNotFound) {
+			errs = append(errs, api.FieldError{Field: fmt.Sprintf("lines[%d].product_id", i), Message: "does not exist"})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		priced[i] = api.OrderLine{ProductID: line.ProductID, ProductName: p.Name, Quantity: line.Quantity, UnitPriceCents: p.PriceCents}
+	}
+	if len(errs) > 0 {
+		return nil, &api.ValidationError{Message: "order is invalid", Errors: errs}
+	}
+	return priced, nil
+}
+
+// errUnknownCustomer reports the customer of a new order that does not exist.
+var errUnknownCustomer = &api.ValidationError{Message: "order is invalid",
+	Errors: []api.FieldError{{Field: "customer_id", Message: "does not exist"}}}
+
+// addTotals computes the totals of the lines and the order.
+func addTotals(order *api.Order) {
+	order.TotalCents = 0
+	for i := range order.Lines {
+		line := &order.Lines[i]
+		line.TotalCents = int64(line.Quantity) * line.UnitPriceCents
+		order.TotalCents += line.TotalCents
+	}
+}
+
+const (
+	orderColumns     = "id, customer_id, status, created_at, updated_at"
+	orderLineColumns = "product_id, product_name, quantity, unit_price_cents"
+)
+
+// activeOrders joins the customer_order table, aliased as o, with the
+// customers that are not in the trash.
+const activeOrders = " customer_order o JOIN customer c ON c.id = o.customer_id AND c.deleted_at IS NULL"
+
+// queryOrders returns the orders selected by a WHERE clause on the
+// customer_order table, aliased as o, newest first. Orders of customers in
+// the trash are left out.
+func queryOrders(ctx context.Context, c conn, where string, args ...any) ([]api.Order, error) {
+	rows, err := c.query(ctx, "SELECT "+qualifiedColumns("o", orderColumns)+" FROM"+activeOrders+where+
+		" ORDER BY o.created_at DESC, o.id DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []api.Order{}
+	byID := map[int]*api.Order{}
+	for rows.Next() {
+		order := api.Order{Lines: []api.OrderLine{}}
+		if err := rows.Scan(&order.ID, &order.CustomerID, &order.Status, &order.CreatedAt, &order.UpdatedAt); err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range orders {
+		byID[*orders[i].ID] = &orders[i]
+	}
+
+	lines, err := c.query(ctx, "SELECT l.order_id, "+qualifiedColumns("l", orderLineColumns)+
+		" FROM"+activeOrders+" JOIN order_line l ON l.order_id = o.id"+where+" ORDER BY l.order_id, l.line_no", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer lines.Close()
+	for lines.Next() {
+		var orderID int
+		var line api.OrderLine
+		if err := lines.Scan(&orderID, &line.ProductID, &line.ProductName, &line.Quantity, &line.UnitPriceCents); err != nil {
+			return nil, err
+		}
+		if order := byID[orderID]; order != nil {
+			order.Lines = append(order.Lines, line)
+		}
+	}
+	for i := range orders {
+		addTotals(&orders[i])
+	}
+	return orders, lines.Err()
+}
+
+func getOrder(ctx context.Context, c conn, id int) (api.Order, error) {
+	orders, err := queryOrders(ctx, c, " WHERE o.id = ?", id)
+	if err != nil {
+		return api.Order{}, err
+	}
+	if len(orders) == 0 {
+		return api.Order{}, ErrOrderNotFound
+	}
+	return orders[0], nil
+}
+
+// storeLines replaces the lines of an order.
+func storeLines(ctx context.Context, c conn, orderID int, lines []api.OrderLine) error {
+	if _, err := c.exec(ctx, "DELETE FROM order_line WHERE order_id = ?", orderID); err != nil {
+		return err
+	}
+	for i, line := range lines {
+		_, err := c.exec(ctx, "INSERT INTO order_line (order_id, line_no, "+orderLineColumns+") VALUES (?, ?, ?, ?, ?, ?)",
+			orderID, i+1, line.ProductID, line.ProductName, line.Quantity, line.UnitPriceCents)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) ListOrders(ctx context.Context, filter OrderFilter) ([]api.Order, error) {
+	var conditions []string
+	var args []any
+	if filter.CustomerID != 0 {
+		conditions, args = append(conditions, "o.customer_id = ?"), append(args, filter.CustomerID)
+	}
+	if filter.Status != "" {
+		conditions, args = append(conditions, "o.status = ?"), append(args, filter.Status)
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+	return queryOrders(ctx, s.conn(), where, args...)
+}
+
+func (s *SQLStore) GetOrder(ctx context.Context, id int) (api.Order, error) {
+	return getOrder(ctx, s.conn(), id)
+}
+
+func (s *SQLStore) CreateOrder(ctx context.Context, order api.Order) (api.Order, error) {
+	var created api.Order
+	err := s.withTx(ctx, func(c conn) error {
+		_, err := checkVersion(ctx, c, order.CustomerID, AnyVersion)
+		if errors.Is(err, ErrNotFound) {
+			return errUnknownCustomer
+		}
+		if err != nil {
+			return err
+		}
+		lines, err := priceLines(nil, order.Lines, func(id int) (api.Product, error) { return getProduct(ctx, c, id) })
+		if err != nil {
+			return err
+		}
+
+		createdAt := now()
+		var id int
+		err = c.queryRow(ctx, "INSERT INTO customer_order (customer_id, status, created_at, updated_at) VALUES (?, ?, ?, ?) RETURNING id",
+			order.CustomerID, order.Status, createdAt, createdAt).Scan(&id)
+		if err != nil {
+			return err
+		}
+		if err := storeLines(ctx, c, id, lines); err != nil {
+			return err
+		}
+		created, err = getOrder(ctx, c, id)
+		return err
+	})
+	return created, err
+}
+
+func (s *SQLStore) UpdateOrder(ctx context.Context, id int, order api.Order) (api.Order, error) {
+	var updated api.Order
+	err := s.withTx(ctx, func(c conn) error {
+		current, err := getOrder(ctx, c, id)
+		if err != nil {
+			return err
+		}
+		if err := checkStatusChange(current, order); err != nil {
+			return err
+		}
+		if !sameLines(current.Lines, order.Lines) {
+			lines, err := priceLines(current.Lines, order.Lines, func(id int) (api.Product, error) { return getProduct(ctx, c, id) })
+			if err != nil {
+				return err
+			}
+			if err := storeLines(ctx, c, id, lines); err != nil {
+				return err
+			}
+		}
+		if _, err := c.exec(ctx, "UPDATE customer_order SET status = ?, updated_at = ? WHERE id = ?", order.Status, now(), id); err != nil {
+			return err
+		}
+		updated, err = getOrder(ctx, c, id)
+		return err
+	})
+	return updated, err
+}
+
+func (s *SQLStore) DeleteOrder(ctx context.Context, id int) error {
+	return s.withTx(ctx, func(c conn) error {
+		current, err := getOrder(ctx, c, id)
+		if err != nil {
+			return err
+		}
+		if err := checkDeletable(current); err != nil {
+			return err
+		}
+		return deleteOrders(ctx, c, " WHERE id = ?", id)
+	})
+}
+
+// deleteOrders removes the orders selected by a WHERE clause on the
+// customer_order table together with their lines.
+func deleteOrders(ctx context.Context, c conn, where string, args ...any) error {
+	if _, err := c.exec(ctx, "DELETE FROM order_line WHERE order_id IN (SELECT id FROM customer_order"+where+")", args...); err != nil {
+		return err
+	}
+	_, err := c.exec(ctx, "DELETE FROM customer_order"+where, args...)
+	return err
+}
+
+func (m *MemoryStore) ListOrders(ctx context.Context, filter OrderFilter) ([]api.Order, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	orders := []api.Order{}
+	for _, order := range m.orders {
+		if _, err := m.checkVersion(order.CustomerID, AnyVersion); err != nil {
+			continue
+		}
+		if (filter.CustomerID == 0 || order.CustomerID == filter.CustomerID) && (filter.Status == "" || order.Status == filter.Status) {
+			orders = append(orders, cloneOrder(order))
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		if !orders[i].CreatedAt.Equal(*orders[j].CreatedAt) {
+			return orders[i].CreatedAt.After(*orders[j].CreatedAt)
+		}
+		return *orders[i].ID > *orders[j].ID
+	})
+	return orders, nil
+}
+
+func (m *MemoryStore) GetOrder(ctx context.Context, id int) (api.Order, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	order, err := m.order(id)
+	if err != nil {
+		return api.Order{}, err
+	}
+	return cloneOrder(order), nil
+}
+
+func (m *MemoryStore) CreateOrder(ctx context.Context, order api.Order) (api.Order, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := m.checkVersion(order.CustomerID, AnyVersion)
+	if errors.Is(err, ErrNotFound) {
+		return api.Order{}, errUnknownCustomer
+	}
+	lines, err := priceLines(nil, order.Lines, m.product)
+	if err != nil {
+		return api.Order{}, err
+	}
+
+	m.lastOrderID++
+	id := m.lastOrderID
+	createdAt := now()
+	order = api.Order{ID: &id, CustomerID: order.CustomerID, Status: order.Status, Lines: lines, CreatedAt: &createdAt, UpdatedAt: &createdAt}
+	addTotals(&order)
+	m.orders[id] = order
+	return cloneOrder(order), nil
+}
+
+func (m *MemoryStore) UpdateOrder(ctx context.Context, id int, order api.Order) (api.Order, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, err := m.order(id)
+	if err != nil {
+		return api.Order{}, err
+	}
+	if err := checkStatusChange(current, order); err != nil {
+		return api.Order{}, err
+	}
+	if !sameLines(current.Lines, order.Lines) {
+		lines, err := priceLines(current.Lines, order.Lines, m.product)
+		if err != nil {
+			return api.Order{}, err
+		}
+		current.Lines = lines
+	}
+	updatedAt := now()
+	current.Status, current.UpdatedAt = order.Status, &updatedAt
+	addTotals(&current)
+	m.orders[id] = current
+	return cloneOrder(current), nil
+}
+
+func (m *MemoryStore) DeleteOrder(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	order, err := m.order(id)
+	if err != nil {
+		return err
+	}
+	if err := checkDeletable(order); err != nil {
+		return err
+	}
+	delete(m.orders, id)
+	return nil
+}
+
+// order looks up an order, returning ErrOrderNotFound if it does not exist
+// or its customer is in the trash. The caller must hold m.mu.
+func (m *MemoryStore) order(id int) (api.Order, error) {
+	order, ok := m.orders[id]
+	if !ok {
+		return api.Order{}, ErrOrderNotFound
+	}
+	if _, err := m.checkVersion(order.CustomerID, AnyVersion); err != nil {
+		return api.Order{}, ErrOrderNotFound
+	}
+	return order, nil
+}
+
+// product looks up a product for priceLines. The caller must hold m.mu.
+func (m *MemoryStore) product(id int) (api.Product, error) {
+	product, ok := m.products[id]
+	if !ok {
+		return api.Product{}, ErrProductNotFound
+	}
+	return product, nil
+}
+
+// moveOrders gives the orders of one customer to another. The caller must
+// hold m.mu.
+func (m *MemoryStore) moveOrders(fromID, toID int) {
+	for id, order := range m.orders {
+		if order.CustomerID == fromID {
+			order.CustomerID = toID
+			m.orders[id] = order
+		}
+	}
+}
+
+// deleteOrders forgets the orders of a purged customer. The caller must hold
+// m.mu.
+func (m *MemoryStore) deleteOrders(customerID int) {
+	for id, order := range m.orders {
+		if order.CustomerID == customerID {
+			delete(m.orders, id)
+		}
+	}
+}
+
+// cloneOrder copies the pointer fields and lines so callers cannot modify the stored order through them.
+func cloneOrder(order api.Order) api.Order {
+	id := *order.ID
+	createdAt, updatedAt := *order.CreatedAt, *order.UpdatedAt
+	order.ID, order.CreatedAt, order.UpdatedAt = &id, &createdAt, &updatedAt
+	order.Lines = append([]api.OrderLine{}, order.Lines...)
+	return order
+}
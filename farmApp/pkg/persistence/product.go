@@ -0,0 +1,171 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"farmApp/pkg/api"
+	"sort"
+)
+
+// ErrProductNotFound is returned when a product does not exist.
+var ErrProductNotFound = errors.New("product not found")
+
+// ProductRepository stores the catalog of products sold to customers.
+type ProductRepository interface {
+	// ListProducts returns the products ordered by name, only those of the
+	// given category unless it is empty.
+	ListProducts(ctx context.Context, category string) ([]api.Product, error)
+	GetProduct(ctx context.Context, id int) (api.Product, error)
+	CreateProduct(ctx context.Context, product api.Product) (api.Product, error)
+	UpdateProduct(ctx context.Context, id int, product api.Product) (api.Product, error)
+	// DeleteProduct removes a product from the catalog. Orders keep their
+	// copy of its name and price.
+	DeleteProduct(ctx context.Context, id int) error
+}
+
+const productColumns = "id, name, category, unit, price_cents, created_at"
+
+func scanProduct(row rowScanner) (api.Product, error) {
+	var product api.Product
+	err := row.Scan(&product.ID, &product.Name, &product.Category, &product.Unit, &product.PriceCents, &product.CreatedAt)
+	return product, err
+}
+
+func (s *SQLStore) ListProducts(ctx context.Context, category string) ([]api.Product, error) {
+	query, args := "SELECT "+productColumns+" FROM product", []any{}
+	if category != "" {
+		query, args = query+" WHERE category = ?", append(args, category)
+	}
+	rows, err := s.conn().query(ctx, query+" ORDER BY name, id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := []api.Product{}
+	for rows.Next() {
+		product, err := scanProduct(rows)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, product)
+	}
+	return products, rows.Err()
+}
+
+func (s *SQLStore) GetProduct(ctx context.Context, id int) (api.Product, error) {
+	return getProduct(ctx, s.conn(), id)
+}
+
+func getProduct(ctx context.Context, c conn, id int) (api.Product, error) {
+	product, err := scanProduct(c.queryRow(ctx, "SELECT "+productColumns+" FROM product WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return product, ErrProductNotFound
+	}
+	return product, err
+}
+
+func (s *SQLStore) CreateProduct(ctx context.Context, product api.Product) (api.Product, error) {
+	return scanProduct(s.conn().queryRow(ctx,
+		"INSERT INTO product (name, category, unit, price_cents, created_at) VALUES (?, ?, ?, ?, ?) RETURNING "+productColumns,
+		product.Name, product.Category, product.Unit, product.PriceCents, now()))
+}
+
+func (s *SQLStore) UpdateProduct(ctx context.Context, id int, product api.Product) (api.Product, error) {
+	updated, err := scanProduct(s.conn().queryRow(ctx,
+		"UPDATE product SET name = ?, category = ?, unit = ?, price_cents = ? WHERE id = ? RETURNING "+productColumns,
+		product.Name, product.Category, product.Unit, product.PriceCents, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return updated, ErrProductNotFound
+	}
+	return updated, err
+}
+
+func (s *SQLStore) DeleteProduct(ctx context.Context, id int) error {
+	result, err := s.conn().exec(ctx, "DELETE FROM product WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if err := requireAffected(result); err != nil {
+		return ErrProductNotFound
+	}
+	return nil
+}
+
+func (m *MemoryStore) ListProducts(ctx context.Context, category string) ([]api.Product, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	products := []api.Product{}
+	for _, product := range m.products {
+		if category == "" || product.Category == category {
+			products = append(products, cloneProduct(product))
+		}
+	}
+	sort.Slice(products, func(i, j int) bool {
+		if products[i].Name != products[j].Name {
+			return products[i].Name < products[j].Name
+		}
+		return *products[i].ID < *products[j].ID
+	})
+	return products, nil
+}
+
+func (m *MemoryStore) GetProduct(ctx context.Context, id int) (api.Product, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	product, ok := m.products[id]
+	if !ok {
+		return api.Product{}, ErrProductNotFound
+	}
+	return cloneProduct(product), nil
+}
+
+func (m *MemoryStore) CreateProduct(ctx context.Context, product api.Product) (api.Product, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastProductID++
+	id := m.lastProductID
+	createdAt := now()
+	product.ID, product.CreatedAt = &id, &createdAt
+	m.products[id] = product
+	return cloneProduct(product), nil
+}
+
+func (m *MemoryStore) UpdateProduct(ctx context.Context, id int, product api.Product) (api.Product, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.products[id]
+	if !ok {
+		return api.Product{}, ErrProductNotFound
+	}
+	product.ID, product.CreatedAt = current.ID, current.CreatedAt
+	m.products[id] = product
+	return cloneProduct(product), nil
+}
+
+func (m *MemoryStore) DeleteProduct(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.products[id]; !ok {
+		return ErrProductNotFound
+	}
+	delete(m.products, id)
+	return nil
+}
+
+// cloneProduct copies the pointer fields so callers cannot modify the stored product through them.
+func cloneProduct(product api.Product) api.Product {
+	id := *product.ID
+	product.ID = &id
+	if product.CreatedAt != nil {
+		createdAt := *product.CreatedAt
+		product.CreatedAt = &createdAt
+	}
+	return product
+}
@@ -0,0 +1,65 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"farmApp/pkg/api"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when a customer does not exist.
+	ErrNotFound = errors.New("customer not found")
+	// ErrVersionConflict is returned when a write expects a version the
+	// customer no longer has.
+	ErrVersionConflict = errors.New("customer was modified by another request")
+)
+
+// AnyVersion makes Update and Delete skip the version check.
+const AnyVersion = 0
+
+// CustomerRepository stores and retrieves farm customers.
+//
+// Every write is recorded in the audit log together with the actor from
+// the context (see WithActor) and increments the customer's version. Update and Delete only
+// succeed if the customer still has the expected version (or AnyVersion is
+// passed), so concurrent writers cannot silently overwrite each other.
+//
+// Delete moves a customer to the trash: it is hidden from List, Get and
+// Update until it is restored, and removed for good by Purge.
+type CustomerRepository interface {
+	List(ctx context.Context, opts ListOptions) (CustomerPage, error)
+	Get(ctx context.Context, id int) (api.Customer, error)
+	Create(ctx context.Context, customer api.Customer) (int, error)
+	Update(ctx context.Context, id, version int, customer api.Customer) (api.Customer, error)
+	// Patch reads the customer, passes it to change and stores the result in
+	// one atomic step. An error from change is returned as is and leaves
+	// the customer untouched.
+	Patch(ctx context.Context, id, version int, change func(api.Customer) (api.Customer, error)) (api.Customer, error)
+	Delete(ctx context.Context, id, version int) error
+
+	// ListTrash returns the deleted customers, most recently deleted first.
+	ListTrash(ctx context.Context) ([]api.Customer, error)
+	// Restore takes a customer out of the trash.
+	Restore(ctx context.Context, id int) error
+	// Purge permanently removes customers deleted before the given time
+	// and returns how many were removed.
+	Purge(ctx context.Context, deletedBefore time.Time) (int, error)
+}
+
+// Store provides all repositories and holds resources until it is closed.
+type Store interface {
+	CustomerRepository
+	BulkRepository
+	AuditRepository
+	SearchRepository
+	MergeRepository
+	InteractionRepository
+	AddressRepository
+	FarmRepository
+	ProductRepository
+	OrderRepository
+	TagRepository
+	IdempotencyRepository
+	Close() error
+}
@@ -0,0 +1,1682 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"farmApp/pkg/api"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// openSQLiteTestStore opens an empty, fully migrated SQLite store in a temporary directory
+func openSQLiteTestStore(t *testing.T) *SQLStore {
+	store, err := OpenDB(filepath.Join(t.TempDir(), "test.db"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestSQLiteCustomerRepository(t *testing.T) {
+	testCustomerRepository(t, openSQLiteTestStore(t))
+}
+
+func TestSQLiteOpenDSNWithParameters(t *testing.T) {
+	dsn := "file:" + filepath.Join(t.TempDir(), "test.db") + "?_busy_timeout=100&cache=shared"
+	store, err := OpenDB(dsn, Options{Seed: SeedDemo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var timeout, customers int
+	if err := store.db.QueryRow("PRAGMA busy_timeout").Scan(&timeout); err != nil {
+		t.Fatal(err)
+	}
+	if timeout != 100 {
+		t.Errorf("OpenDB ignored _busy_timeout from the DSN: got %v want 100", timeout)
+	}
+	store.Close()
+
+	store, err = OpenDB(dsn, Options{Reset: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM customer").Scan(&customers); err != nil {
+		t.Fatal(err)
+	}
+	if customers != 0 {
+		t.Errorf("OpenDB with Reset kept the file named by a file: DSN: got %v customers want 0", customers)
+	}
+}
+
+// testCustomerRepository checks the behaviour every CustomerRepository must share.
+// The repository must be empty.
+func testCustomerRepository(t *testing.T, repo CustomerRepository) {
+	ctx := context.Background()
+
+	if trash, err := repo.ListTrash(ctx); err != nil || trash == nil || len(trash) != 0 {
+		t.Errorf("ListTrash of an empty trash returned wrong result: got %#v, %v want an empty slice", trash, err)
+	}
+
+	klaus := api.Customer{Name: "Bauer Klaus", Role: "Farmer", Email: "klaus.bauer@farm.de", Phone: "01234 567890", Contacted: true}
+	anna := api.Customer{Name: "Bauerin Anna", Role: "Owner", Email: "anna.bauerin@farm.de", Phone: "01234 567891"}
+
+	klausID, err := repo.Create(ctx, klaus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	annaID, err := repo.Create(ctx, anna)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if klausID == annaID {
+		t.Fatalf("Create returned the same ID %v twice", klausID)
+	}
+
+	got, err := repo.Get(ctx, klausID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID == nil || *got.ID != klausID {
+		t.Errorf("Get returned wrong ID: got %v want %v", got.ID, klausID)
+	}
+	if got.Name != klaus.Name || got.Email != klaus.Email || got.Contacted != klaus.Contacted {
+		t.Errorf("Get returned wrong customer: got %+v want %+v", got, klaus)
+	}
+
+	page, err := repo.List(ctx, ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Customers) != 2 || page.Total != 2 || page.NextCursor != "" {
+		t.Errorf("List returned wrong page: got %d customers of %d with cursor %q want 2 of 2 without cursor",
+			len(page.Customers), page.Total, page.NextCursor)
+	}
+
+	if got.Version != 1 {
+		t.Errorf("new customer has wrong version: got %v want %v", got.Version, 1)
+	}
+
+	anna.Email = "anna@farm.de"
+	updated, err := repo.Update(ctx, annaID, 1, anna)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Email != anna.Email || updated.Version != 2 {
+		t.Errorf("Update returned wrong customer: got %+v want email %v and version %v", updated, anna.Email, 2)
+	}
+	got, err = repo.Get(ctx, annaID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Email != anna.Email {
+		t.Errorf("Update did not change the email: got %v want %v", got.Email, anna.Email)
+	}
+
+	// Writes that expect an old version are rejected
+	if _, err := repo.Update(ctx, annaID, 1, anna); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Update with a stale version returned wrong error: got %v want %v", err, ErrVersionConflict)
+	}
+	if err := repo.Delete(ctx, annaID, 1); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Delete with a stale version returned wrong error: got %v want %v", err, ErrVersionConflict)
+	}
+	if _, err := repo.Update(ctx, annaID, AnyVersion, anna); err != nil {
+		t.Errorf("Update with AnyVersion failed: %v", err)
+	}
+
+	if _, err := repo.Update(ctx, 1000, AnyVersion, anna); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Update of a missing customer returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+
+	if err := repo.Delete(ctx, klausID, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Get(ctx, klausID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a deleted customer returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+	if err := repo.Delete(ctx, klausID, AnyVersion); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete of a missing customer returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+
+	// Deleted customers wait in the trash until they are restored or purged
+	trash, err := repo.ListTrash(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 1 || *trash[0].ID != klausID || trash[0].DeletedAt == nil {
+		t.Errorf("ListTrash returned wrong customers: got %+v want only %v", trash, klausID)
+	}
+	if _, err := repo.Update(ctx, klausID, AnyVersion, klaus); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Update of a deleted customer returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+	if err := repo.Restore(ctx, klausID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Get(ctx, klausID); err != nil {
+		t.Errorf("Get of a restored customer failed: %v", err)
+	}
+	if err := repo.Restore(ctx, klausID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Restore of a customer outside the trash returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+
+	if err := repo.Delete(ctx, klausID, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	purged, err := repo.Purge(ctx, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 0 {
+		t.Errorf("Purge removed customers deleted after the cutoff: got %v want %v", purged, 0)
+	}
+	purged, err = repo.Purge(ctx, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Errorf("Purge removed wrong number of customers: got %v want %v", purged, 1)
+	}
+	if err := repo.Restore(ctx, klausID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Restore of a purged customer returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+
+	// IDs of deleted customers are never handed out again
+	if err := repo.Delete(ctx, annaID, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Purge(ctx, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	newID, err := repo.Create(ctx, klaus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newID <= annaID {
+		t.Errorf("Create reused an ID: got %v want more than %v", newID, annaID)
+	}
+}
+
+func TestMemoryCustomerRepository(t *testing.T) {
+	testCustomerRepository(t, NewMemoryStore())
+}
+
+// Tests that concurrent creates on the in-memory store get distinct IDs
+func TestMemoryStoreConcurrentCreate(t *testing.T) {
+	store := NewMemoryStore()
+	ids := make([]int, 50)
+
+	var wg sync.WaitGroup
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ids[i], _ = store.Create(context.Background(), api.Customer{Name: "Customer"})
+		}(i)
+	}
+	wg.Wait()
+
+	seen := map[int]bool{}
+	for _, id := range ids {
+		if seen[id] {
+			t.Errorf("ID %v was assigned twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+// testAuditRepository checks that every mutation is recorded with its actor.
+// The repository must be empty.
+func testAuditRepository(t *testing.T, store Store) {
+	ctx := WithActor(context.Background(), "anna")
+
+	id, err := store.Create(ctx, api.Customer{Name: "Bauer Klaus", Email: "klaus@farm.de"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Update(ctx, id, AnyVersion, api.Customer{Name: "Bauer Klaus", Email: "klaus.bauer@farm.de"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(context.Background(), id, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := store.History(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantOperations := []string{OpDelete, OpUpdate, OpCreate}
+	if len(history) != len(wantOperations) {
+		t.Fatalf("History returned wrong number of entries: got %v want %v", len(history), len(wantOperations))
+	}
+	for i, op := range wantOperations {
+		if history[i].Operation != op {
+			t.Errorf("History entry %d has wrong operation: got %v want %v", i, history[i].Operation, op)
+		}
+	}
+
+	update := history[1]
+	if update.Actor != "anna" {
+		t.Errorf("update has wrong actor: got %v want %v", update.Actor, "anna")
+	}
+	if len(update.Before) != 1 || update.Before["email"] != "klaus@farm.de" || update.After["email"] != "klaus.bauer@farm.de" {
+		t.Errorf("update has wrong values: got %v -> %v want only the email change", update.Before, update.After)
+	}
+	if history[0].Actor != SystemActor {
+		t.Errorf("delete without actor has wrong actor: got %v want %v", history[0].Actor, SystemActor)
+	}
+
+	entries, err := store.ListAudit(ctx, AuditFilter{Actor: "anna", Operation: OpCreate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].CustomerID != id {
+		t.Errorf("ListAudit returned wrong entries: got %+v want the creation of %v", entries, id)
+	}
+	entries, err = store.ListAudit(ctx, AuditFilter{Since: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("ListAudit returned entries from the future: got %+v", entries)
+	}
+
+	if _, err := store.History(ctx, 1000); !errors.Is(err, ErrNotFound) {
+		t.Errorf("History of a missing customer returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+}
+
+func TestSQLiteAuditRepository(t *testing.T) {
+	testAuditRepository(t, openSQLiteTestStore(t))
+}
+
+func TestMemoryAuditRepository(t *testing.T) {
+	testAuditRepository(t, NewMemoryStore())
+}
+
+// testPagination checks that paging through the customers visits each one
+// once, in ID order. The repository must be empty.
+func testPagination(t *testing.T, repo CustomerRepository) {
+	ctx := context.Background()
+	for i := 0; i < 7; i++ {
+		if _, err := repo.Create(ctx, api.Customer{Name: fmt.Sprintf("Customer %d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ids []int
+	opts := ListOptions{Limit: 3}
+	for pages := 1; ; pages++ {
+		page, err := repo.List(ctx, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Total != 7 {
+			t.Errorf("page %d has wrong total: got %v want %v", pages, page.Total, 7)
+		}
+		for _, customer := range page.Customers {
+			ids = append(ids, *customer.ID)
+		}
+		if page.NextCursor == "" {
+			if pages != 3 {
+				t.Errorf("wrong number of pages: got %v want %v", pages, 3)
+			}
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+
+	if len(ids) != 7 || !sort.IntsAreSorted(ids) {
+		t.Errorf("pages returned wrong customers: got %v want 7 IDs in ascending order", ids)
+	}
+
+	if _, err := repo.List(ctx, ListOptions{Cursor: "not a cursor"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("List with a bad cursor returned wrong error: got %v want %v", err, ErrInvalidCursor)
+	}
+}
+
+func TestSQLitePagination(t *testing.T) {
+	testPagination(t, openSQLiteTestStore(t))
+}
+
+func TestMemoryPagination(t *testing.T) {
+	testPagination(t, NewMemoryStore())
+}
+
+// Tests filtering, and paging through a multi-key sort order.
+func testFilterAndSort(t *testing.T, repo CustomerRepository) {
+	ctx := context.Background()
+	customers := []api.Customer{
+		{Name: "Anna Schmidt", Role: "Farmer", Email: "anna@hof.de", Contacted: true},
+		{Name: "Bernd Schmidt", Role: "Farmer", Email: "bernd@hof.de"},
+		{Name: "Carla Meyer", Role: "Dealer", Email: "carla@agrar.de"},
+		{Name: "Dieter Maier", Role: "farmer", Email: "dieter@agrar.de"},
+		{Name: "Emil 100%_Bio", Role: "Dealer", Email: "emil@bio.de", Contacted: true},
+		{Name: "Özlem Müller", Role: "Öko-Landwirtin", Email: "Özlem@müller.de", Contacted: true},
+	}
+	for _, customer := range customers {
+		if _, err := repo.Create(ctx, customer); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names := func(opts ListOptions) []string {
+		t.Helper()
+		var names []string
+		for {
+			page, err := repo.List(ctx, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, customer := range page.Customers {
+				names = append(names, customer.Name)
+			}
+			if page.NextCursor == "" {
+				return names
+			}
+			opts.Cursor = page.NextCursor
+		}
+	}
+
+	contacted := false
+	hour := time.Hour
+	tests := []struct {
+		name string
+		opts ListOptions
+		want []string
+	}{
+		{"role ignores case", ListOptions{Filter: CustomerFilter{Role: "FARMER"}},
+			[]string{"Anna Schmidt", "Bernd Schmidt", "Dieter Maier"}},
+		{"contacted", ListOptions{Filter: CustomerFilter{Contacted: &contacted}},
+			[]string{"Bernd Schmidt", "Carla Meyer", "Dieter Maier"}},
+		{"name substring", ListOptions{Filter: CustomerFilter{Name: "schmidt"}},
+			[]string{"Anna Schmidt", "Bernd Schmidt"}},
+		{"name wildcards are literal", ListOptions{Filter: CustomerFilter{Name: "%_"}},
+			[]string{"Emil 100%_Bio"}},
+		{"email substring", ListOptions{Filter: CustomerFilter{Email: "@AGRAR"}},
+			[]string{"Carla Meyer", "Dieter Maier"}},
+		{"name ignores case of umlauts", ListOptions{Filter: CustomerFilter{Name: "özlem MÜLLER"}}, []string{"Özlem Müller"}},
+		{"role ignores case of umlauts", ListOptions{Filter: CustomerFilter{Role: "öko-landwirtin"}}, []string{"Özlem Müller"}},
+		{"email ignores case of umlauts", ListOptions{Filter: CustomerFilter{Email: "özlem@"}}, []string{"Özlem Müller"}},
+		{"created before", ListOptions{Filter: CustomerFilter{CreatedBefore: time.Now().Add(-hour)}}, nil},
+		{"created range", ListOptions{Filter: CustomerFilter{
+			Role: "dealer", CreatedAfter: time.Now().Add(-hour), CreatedBefore: time.Now().Add(hour)}},
+			[]string{"Carla Meyer", "Emil 100%_Bio"}},
+		{"multi-key sort", ListOptions{Limit: 2, Sort: []SortKey{{Field: "contacted"}, {Field: "name", Descending: true}}},
+			[]string{"Dieter Maier", "Carla Meyer", "Bernd Schmidt", "Özlem Müller", "Emil 100%_Bio", "Anna Schmidt"}},
+		{"sort with filter", ListOptions{Limit: 1, Filter: CustomerFilter{Role: "farmer"}, Sort: []SortKey{{Field: "email", Descending: true}}},
+			[]string{"Dieter Maier", "Bernd Schmidt", "Anna Schmidt"}},
+		{"created_at sort", ListOptions{Limit: 1, Filter: CustomerFilter{Contacted: &contacted}, Sort: []SortKey{{Field: "created_at"}}},
+			[]string{"Bernd Schmidt", "Carla Meyer", "Dieter Maier"}},
+	}
+	for _, test := range tests {
+		if got := names(test.opts); fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s: got %v want %v", test.name, got, test.want)
+		}
+	}
+
+	page, err := repo.List(ctx, ListOptions{Limit: 2, Sort: []SortKey{{Field: "name"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.List(ctx, ListOptions{Limit: 2, Cursor: page.NextCursor})
+	if !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("List with a cursor from another sort order returned wrong error: got %v want %v", err, ErrInvalidCursor)
+	}
+}
+
+func TestSQLiteFilterAndSort(t *testing.T) {
+	testFilterAndSort(t, openSQLiteTestStore(t))
+}
+
+func TestMemoryFilterAndSort(t *testing.T) {
+	testFilterAndSort(t, NewMemoryStore())
+}
+
+func TestParseSort(t *testing.T) {
+	keys, err := ParseSort("role, -created_at")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SortKey{{Field: "role"}, {Field: "created_at", Descending: true}}
+	if fmt.Sprint(keys) != fmt.Sprint(want) {
+		t.Errorf("ParseSort returned wrong keys: got %v want %v", keys, want)
+	}
+	if _, err := ParseSort("deleted_at"); err == nil {
+		t.Errorf("ParseSort accepted an unknown field")
+	}
+}
+
+// Tests that Patch changes the current customer and leaves it untouched when the change fails.
+func testPatch(t *testing.T, repo CustomerRepository) {
+	ctx := context.Background()
+	id, err := repo.Create(ctx, api.Customer{Name: "Bauer Klaus", Role: "Farmer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patched, err := repo.Patch(ctx, id, 1, func(current api.Customer) (api.Customer, error) {
+		current.Contacted = true
+		return current, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched.Name != "Bauer Klaus" || patched.Role != "Farmer" || !patched.Contacted || patched.Version != 2 {
+		t.Errorf("Patch returned wrong customer: got %+v", patched)
+	}
+
+	errChange := errors.New("change failed")
+	_, err = repo.Patch(ctx, id, AnyVersion, func(current api.Customer) (api.Customer, error) {
+		return api.Customer{}, errChange
+	})
+	if !errors.Is(err, errChange) {
+		t.Errorf("Patch returned wrong error: got %v want %v", err, errChange)
+	}
+	got, err := repo.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != 2 || got.Name != "Bauer Klaus" {
+		t.Errorf("failed Patch changed the customer: got %+v", got)
+	}
+
+	called := false
+	_, err = repo.Patch(ctx, id, 1, func(current api.Customer) (api.Customer, error) {
+		called = true
+		return current, nil
+	})
+	if !errors.Is(err, ErrVersionConflict) || called {
+		t.Errorf("Patch of an old version returned wrong error: got %v want %v", err, ErrVersionConflict)
+	}
+	if _, err := repo.Patch(ctx, id+100, AnyVersion, nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Patch of a missing customer returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+}
+
+func TestSQLitePatch(t *testing.T) {
+	testPatch(t, openSQLiteTestStore(t))
+}
+
+func TestMemoryPatch(t *testing.T) {
+	testPatch(t, NewMemoryStore())
+}
+
+// Tests that batches report every item and roll back according to their mode.
+func testBulk(t *testing.T, store Store) {
+	ctx := context.Background()
+	created, err := store.CreateMany(ctx, []api.Customer{{Name: "Bauer Klaus"}, {Name: "Bauerin Anna"}}, AllOrNothing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != 2 || created[0].Err != nil || created[1].Err != nil || created[1].Customer.Version != 1 {
+		t.Fatalf("CreateMany returned wrong results: got %+v", created)
+	}
+	klaus, anna := *created[0].Customer.ID, *created[1].Customer.ID
+
+	countCustomers := func() int {
+		t.Helper()
+		page, err := store.List(ctx, ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return page.Total
+	}
+	countAudit := func() int {
+		t.Helper()
+		entries, err := store.ListAudit(ctx, AuditFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(entries)
+	}
+	audited := countAudit()
+
+	updates := []BulkUpdate{
+		{ID: klaus, Version: 1, Customer: api.Customer{Name: "Bauer Klaus", Contacted: true}},
+		{ID: anna, Version: 7, Customer: api.Customer{Name: "Bauerin Anna", Contacted: true}},
+	}
+	results, err := store.UpdateMany(ctx, updates, AllOrNothing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(results[0].Err, ErrBatchAborted) || !errors.Is(results[1].Err, ErrVersionConflict) {
+		t.Errorf("UpdateMany returned wrong errors: got %v, %v want %v, %v", results[0].Err, results[1].Err, ErrBatchAborted, ErrVersionConflict)
+	}
+	if got, err := store.Get(ctx, klaus); err != nil || got.Contacted || got.Version != 1 {
+		t.Errorf("aborted batch changed the customer: got %+v, %v", got, err)
+	}
+	if got := countAudit(); got != audited {
+		t.Errorf("aborted batch wrote audit entries: got %v want %v", got, audited)
+	}
+
+	results, err = store.UpdateMany(ctx, updates, BestEffort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Err != nil || !results[0].Customer.Contacted || results[0].Customer.Version != 2 ||
+		!errors.Is(results[1].Err, ErrVersionConflict) {
+		t.Errorf("UpdateMany returned wrong results: got %+v", results)
+	}
+
+	results, err = store.CreateMany(ctx, []api.Customer{{Name: "Müller Hans"}, {Name: "Schmidt Peter"}}, BestEffort)
+	if err != nil || results[0].Err != nil || results[1].Err != nil {
+		t.Fatalf("CreateMany failed: got %+v, %v", results, err)
+	}
+	results, err = store.DeleteMany(ctx, []BulkDelete{{ID: klaus, Version: 2}, {ID: anna + 100, Version: AnyVersion}}, AllOrNothing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(results[0].Err, ErrBatchAborted) || !errors.Is(results[1].Err, ErrNotFound) {
+		t.Errorf("DeleteMany returned wrong errors: got %v, %v want %v, %v", results[0].Err, results[1].Err, ErrBatchAborted, ErrNotFound)
+	}
+	if got := countCustomers(); got != 4 {
+		t.Errorf("aborted batch deleted customers: got %v want %v", got, 4)
+	}
+
+	results, err = store.DeleteMany(ctx, []BulkDelete{{ID: klaus, Version: 2}, {ID: anna, Version: 1}}, AllOrNothing)
+	if err != nil || results[0].Err != nil || results[1].Err != nil {
+		t.Fatalf("DeleteMany failed: got %+v, %v", results, err)
+	}
+	if got := countCustomers(); got != 2 {
+		t.Errorf("DeleteMany left wrong number of customers: got %v want %v", got, 2)
+	}
+}
+
+func TestSQLiteBulk(t *testing.T) {
+	testBulk(t, openSQLiteTestStore(t))
+}
+
+func TestMemoryBulk(t *testing.T) {
+	testBulk(t, NewMemoryStore())
+}
+
+// testSearch checks full-text search including German spellings. The store must be empty.
+func testSearch(t *testing.T, store Store) {
+	ctx := context.Background()
+	var ids []int
+	for _, customer := range []api.Customer{
+		{Name: "Hans Müller", Role: "Landwirt", Email: "hans@hof.de"},
+		{Name: "Erika Mueller", Role: "Tierärztin", Email: "erika@praxis.de"},
+		{Name: "Anna Schmidt", Role: "Müllerin", Email: "anna@muehle.de"},
+		{Name: "Peter Weiß", Role: "Fahrer", Email: "peter@example.com", Phone: "0171 2345678"},
+		{Name: "Ben & Bauer", Role: "Händler", Email: "ben@example.com"},
+		{Name: "Johann Baur", Role: "Straßenwärter", Email: "johann@example.com"},
+	} {
+		id, err := store.Create(ctx, customer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	search := func(query string) []api.SearchResult {
+		t.Helper()
+		results, err := store.Search(ctx, query, 10)
+		if err != nil {
+			t.Fatalf("Search(%q) failed: %v", query, err)
+		}
+		return results
+	}
+	resultIDs := func(results []api.SearchResult) []int {
+		found := []int{}
+		for _, result := range results {
+			found = append(found, *result.Customer.ID)
+		}
+		return found
+	}
+
+	for _, query := range []string{"Müller", "mueller", "MÜLLER"} {
+		found := resultIDs(search(query))
+		sort.Ints(found[:min(2, len(found))])
+		if fmt.Sprint(found) != fmt.Sprint([]int{ids[0], ids[1], ids[2]}) {
+			t.Errorf("Search(%q) found wrong customers: got %v want %v", query, found, ids[:3])
+		}
+	}
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{"hans müller", []int{ids[0]}},
+		{"tierarzt", []int{ids[1]}},
+		{"weiss", []int{ids[3]}},
+		{"0171", []int{ids[3]}},
+		{"praxis", []int{ids[1]}},
+		{"hans schmidt", []int{}},
+		{"joe", []int{}},
+		{"strassenwaerter", []int{ids[5]}},
+		{"straßenw", []int{ids[5]}},
+		{"?!", []int{}},
+	}
+	for _, tt := range tests {
+		if found := resultIDs(search(tt.query)); fmt.Sprint(found) != fmt.Sprint(tt.want) {
+			t.Errorf("Search(%q) found wrong customers: got %v want %v", tt.query, found, tt.want)
+		}
+	}
+
+	results := search("muller hans")
+	if len(results) != 1 || results[0].Highlights["name"] != "<mark>Hans</mark> <mark>Müller</mark>" {
+		t.Errorf("Search returned wrong highlights: got %+v", results)
+	}
+	if _, ok := results[0].Highlights["role"]; ok {
+		t.Errorf("Search highlighted a field without matches: got %v", results[0].Highlights)
+	}
+	results = search("bauer")
+	if len(results) != 1 || results[0].Highlights["name"] != "Ben &amp; <mark>Bauer</mark>" {
+		t.Errorf("Search did not escape highlights: got %+v", results)
+	}
+
+	if _, err := store.Update(ctx, ids[2], AnyVersion, api.Customer{Name: "Anna Schmitt", Role: "Bäckerin", Email: "anna@muehle.de"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, ids[3], AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if found := resultIDs(search("schmitt backer")); fmt.Sprint(found) != fmt.Sprint([]int{ids[2]}) {
+		t.Errorf("Search did not find the updated customer: got %v want %v", found, ids[2:3])
+	}
+	if found := resultIDs(search("müllerin")); len(found) != 0 {
+		t.Errorf("Search found the old values of an updated customer: got %v", found)
+	}
+	if found := resultIDs(search("weiß")); len(found) != 0 {
+		t.Errorf("Search found a deleted customer: got %v", found)
+	}
+}
+
+func TestSQLiteSearch(t *testing.T) {
+	testSearch(t, openSQLiteTestStore(t))
+}
+
+func TestMemorySearch(t *testing.T) {
+	testSearch(t, NewMemoryStore())
+}
+
+// Tests that the words of a query expand to the spellings of umlauts
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"Müller", "[mueller]"},
+		{"Mueller", "[mueller]"},
+		{"Weiß", "[weiss]"},
+		{"Joe", "[joe]"},
+		{"José  Ruiz-Pérez", "[jose ruiz perez]"},
+		{" - ", "[]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(parseSearchQuery(tt.query)); got != tt.want {
+			t.Errorf("parseSearchQuery(%q) = %v want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+// testMerge checks the duplicate report and merging. The store must be empty.
+func testMerge(t *testing.T, store Store) {
+	ctx := context.Background()
+	var ids []int
+	for _, customer := range []api.Customer{
+		{Name: "Bauer Klaus", Role: "Farmer", Email: "klaus.bauer@farm.de", Phone: "01234 567890"},
+		{Name: "Bauerin Anna", Role: "Owner", Email: "anna.bauerin@farm.de"},
+		{Name: "Klaus Bauer", Email: "KLAUS.BAUER@farm.de ", Phone: "+49 1234 567890", Contacted: true},
+		{Name: "Meier Lisa", Email: "lisa@hof.de"},
+		{Name: "Meyer Lisa", Email: "lisa.meyer@farm.de"},
+	} {
+		id, err := store.Create(ctx, customer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	pairs, err := store.Duplicates(ctx, 0.3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, pair := range pairs {
+		got = append(got, fmt.Sprintf("%d-%d %.2f %v", *pair.Customers[0].ID, *pair.Customers[1].ID, pair.Score, pair.Reasons))
+	}
+	want := []string{
+		fmt.Sprintf("%d-%d 0.98 [same email same phone same name]", ids[0], ids[2]),
+		fmt.Sprintf("%d-%d 0.50 [similar name]", ids[3], ids[4]),
+		fmt.Sprintf("%d-%d 0.30 [both names contain \"bauer\"]", ids[0], ids[1]),
+		fmt.Sprintf("%d-%d 0.30 [both names contain \"bauer\"]", ids[1], ids[2]),
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Duplicates returned wrong pairs:\ngot  %v\nwant %v", got, want)
+	}
+	if pairs, err := store.Duplicates(ctx, 0.9); err != nil || len(pairs) != 1 {
+		t.Errorf("Duplicates did not apply the minimum score: got %v pairs, %v", len(pairs), err)
+	}
+
+	combine := func(target, source api.Customer) (api.Customer, error) {
+		target.Contacted = target.Contacted || source.Contacted
+		return target, nil
+	}
+	if _, err := store.Merge(ctx, ids[0], AnyVersion, ids[0], AnyVersion, combine); !errors.Is(err, ErrSelfMerge) {
+		t.Errorf("Merge with itself returned wrong error: got %v want %v", err, ErrSelfMerge)
+	}
+	if _, err := store.Merge(ctx, ids[0], 1, ids[2], 2, combine); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Merge with a stale source returned wrong error: got %v want %v", err, ErrVersionConflict)
+	}
+	merged, err := store.Merge(ctx, ids[0], 1, ids[2], 1, combine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.Name != "Bauer Klaus" || !merged.Contacted || merged.Version != 2 {
+		t.Errorf("Merge returned wrong customer: got %+v", merged)
+	}
+	if _, err := store.Get(ctx, ids[2]); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Merge did not move the source to the trash: got %v want %v", err, ErrNotFound)
+	}
+	if _, err := store.Merge(ctx, ids[0], AnyVersion, ids[2], AnyVersion, combine); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Merge of a trashed customer returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+
+	history, err := store.History(ctx, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history[0].Operation != OpMerge || fmt.Sprint(history[0].After) != fmt.Sprintf("map[contacted:true merged_from:%d]", ids[2]) {
+		t.Errorf("Merge recorded wrong history for the target: got %+v", history[0])
+	}
+	history, err = store.History(ctx, ids[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history[0].Operation != OpMerge || fmt.Sprint(history[0].After) != fmt.Sprintf("map[merged_into:%d]", ids[0]) {
+		t.Errorf("Merge recorded wrong history for the source: got %+v", history[0])
+	}
+
+	errCombine := errors.New("combine failed")
+	if _, err := store.Merge(ctx, ids[3], AnyVersion, ids[4], AnyVersion, func(target, source api.Customer) (api.Customer, error) {
+		return api.Customer{}, errCombine
+	}); !errors.Is(err, errCombine) {
+		t.Errorf("Merge returned wrong error: got %v want %v", err, errCombine)
+	}
+	if _, err := store.Get(ctx, ids[4]); err != nil {
+		t.Errorf("failed Merge moved the source to the trash: %v", err)
+	}
+}
+
+func TestSQLiteMerge(t *testing.T) {
+	testMerge(t, openSQLiteTestStore(t))
+}
+
+func TestMemoryMerge(t *testing.T) {
+	testMerge(t, NewMemoryStore())
+}
+
+func TestDuplicateCandidates(t *testing.T) {
+	var keys []duplicateKeys
+	for _, customer := range []api.Customer{
+		{Name: "Bauer Klaus", Email: "klaus@farm.de"},
+		{Name: "Meier Lisa", Phone: "0171 5551234"},
+		{Name: "Schulz Otto", Email: "KLAUS@farm.de"},
+		{Name: "Bauerin Anna Anna", Phone: "+49 171 5551234"},
+		{Name: "Weber Jens"},
+	} {
+		keys = append(keys, newDuplicateKeys(customer))
+	}
+
+	got := duplicateCandidates(keys)
+	want := [][2]int{{0, 2}, {0, 3}, {1, 3}}
+	if !slices.Equal(got, want) {
+		t.Errorf("duplicateCandidates returned wrong pairs: got %v want %v", got, want)
+	}
+}
+
+func testIdempotency(t *testing.T, store Store) {
+	ctx := WithActor(context.Background(), "app")
+	if _, reserved, err := store.ReserveKey(ctx, "key-1", "POST /customers abc", time.Hour, time.Minute); err != nil || !reserved {
+		t.Fatalf("ReserveKey did not reserve a new key: got %v, %v", reserved, err)
+	}
+	stored, reserved, err := store.ReserveKey(ctx, "key-1", "POST /customers abc", time.Hour, time.Minute)
+	if err != nil || reserved || stored.Status != 0 || stored.Fingerprint != "POST /customers abc" {
+		t.Errorf("ReserveKey of a running request returned wrong result: got %+v, %v, %v", stored, reserved, err)
+	}
+	if _, reserved, err := store.ReserveKey(WithActor(ctx, "other"), "key-1", "POST /customers def", time.Hour, time.Minute); err != nil || !reserved {
+		t.Errorf("ReserveKey did not scope the key to the actor: got %v, %v", reserved, err)
+	}
+
+	response := StoredResponse{Status: 201, Header: map[string]string{"ETag": `"1"`}, Body: []byte(`{"id":1}`)}
+	if err := store.CompleteKey(ctx, "key-1", response); err != nil {
+		t.Fatal(err)
+	}
+	stored, reserved, err = store.ReserveKey(ctx, "key-1", "POST /customers def", time.Hour, time.Minute)
+	response.Fingerprint = "POST /customers abc"
+	if err != nil || reserved || fmt.Sprint(stored) != fmt.Sprint(response) {
+		t.Errorf("ReserveKey of a completed request returned wrong result: got %+v, %v, %v want %+v", stored, reserved, err, response)
+	}
+	if err := store.CompleteKey(ctx, "key-2", response); !errors.Is(err, ErrNotFound) {
+		t.Errorf("CompleteKey of an unknown key returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+
+	if err := store.ReleaseKey(ctx, "key-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, reserved, err := store.ReserveKey(ctx, "key-1", "POST /customers def", -time.Second, time.Minute); err != nil || !reserved {
+		t.Errorf("ReserveKey did not reserve a released key: got %v, %v", reserved, err)
+	}
+	if _, reserved, err := store.ReserveKey(ctx, "key-1", "POST /customers ghi", time.Hour, -time.Second); err != nil || !reserved {
+		t.Errorf("ReserveKey did not reserve an expired key: got %v, %v", reserved, err)
+	}
+	stored, reserved, err = store.ReserveKey(ctx, "key-1", "POST /customers jkl", time.Hour, time.Minute)
+	if err != nil || !reserved {
+		t.Errorf("ReserveKey did not take over a key whose lease passed: got %+v, %v, %v", stored, reserved, err)
+	}
+	if _, reserved, err := store.ReserveKey(ctx, "key-1", "POST /customers jkl", time.Hour, time.Minute); err != nil || reserved {
+		t.Errorf("ReserveKey took over a key whose lease is held: got %v, %v", reserved, err)
+	}
+
+	if _, reserved, err := store.ReserveKey(ctx, "key-3", "POST /customers abc", time.Hour, -time.Second); err != nil || !reserved {
+		t.Fatalf("ReserveKey did not reserve a new key: got %v, %v", reserved, err)
+	}
+	if err := store.CompleteKey(ctx, "key-3", response); err != nil {
+		t.Fatal(err)
+	}
+	if stored, reserved, err := store.ReserveKey(ctx, "key-3", "POST /customers abc", time.Hour, time.Minute); err != nil || reserved || stored.Status != 201 {
+		t.Errorf("ReserveKey took over a completed key whose lease passed: got %+v, %v, %v", stored, reserved, err)
+	}
+}
+
+func TestSQLiteIdempotency(t *testing.T) {
+	testIdempotency(t, openSQLiteTestStore(t))
+}
+
+func TestMemoryIdempotency(t *testing.T) {
+	testIdempotency(t, NewMemoryStore())
+}
+
+// testInteractions checks recording interactions and the contact fields
+// derived from them.
+func testInteractions(t *testing.T, store Store) {
+	ctx := context.Background()
+	id, err := store.Create(ctx, api.Customer{Name: "Bauer Klaus", Contacted: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherID, err := store.Create(ctx, api.Customer{Name: "Meier Lisa"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	call, err := store.AddInteraction(ctx, otherID, api.Interaction{Channel: api.ChannelCall, OccurredAt: first, Author: "anna"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	visit, err := store.AddInteraction(ctx, otherID, api.Interaction{Channel: api.ChannelVisit,
+		OccurredAt: first.Add(48 * time.Hour).In(time.FixedZone("CET", 3600)), Author: "anna", Notes: "Looked at the barn"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if visit.CustomerID != otherID || visit.ID == call.ID || !visit.OccurredAt.Equal(first.Add(48*time.Hour)) {
+		t.Errorf("AddInteraction returned wrong interaction: got %+v", visit)
+	}
+	if _, err := store.AddInteraction(ctx, 999, api.Interaction{Channel: api.ChannelCall, Author: "anna"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("AddInteraction for a missing customer returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+
+	customer, err := store.Get(ctx, otherID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !customer.Contacted || customer.ContactCount != 2 || customer.LastContactedAt == nil ||
+		!customer.LastContactedAt.Equal(visit.OccurredAt) || customer.Version != 3 {
+		t.Errorf("AddInteraction did not update the contact fields: got %+v", customer)
+	}
+	history, err := store.History(ctx, otherID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 3 || history[0].Operation != OpUpdate || history[0].After["contact_count"] == nil {
+		t.Errorf("AddInteraction did not record the change in the history: got %+v", history)
+	}
+
+	interactions, err := store.ListInteractions(ctx, otherID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(interactions) != 2 || interactions[0].ID != visit.ID || interactions[1].ID != call.ID || interactions[0].Notes != "Looked at the barn" {
+		t.Errorf("ListInteractions returned wrong interactions: got %+v", interactions)
+	}
+	if interactions, err := store.ListInteractions(ctx, id); err != nil || len(interactions) != 0 {
+		t.Errorf("ListInteractions of a customer without interactions returned %v, %v", interactions, err)
+	}
+	if got, err := store.GetInteraction(ctx, otherID, call.ID); err != nil || got.Channel != api.ChannelCall || !got.OccurredAt.Equal(first) {
+		t.Errorf("GetInteraction returned wrong interaction: got %+v, %v", got, err)
+	}
+	if _, err := store.GetInteraction(ctx, id, call.ID); !errors.Is(err, ErrInteractionNotFound) {
+		t.Errorf("GetInteraction of another customer's interaction returned wrong error: got %v want %v", err, ErrInteractionNotFound)
+	}
+
+	customer.Contacted = false
+	if customer, err = store.Update(ctx, otherID, customer.Version, customer); err != nil {
+		t.Fatal(err)
+	}
+	if !customer.Contacted || customer.ContactCount != 2 {
+		t.Errorf("Update cleared the contact fields of a customer with interactions: got %+v", customer)
+	}
+
+	if err := store.DeleteInteraction(ctx, otherID, visit.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteInteraction(ctx, otherID, visit.ID); !errors.Is(err, ErrInteractionNotFound) {
+		t.Errorf("DeleteInteraction of a deleted interaction returned wrong error: got %v want %v", err, ErrInteractionNotFound)
+	}
+	if customer, err = store.Get(ctx, otherID); err != nil {
+		t.Fatal(err)
+	}
+	if customer.ContactCount != 1 || !customer.LastContactedAt.Equal(first) {
+		t.Errorf("DeleteInteraction did not update the contact fields: got %+v", customer)
+	}
+
+	merged, err := store.Merge(ctx, id, AnyVersion, otherID, AnyVersion, func(target, source api.Customer) (api.Customer, error) {
+		return target, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.ContactCount != 1 || merged.LastContactedAt == nil || !merged.LastContactedAt.Equal(first) {
+		t.Errorf("Merge did not move the interactions: got %+v", merged)
+	}
+	if got, err := store.GetInteraction(ctx, id, call.ID); err != nil || got.CustomerID != id {
+		t.Errorf("Merge did not move the interaction: got %+v, %v", got, err)
+	}
+	if _, err := store.ListInteractions(ctx, otherID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ListInteractions of a trashed customer returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+
+	if err := store.Delete(ctx, id, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Purge(ctx, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	id, err = store.Create(ctx, api.Customer{Name: "Bauer Klaus"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if customer, err := store.Get(ctx, id); err != nil || customer.Contacted || customer.ContactCount != 0 || customer.LastContactedAt != nil {
+		t.Errorf("Create returned contact fields: got %+v, %v", customer, err)
+	}
+}
+
+func TestSQLiteInteractions(t *testing.T) {
+	testInteractions(t, openSQLiteTestStore(t))
+}
+
+func TestMemoryInteractions(t *testing.T) {
+	testInteractions(t, NewMemoryStore())
+}
+
+// testFarms checks farms, their links to customers and the cascade rules of
+// deleting a farm.
+func testFarms(t *testing.T, store Store) {
+	ctx := context.Background()
+	var ids []int
+	for _, name := range []string{"Bauer Klaus", "Bauerin Anna", "Meier Lisa"} {
+		id, err := store.Create(ctx, api.Customer{Name: name, Email: "info@hof.de"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	lindenhof, err := store.CreateFarm(ctx, api.Farm{Name: "Lindenhof", Address: "Dorfstraße 1, 12345 Linden", SizeHectares: 120.5, Type: api.FarmTypeDairy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	birkenhof, err := store.CreateFarm(ctx, api.Farm{Name: "Birkenhof", Type: api.FarmTypeArable})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lindenhof.ID == nil || lindenhof.CreatedAt == nil || lindenhof.SizeHectares != 120.5 {
+		t.Errorf("CreateFarm returned wrong farm: got %+v", lindenhof)
+	}
+	farms, err := store.ListFarms(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(farms) != 2 || farms[0].Name != "Birkenhof" || farms[1].Name != "Lindenhof" {
+		t.Errorf("ListFarms returned wrong farms: got %+v", farms)
+	}
+
+	lindenhof.SizeHectares = 130
+	updated, err := store.UpdateFarm(ctx, *lindenhof.ID, lindenhof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.GetFarm(ctx, *lindenhof.ID); err != nil || got.SizeHectares != 130 || !got.CreatedAt.Equal(*updated.CreatedAt) {
+		t.Errorf("UpdateFarm stored wrong farm: got %+v, %v", got, err)
+	}
+	if _, err := store.UpdateFarm(ctx, 999, lindenhof); !errors.Is(err, ErrFarmNotFound) {
+		t.Errorf("UpdateFarm of a missing farm returned wrong error: got %v want %v", err, ErrFarmNotFound)
+	}
+
+	for _, link := range []struct {
+		farm     api.Farm
+		customer int
+		role     string
+	}{
+		{lindenhof, ids[0], "Owner"},
+		{lindenhof, ids[1], "Manager"},
+		{lindenhof, ids[1], "Owner"},
+		{birkenhof, ids[1], "Worker"},
+		{birkenhof, ids[2], "Owner"},
+	} {
+		contact, err := store.LinkFarm(ctx, *link.farm.ID, link.customer, link.role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contact.Role != link.role || *contact.Customer.ID != link.customer {
+			t.Errorf("LinkFarm returned wrong contact: got %+v", contact)
+		}
+	}
+	if _, err := store.LinkFarm(ctx, 999, ids[0], "Owner"); !errors.Is(err, ErrFarmNotFound) {
+		t.Errorf("LinkFarm to a missing farm returned wrong error: got %v want %v", err, ErrFarmNotFound)
+	}
+	if _, err := store.LinkFarm(ctx, *lindenhof.ID, 999, "Owner"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("LinkFarm of a missing customer returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+
+	contacts, err := store.ListFarmContacts(ctx, *lindenhof.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contacts) != 2 || contacts[0].Customer.Name != "Bauer Klaus" || contacts[1].Role != "Owner" {
+		t.Errorf("ListFarmContacts returned wrong contacts: got %+v", contacts)
+	}
+	customerFarms, err := store.ListCustomerFarms(ctx, ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(customerFarms) != 2 || customerFarms[0].Farm.Name != "Birkenhof" || customerFarms[0].Role != "Worker" ||
+		customerFarms[1].Farm.SizeHectares != 130 {
+		t.Errorf("ListCustomerFarms returned wrong farms: got %+v", customerFarms)
+	}
+
+	if err := store.UnlinkFarm(ctx, *birkenhof.ID, ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UnlinkFarm(ctx, *birkenhof.ID, ids[1]); !errors.Is(err, ErrFarmContactNotFound) {
+		t.Errorf("UnlinkFarm of an unlinked customer returned wrong error: got %v want %v", err, ErrFarmContactNotFound)
+	}
+
+	// Merging Klaus into Anna keeps Anna's role on the Lindenhof.
+	if _, err := store.Merge(ctx, ids[1], AnyVersion, ids[0], AnyVersion, func(target, source api.Customer) (api.Customer, error) {
+		return target, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if contacts, err := store.ListFarmContacts(ctx, *lindenhof.ID); err != nil || len(contacts) != 1 ||
+		*contacts[0].Customer.ID != ids[1] || contacts[0].Role != "Owner" {
+		t.Errorf("Merge did not move the farm links: got %+v, %v", contacts, err)
+	}
+
+	if err := store.DeleteFarm(ctx, *lindenhof.ID, CascadeRestrict); !errors.Is(err, ErrFarmHasContacts) {
+		t.Errorf("DeleteFarm of a farm with contacts returned wrong error: got %v want %v", err, ErrFarmHasContacts)
+	}
+	if _, err := store.LinkFarm(ctx, *birkenhof.ID, ids[1], "Worker"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteFarm(ctx, *lindenhof.ID, CascadeTrash); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, ids[1]); err != nil {
+		t.Errorf("DeleteFarm moved a contact with another farm to the trash: %v", err)
+	}
+	if _, err := store.GetFarm(ctx, *lindenhof.ID); !errors.Is(err, ErrFarmNotFound) {
+		t.Errorf("GetFarm of a deleted farm returned wrong error: got %v want %v", err, ErrFarmNotFound)
+	}
+
+	if err := store.DeleteFarm(ctx, *birkenhof.ID, CascadeTrash); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids[1:] {
+		if _, err := store.Get(ctx, id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("DeleteFarm did not move its last contact %d to the trash: got %v want %v", id, err, ErrNotFound)
+		}
+	}
+	history, err := store.History(ctx, ids[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history[0].Operation != OpDelete {
+		t.Errorf("DeleteFarm recorded wrong history: got %+v", history[0])
+	}
+
+	if err := store.Restore(ctx, ids[2]); err != nil {
+		t.Fatal(err)
+	}
+	if farms, err := store.ListCustomerFarms(ctx, ids[2]); err != nil || len(farms) != 0 {
+		t.Errorf("ListCustomerFarms after deleting the farm returned %+v, %v", farms, err)
+	}
+	farm, err := store.CreateFarm(ctx, api.Farm{Name: "Eichenhof", Type: api.FarmTypeMixed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.LinkFarm(ctx, *farm.ID, ids[2], "Owner"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteFarm(ctx, *farm.ID, CascadeUnlink); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, ids[2]); err != nil {
+		t.Errorf("DeleteFarm with CascadeUnlink moved a contact to the trash: %v", err)
+	}
+}
+
+func TestSQLiteFarms(t *testing.T) {
+	testFarms(t, openSQLiteTestStore(t))
+}
+
+func TestMemoryFarms(t *testing.T) {
+	testFarms(t, NewMemoryStore())
+}
+
+// testOrders checks the product catalog and the orders of customers.
+func testOrders(t *testing.T, store Store) {
+	ctx := context.Background()
+	customerID, err := store.Create(ctx, api.Customer{Name: "Bauer Klaus", Email: "klaus@hof.de"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed, err := store.CreateProduct(ctx, api.Product{Name: "Winterweizen", Category: api.CategorySeed, Unit: "25 kg bag", PriceCents: 3450})
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed, err := store.CreateProduct(ctx, api.Product{Name: "Milchleistungsfutter", Category: api.CategoryFeed, Unit: "t", PriceCents: 41000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if products, err := store.ListProducts(ctx, api.CategorySeed); err != nil || len(products) != 1 || *products[0].ID != *seed.ID {
+		t.Errorf("ListProducts did not filter by category: got %+v, %v", products, err)
+	}
+	if products, err := store.ListProducts(ctx, ""); err != nil || len(products) != 2 || products[0].Name != "Milchleistungsfutter" {
+		t.Errorf("ListProducts returned wrong products: got %+v, %v", products, err)
+	}
+
+	order, err := store.CreateOrder(ctx, api.Order{CustomerID: customerID, Status: api.StatusPending,
+		Lines: []api.OrderLine{{ProductID: *seed.ID, Quantity: 4, UnitPriceCents: 1}, {ProductID: *feed.ID, Quantity: 2}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.TotalCents != 4*3450+2*41000 || order.Lines[0].ProductName != "Winterweizen" || order.Lines[0].UnitPriceCents != 3450 ||
+		order.Lines[1].TotalCents != 82000 || order.CreatedAt == nil {
+		t.Errorf("CreateOrder returned wrong order: got %+v", order)
+	}
+	var invalid *api.ValidationError
+	if _, err := store.CreateOrder(ctx, api.Order{CustomerID: 999, Status: api.StatusPending,
+		Lines: []api.OrderLine{{ProductID: *seed.ID, Quantity: 1}}}); !errors.As(err, &invalid) || invalid.Errors[0].Field != "customer_id" {
+		t.Errorf("CreateOrder for a missing customer returned wrong error: got %v", err)
+	}
+	if _, err := store.CreateOrder(ctx, api.Order{CustomerID: customerID, Status: api.StatusPending,
+		Lines: []api.OrderLine{{ProductID: *seed.ID, Quantity: 1}, {ProductID: 999, Quantity: 1}}}); !errors.As(err, &invalid) ||
+		invalid.Errors[0].Field != "lines[1].product_id" {
+		t.Errorf("CreateOrder of a missing product returned wrong error: got %v", err)
+	}
+
+	// Prices change in the catalog, but not in the stored order.
+	seed.PriceCents = 3900
+	if _, err := store.UpdateProduct(ctx, *seed.ID, seed); err != nil {
+		t.Fatal(err)
+	}
+	order.Status = api.StatusConfirmed
+	confirmed, err := store.UpdateOrder(ctx, *order.ID, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if confirmed.Status != api.StatusConfirmed || confirmed.Lines[0].UnitPriceCents != 3450 || confirmed.TotalCents != order.TotalCents {
+		t.Errorf("UpdateOrder returned wrong order: got %+v", confirmed)
+	}
+	confirmed.Lines = confirmed.Lines[:1]
+	if _, err := store.UpdateOrder(ctx, *order.ID, confirmed); !errors.Is(err, ErrOrderLocked) {
+		t.Errorf("UpdateOrder of the lines of a confirmed order returned wrong error: got %v want %v", err, ErrOrderLocked)
+	}
+	order.Status = api.StatusPending
+	if _, err := store.UpdateOrder(ctx, *order.ID, order); !errors.Is(err, ErrOrderLocked) {
+		t.Errorf("UpdateOrder back to pending returned wrong error: got %v want %v", err, ErrOrderLocked)
+	}
+	if err := store.DeleteOrder(ctx, *order.ID); !errors.Is(err, ErrOrderLocked) {
+		t.Errorf("DeleteOrder of a confirmed order returned wrong error: got %v want %v", err, ErrOrderLocked)
+	}
+	if _, err := store.UpdateOrder(ctx, 999, order); !errors.Is(err, ErrOrderNotFound) {
+		t.Errorf("UpdateOrder of a missing order returned wrong error: got %v want %v", err, ErrOrderNotFound)
+	}
+
+	if err := store.DeleteProduct(ctx, *feed.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.GetOrder(ctx, *order.ID); err != nil || got.Lines[1].ProductName != "Milchleistungsfutter" {
+		t.Errorf("DeleteProduct changed the orders: got %+v, %v", got, err)
+	}
+
+	barley, err := store.CreateProduct(ctx, api.Product{Name: "Sommergerste", Category: api.CategorySeed, Unit: "25 kg bag", PriceCents: 2800})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err := store.CreateOrder(ctx, api.Order{CustomerID: customerID, Status: api.StatusPending,
+		Lines: []api.OrderLine{{ProductID: *seed.ID, Quantity: 1}, {ProductID: *barley.ID, Quantity: 2}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the changed line takes the new catalog price.
+	seed.PriceCents, barley.PriceCents = 4100, 3100
+	if _, err := store.UpdateProduct(ctx, *seed.ID, seed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.UpdateProduct(ctx, *barley.ID, barley); err != nil {
+		t.Fatal(err)
+	}
+	pending.Lines[0].Quantity = 3
+	if pending, err = store.UpdateOrder(ctx, *pending.ID, pending); err != nil {
+		t.Fatal(err)
+	}
+	if pending.Lines[0].UnitPriceCents != 4100 || pending.Lines[1].UnitPriceCents != 2800 || pending.TotalCents != 3*4100+2*2800 {
+		t.Errorf("UpdateOrder did not reprice only the changed lines: got %+v", pending)
+	}
+	pending.CustomerID = 999
+	if _, err := store.UpdateOrder(ctx, *pending.ID, pending); !errors.As(err, &invalid) {
+		t.Errorf("UpdateOrder to another customer returned wrong error: got %v", err)
+	}
+
+	orders, err := store.ListOrders(ctx, OrderFilter{CustomerID: customerID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 2 || *orders[0].ID != *pending.ID || len(orders[1].Lines) != 2 {
+		t.Errorf("ListOrders returned wrong orders: got %+v", orders)
+	}
+	if orders, err := store.ListOrders(ctx, OrderFilter{Status: api.StatusConfirmed}); err != nil || len(orders) != 1 {
+		t.Errorf("ListOrders did not filter by status: got %+v, %v", orders, err)
+	}
+	if err := store.DeleteOrder(ctx, *pending.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetOrder(ctx, *pending.ID); !errors.Is(err, ErrOrderNotFound) {
+		t.Errorf("GetOrder of a deleted order returned wrong error: got %v want %v", err, ErrOrderNotFound)
+	}
+
+	targetID, err := store.Create(ctx, api.Customer{Name: "Klaus Bauer", Email: "klaus@hof.de"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Merge(ctx, targetID, AnyVersion, customerID, AnyVersion, func(target, source api.Customer) (api.Customer, error) {
+		return target, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	orders, err = store.ListOrders(ctx, OrderFilter{CustomerID: targetID})
+	if err != nil || len(orders) != 1 {
+		t.Fatalf("Merge did not move the orders: got %+v, %v", orders, err)
+	}
+
+	if err := store.Delete(ctx, targetID, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if orders, err := store.ListOrders(ctx, OrderFilter{}); err != nil || len(orders) != 0 {
+		t.Errorf("ListOrders returned the orders of a customer in the trash: got %+v, %v", orders, err)
+	}
+	trashed := orders[0]
+	if _, err := store.GetOrder(ctx, *trashed.ID); !errors.Is(err, ErrOrderNotFound) {
+		t.Errorf("GetOrder of a customer in the trash returned wrong error: got %v want %v", err, ErrOrderNotFound)
+	}
+	trashed.Status = api.StatusShipped
+	if _, err := store.UpdateOrder(ctx, *trashed.ID, trashed); !errors.Is(err, ErrOrderNotFound) {
+		t.Errorf("UpdateOrder of a customer in the trash returned wrong error: got %v want %v", err, ErrOrderNotFound)
+	}
+	if err := store.Restore(ctx, targetID); err != nil {
+		t.Fatal(err)
+	}
+	if orders, err := store.ListOrders(ctx, OrderFilter{}); err != nil || len(orders) != 1 {
+		t.Errorf("Restore did not bring back the orders: got %+v, %v", orders, err)
+	}
+
+	if err := store.Delete(ctx, targetID, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Purge(ctx, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if orders, err := store.ListOrders(ctx, OrderFilter{}); err != nil || len(orders) != 0 {
+		t.Errorf("Purge did not remove the orders: got %+v, %v", orders, err)
+	}
+}
+
+func TestSQLiteOrders(t *testing.T) {
+	testOrders(t, openSQLiteTestStore(t))
+}
+
+func TestMemoryOrders(t *testing.T) {
+	testOrders(t, NewMemoryStore())
+}
+
+// testAddresses checks the addresses of customers and filtering customers by
+// them.
+func testAddresses(t *testing.T, store Store) {
+	ctx := context.Background()
+	id, err := store.Create(ctx, api.Customer{Name: "Bauer Klaus"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherID, err := store.Create(ctx, api.Customer{Name: "Meier Lisa"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	yard, err := store.AddAddress(ctx, id, api.Address{Type: api.AddressFarmYard, Street: "Ölmühlweg", HouseNumber: "3",
+		PostalCode: "84028", City: "Landshut", Country: "DE"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	billing, err := store.AddAddress(ctx, id, api.Address{Type: api.AddressBilling, Street: "Hauptstraße", HouseNumber: "12a",
+		PostalCode: "80331", City: "München", Country: "DE"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if billing.CustomerID != id || billing.ID == yard.ID || billing.HouseNumber != "12a" {
+		t.Errorf("AddAddress returned wrong address: got %+v", billing)
+	}
+	delivery, err := store.AddAddress(ctx, otherID, api.Address{Type: api.AddressDelivery, Street: "Dorfstraße",
+		PostalCode: "6020", City: "Innsbruck", Country: "AT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddAddress(ctx, 999, api.Address{Type: api.AddressBilling}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("AddAddress for a missing customer returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+	if customer, err := store.Get(ctx, id); err != nil || customer.Version != 1 {
+		t.Errorf("AddAddress changed the customer's version: got %+v, %v", customer, err)
+	}
+
+	addresses, err := store.ListAddresses(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addresses) != 2 || addresses[0].ID != billing.ID || addresses[1].ID != yard.ID {
+		t.Errorf("ListAddresses returned wrong addresses: got %+v", addresses)
+	}
+	if got, err := store.GetAddress(ctx, otherID, delivery.ID); err != nil || got != delivery {
+		t.Errorf("GetAddress returned wrong address: got %+v, %v", got, err)
+	}
+	if _, err := store.GetAddress(ctx, otherID, yard.ID); !errors.Is(err, ErrAddressNotFound) {
+		t.Errorf("GetAddress of another customer's address returned wrong error: got %v want %v", err, ErrAddressNotFound)
+	}
+
+	yard.Street = "Ölmühlweg"
+	yard.HouseNumber = "5"
+	if yard, err = store.UpdateAddress(ctx, id, yard.ID, yard); err != nil || yard.HouseNumber != "5" || yard.CustomerID != id {
+		t.Errorf("UpdateAddress returned wrong address: got %+v, %v", yard, err)
+	}
+	if _, err := store.UpdateAddress(ctx, otherID, yard.ID, yard); !errors.Is(err, ErrAddressNotFound) {
+		t.Errorf("UpdateAddress of another customer's address returned wrong error: got %v want %v", err, ErrAddressNotFound)
+	}
+
+	for _, test := range []struct {
+		name   string
+		filter CustomerFilter
+		want   []int
+	}{
+		{"street with umlaut", CustomerFilter{Address: "straße"}, []int{id, otherID}},
+		{"city substring", CustomerFilter{Address: "lands"}, []int{id}},
+		{"postal code prefix", CustomerFilter{PostalCode: "80"}, []int{id}},
+		{"postal code is not a substring", CustomerFilter{PostalCode: "33"}, nil},
+		{"city", CustomerFilter{City: "münchen"}, []int{id}},
+		{"city ignores case of umlauts", CustomerFilter{City: "MÜNCHEN"}, []int{id}},
+		{"street ignores case of umlauts", CustomerFilter{Address: "ölmühl"}, []int{id}},
+		{"country", CustomerFilter{Country: "at"}, []int{otherID}},
+		{"type", CustomerFilter{AddressType: api.AddressDelivery}, []int{otherID}},
+		{"same address", CustomerFilter{AddressType: api.AddressBilling, City: "Landshut"}, nil},
+		{"with customer field", CustomerFilter{Name: "meier", Country: "AT"}, []int{otherID}},
+		{"wildcard", CustomerFilter{Address: "%"}, nil},
+	} {
+		page, err := store.List(ctx, ListOptions{Filter: test.filter})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int
+		for _, customer := range page.Customers {
+			got = append(got, *customer.ID)
+		}
+		if !slices.Equal(got, test.want) || page.Total != len(test.want) {
+			t.Errorf("List filtered by %s returned wrong customers: got %v (total %d) want %v", test.name, got, page.Total, test.want)
+		}
+	}
+
+	if err := store.DeleteAddress(ctx, id, billing.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteAddress(ctx, id, billing.ID); !errors.Is(err, ErrAddressNotFound) {
+		t.Errorf("DeleteAddress of a deleted address returned wrong error: got %v want %v", err, ErrAddressNotFound)
+	}
+
+	if _, err := store.Merge(ctx, id, AnyVersion, otherID, AnyVersion, func(target, source api.Customer) (api.Customer, error) {
+		return target, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if addresses, err := store.ListAddresses(ctx, id); err != nil || len(addresses) != 2 || addresses[0].ID != delivery.ID {
+		t.Errorf("Merge did not move the addresses: got %+v, %v", addresses, err)
+	}
+	if _, err := store.ListAddresses(ctx, otherID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ListAddresses of a trashed customer returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+
+	if err := store.Delete(ctx, id, AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Purge(ctx, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	newID, err := store.Create(ctx, api.Customer{Name: "Bauer Klaus"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page, err := store.List(ctx, ListOptions{Filter: CustomerFilter{Country: "DE"}}); err != nil || len(page.Customers) != 0 {
+		t.Errorf("Purge did not remove the addresses of customer %d: got %+v, %v", newID, page.Customers, err)
+	}
+}
+
+func TestSQLiteAddresses(t *testing.T) {
+	testAddresses(t, openSQLiteTestStore(t))
+}
+
+func TestMemoryAddresses(t *testing.T) {
+	testAddresses(t, NewMemoryStore())
+}
+
+// testTags checks tags, their assignment to customers, renaming and merging
+// them, and filtering customers by them.
+func testTags(t *testing.T, store Store) {
+	ctx := context.Background()
+	var ids []int
+	for _, name := range []string{"Bauer Klaus", "Meier Lisa", "Schmidt Hans"} {
+		id, err := store.Create(ctx, api.Customer{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	organic, err := store.CreateTag(ctx, " organic ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if organic.Name != "organic" || organic.CustomerCount != 0 {
+		t.Errorf("CreateTag returned wrong tag: got %+v", organic)
+	}
+	if _, err := store.CreateTag(ctx, "Organic"); !errors.Is(err, ErrTagExists) {
+		t.Errorf("CreateTag of an existing name returned wrong error: got %v want %v", err, ErrTagExists)
+	}
+
+	for _, assignment := range []struct {
+		customer int
+		name     string
+	}{
+		{ids[0], "ORGANIC"}, {ids[0], "Dairy"}, {ids[1], "dairy"}, {ids[1], "VIP"}, {ids[2], "Eifel region"}, {ids[0], "organic"},
+	} {
+		if _, err := store.TagCustomer(ctx, assignment.customer, assignment.name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.TagCustomer(ctx, 999, "organic"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("TagCustomer of a missing customer returned wrong error: got %v want %v", err, ErrNotFound)
+	}
+	if customer, err := store.Get(ctx, ids[0]); err != nil || customer.Version != 1 {
+		t.Errorf("TagCustomer changed the customer's version: got %+v, %v", customer, err)
+	}
+
+	tags, err := store.ListTags(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, tag := range tags {
+		got = append(got, fmt.Sprintf("%s:%d", tag.Name, tag.CustomerCount))
+	}
+	if want := []string{"Dairy:2", "Eifel region:1", "organic:1", "VIP:1"}; !slices.Equal(got, want) {
+		t.Errorf("ListTags returned wrong tags: got %v want %v", got, want)
+	}
+	dairy, vip, eifel := tags[0], tags[3], tags[1]
+	if customerTags, err := store.ListCustomerTags(ctx, ids[0]); err != nil || len(customerTags) != 2 ||
+		customerTags[0].ID != dairy.ID || customerTags[1].ID != organic.ID {
+		t.Errorf("ListCustomerTags returned wrong tags: got %+v, %v", customerTags, err)
+	}
+
+	for _, test := range []struct {
+		name   string
+		filter CustomerFilter
+		want   []int
+	}{
+		{"any tag", CustomerFilter{Tags: []string{"organic", "vip"}}, []int{ids[0], ids[1]}},
+		{"all tags", CustomerFilter{Tags: []string{"Organic", "dairy"}, AllTags: true}, []int{ids[0]}},
+		{"all tags, repeated", CustomerFilter{Tags: []string{"dairy", "DAIRY"}, AllTags: true}, []int{ids[0], ids[1]}},
+		{"all tags, one unknown", CustomerFilter{Tags: []string{"dairy", "beef"}, AllTags: true}, nil},
+		{"unknown tag", CustomerFilter{Tags: []string{"beef"}}, nil},
+		{"with customer field", CustomerFilter{Tags: []string{"dairy"}, Name: "meier"}, []int{ids[1]}},
+	} {
+		page, err := store.List(ctx, ListOptions{Filter: test.filter})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int
+		for _, customer := range page.Customers {
+			got = append(got, *customer.ID)
+		}
+		if !slices.Equal(got, test.want) || page.Total != len(test.want) {
+			t.Errorf("List filtered by %s returned wrong customers: got %v (total %d) want %v", test.name, got, page.Total, test.want)
+		}
+	}
+
+	if _, err := store.RenameTag(ctx, vip.ID, "dairy"); !errors.Is(err, ErrTagExists) {
+		t.Errorf("RenameTag to an existing name returned wrong error: got %v want %v", err, ErrTagExists)
+	}
+	if vip, err = store.RenameTag(ctx, vip.ID, "Premium"); err != nil || vip.Name != "Premium" || vip.CustomerCount != 1 {
+		t.Errorf("RenameTag returned wrong tag: got %+v, %v", vip, err)
+	}
+	if _, err := store.RenameTag(ctx, 999, "Premium"); !errors.Is(err, ErrTagNotFound) {
+		t.Errorf("RenameTag of a missing tag returned wrong error: got %v want %v", err, ErrTagNotFound)
+	}
+
+	if _, err := store.MergeTags(ctx, dairy.ID, dairy.ID); !errors.Is(err, ErrTagSelfMerge) {
+		t.Errorf("MergeTags with itself returned wrong error: got %v want %v", err, ErrTagSelfMerge)
+	}
+	merged, err := store.MergeTags(ctx, dairy.ID, organic.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.Name != "Dairy" || merged.CustomerCount != 2 {
+		t.Errorf("MergeTags returned wrong tag: got %+v", merged)
+	}
+	if _, err := store.GetTag(ctx, organic.ID); !errors.Is(err, ErrTagNotFound) {
+		t.Errorf("MergeTags kept the source tag: got %v want %v", err, ErrTagNotFound)
+	}
+
+	if err := store.UntagCustomer(ctx, ids[2], eifel.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UntagCustomer(ctx, ids[2], eifel.ID); !errors.Is(err, ErrCustomerTagNotFound) {
+		t.Errorf("UntagCustomer of a removed tag returned wrong error: got %v want %v", err, ErrCustomerTagNotFound)
+	}
+	if err := store.UntagCustomer(ctx, ids[2], organic.ID); !errors.Is(err, ErrTagNotFound) {
+		t.Errorf("UntagCustomer of a missing tag returned wrong error: got %v want %v", err, ErrTagNotFound)
+	}
+	if eifel, err = store.GetTag(ctx, eifel.ID); err != nil || eifel.CustomerCount != 0 {
+		t.Errorf("UntagCustomer did not keep the tag: got %+v, %v", eifel, err)
+	}
+	if err := store.DeleteTag(ctx, eifel.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteTag(ctx, eifel.ID); !errors.Is(err, ErrTagNotFound) {
+		t.Errorf("DeleteTag of a deleted tag returned wrong error: got %v want %v", err, ErrTagNotFound)
+	}
+
+	if _, err := store.Merge(ctx, ids[0], AnyVersion, ids[1], AnyVersion, func(target, source api.Customer) (api.Customer, error) {
+		return target, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if customerTags, err := store.ListCustomerTags(ctx, ids[0]); err != nil || len(customerTags) != 2 ||
+		customerTags[0].ID != dairy.ID || customerTags[1].ID != vip.ID || customerTags[0].CustomerCount != 1 {
+		t.Errorf("Merge did not move the tags: got %+v, %v", customerTags, err)
+	}
+
+	if err := store.Delete(ctx, ids[0], AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if dairy, err = store.GetTag(ctx, dairy.ID); err != nil || dairy.CustomerCount != 0 {
+		t.Errorf("GetTag counted a customer in the trash: got %+v, %v", dairy, err)
+	}
+	if _, err := store.Purge(ctx, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	id, err := store.Create(ctx, api.Customer{Name: "Bauer Klaus"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if customerTags, err := store.ListCustomerTags(ctx, id); err != nil || len(customerTags) != 0 {
+		t.Errorf("Purge did not remove the tags of the customer: got %+v, %v", customerTags, err)
+	}
+}
+
+func TestSQLiteTags(t *testing.T) {
+	testTags(t, openSQLiteTestStore(t))
+}
+
+func TestMemoryTags(t *testing.T) {
+	testTags(t, NewMemoryStore())
+}
+
+// testTagNameConflicts checks that a name taken after the lookup of another
+// transaction is reported as ErrTagExists rather than a database error.
+func testTagNameConflicts(t *testing.T, store *SQLStore) {
+	ctx := context.Background()
+	c := store.conn()
+	if _, err := insertTag(ctx, c, "Bio"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := insertTag(ctx, c, " bio "); !errors.Is(err, ErrTagExists) {
+		t.Errorf("insertTag of a taken name returned wrong error: got %v want %v", err, ErrTagExists)
+	}
+	id, err := insertTag(ctx, c, "Demeter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.exec(ctx, "UPDATE tag SET name_key = ? WHERE id = ?", "bio", id); !isUniqueViolation(err) {
+		t.Errorf("renaming a tag to a taken name was not a unique violation: got %v", err)
+	}
+	if _, err := c.exec(ctx, "UPDATE tag SET name_key = ? WHERE id = ?", "demeter-bio", id); isUniqueViolation(err) {
+		t.Errorf("renaming a tag to a free name was a unique violation: got %v", err)
+	}
+}
+
+func TestSQLiteTagNameConflicts(t *testing.T) {
+	testTagNameConflicts(t, openSQLiteTestStore(t))
+}
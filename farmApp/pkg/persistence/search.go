@@ -0,0 +1,330 @@
+package persistence
+
+import (
+	"context"
+	"farmApp/pkg/api"
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// SearchRepository finds customers by free text.
+type SearchRepository interface {
+	// Search returns up to limit customers, best match first, that match
+	// every word of the query in their name, role, email or phone. A word
+	// matches the beginning of a word regardless of case and diacritics, and
+	// German umlauts match their transliteration: "Müller" finds "Mueller"
+	// and the other way round. Words with umlauts are indexed in both
+	// spellings and queries are transliterated, so that no spelling is
+	// guessed back into umlauts. Deleted customers are never found.
+	Search(ctx context.Context, query string, limit int) ([]api.SearchResult, error)
+}
+
+// searchField is a customer field covered by the search, with its weight
+// when ranking matches.
+type searchField struct {
+	name   string
+	weight float64
+	value  func(c api.Customer) string
+}
+
+// searchFields lists the searched fields in the column order of the
+// customer_fts table.
+var searchFields = []searchField{
+	{"name", 10, func(c api.Customer) string { return c.Name }},
+	{"role", 2, func(c api.Customer) string { return c.Role }},
+	{"email", 4, func(c api.Customer) string { return c.Email }},
+	{"phone", 1, func(c api.Customer) string { return c.Phone }},
+}
+
+// foldFrom and foldTo are the diacritics removed by fold; the search_fold
+// function of the PostgreSQL schema uses the same lists.
+const (
+	foldFrom = "àáâãäåçèéêëìíîïñòóôõöùúûüýÿ"
+	foldTo   = "aaaaaaceeeeiiiinooooouuuuyy"
+)
+
+var (
+	foldReplacer = func() *strings.Replacer {
+		from, to := []rune(foldFrom), []rune(foldTo)
+		pairs := make([]string, 0, 2*len(from))
+		for i := range from {
+			pairs = append(pairs, string(from[i]), string(to[i]))
+		}
+		return strings.NewReplacer(pairs...)
+	}()
+	// transliterate spells umlauts the way they are written without them.
+	// The customer_search view of the SQLite schema and the search_words
+	// function of the PostgreSQL schema use the same replacements.
+	transliterate = strings.NewReplacer("ä", "ae", "ö", "oe", "ü", "ue", "ß", "ss")
+)
+
+// fold lowercases a word and strips its diacritics, as the index does.
+func fold(word string) string {
+	return foldReplacer.Replace(strings.ToLower(word))
+}
+
+// wordSpans returns the byte offsets of the words in s: runs of letters and
+// digits.
+func wordSpans(s string) [][2]int {
+	var spans [][2]int
+	start := -1
+	for i, r := range s {
+		isWordRune := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case isWordRune && start < 0:
+			start = i
+		case !isWordRune && start >= 0:
+			spans = append(spans, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, [2]int{start, len(s)})
+	}
+	return spans
+}
+
+// searchTerm is one word of a query, folded and transliterated, that
+// matches an indexed word starting with it.
+type searchTerm string
+
+// parseSearchQuery splits a query into terms. It returns no terms for a
+// query without any letters or digits.
+func parseSearchQuery(query string) []searchTerm {
+	var terms []searchTerm
+	for _, span := range wordSpans(query) {
+		terms = append(terms, searchTerm(transliterateFold(query[span[0]:span[1]])))
+	}
+	return terms
+}
+
+// transliterateFold folds a word after spelling its umlauts out.
+func transliterateFold(word string) string {
+	return fold(transliterate.Replace(strings.ToLower(word)))
+}
+
+// indexedSpellings returns the folded spellings a word is indexed under:
+// itself and, if it has umlauts, its transliteration.
+func indexedSpellings(word string) []string {
+	folded, transliterated := fold(word), transliterateFold(word)
+	if transliterated == folded {
+		return []string{folded}
+	}
+	return []string{folded, transliterated}
+}
+
+// matches reports whether the term matches an indexed word.
+func (t searchTerm) matches(word string) bool {
+	for _, spelling := range indexedSpellings(word) {
+		if strings.HasPrefix(spelling, string(t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// fts5Query builds an FTS5 MATCH expression for the terms.
+func fts5Query(terms []searchTerm) string {
+	parts := make([]string, len(terms))
+	for i, term := range terms {
+		parts[i] = `"` + string(term) + `"*`
+	}
+	return strings.Join(parts, " AND ")
+}
+
+// tsQuery builds a PostgreSQL tsquery for the terms.
+func tsQuery(terms []searchTerm) string {
+	parts := make([]string, len(terms))
+	for i, term := range terms {
+		parts[i] = string(term) + ":*"
+	}
+	return strings.Join(parts, " & ")
+}
+
+// highlight returns the fields of the customer that match any of the terms
+// as HTML, with the matching words wrapped in <mark>.
+func highlight(customer api.Customer, terms []searchTerm) map[string]string {
+	highlights := map[string]string{}
+	for _, field := range searchFields {
+		value := field.value(customer)
+		var b strings.Builder
+		last, marked := 0, false
+		for _, span := range wordSpans(value) {
+			word := value[span[0]:span[1]]
+			if !matchesAny(terms, word) {
+				continue
+			}
+			b.WriteString(html.EscapeString(value[last:span[0]]))
+			b.WriteString("<mark>" + html.EscapeString(word) + "</mark>")
+			last, marked = span[1], true
+		}
+		if marked {
+			b.WriteString(html.EscapeString(value[last:]))
+			highlights[field.name] = b.String()
+		}
+	}
+	return highlights
+}
+
+func matchesAny(terms []searchTerm, word string) bool {
+	for _, term := range terms {
+		if term.matches(word) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchCustomers ranks customers in Go, for stores without a search index.
+// A customer scores the weight of every field word matched by a term.
+func searchCustomers(customers []api.Customer, terms []searchTerm, limit int) []api.SearchResult {
+	results := []api.SearchResult{}
+	for _, customer := range customers {
+		score := 0.0
+		matchedAll := true
+		for _, term := range terms {
+			matched := false
+			for _, field := range searchFields {
+				value := field.value(customer)
+				for _, span := range wordSpans(value) {
+					if term.matches(value[span[0]:span[1]]) {
+						score += field.weight
+						matched = true
+					}
+				}
+			}
+			matchedAll = matchedAll && matched
+		}
+		if matchedAll {
+			results = append(results, api.SearchResult{Customer: customer, Score: score})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return *results[i].Customer.ID < *results[j].Customer.ID
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	for i := range results {
+		results[i].Highlights = highlight(results[i].Customer, terms)
+	}
+	return results
+}
+
+// Search uses the customer_fts table where SQLite was built with FTS5 and
+// the generated search column on PostgreSQL. Without either it falls back
+// to ranking all customers in Go.
+func (s *SQLStore) Search(ctx context.Context, query string, limit int) ([]api.SearchResult, error) {
+	terms := parseSearchQuery(query)
+	if len(terms) == 0 {
+		return []api.SearchResult{}, nil
+	}
+
+	var results []api.SearchResult
+	if s.dialect == postgresDialect {
+		tsq := tsQuery(terms)
+		var err error
+		results, err = s.querySearchResults(ctx, `
+        SELECT `+customerColumns+`, ts_rank(search, to_tsquery('simple', ?)) AS score
+        FROM customer
+        WHERE search @@ to_tsquery('simple', ?) AND deleted_at IS NULL
+        ORDER BY score DESC, id
+        LIMIT ?`, tsq, tsq, limit)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		indexed, err := s.hasSearchIndex(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !indexed {
+			customers, err := s.queryCustomers(ctx, "SELECT "+customerColumns+" FROM customer WHERE deleted_at IS NULL")
+			if err != nil {
+				return nil, err
+			}
+			return searchCustomers(customers, terms, limit), nil
+		}
+		// bm25 is lower for better matches; its weights follow searchFields.
+		results, err = s.querySearchResults(ctx, `
+        SELECT `+qualifiedColumns("c", customerColumns)+`, -bm25(customer_fts, 10.0, 2.0, 4.0, 1.0) AS score
+        FROM customer_fts JOIN customer c ON c.id = customer_fts.rowid
+        WHERE customer_fts MATCH ? AND c.deleted_at IS NULL
+        ORDER BY score DESC, c.id
+        LIMIT ?`, fts5Query(terms), limit)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i := range results {
+		results[i].Highlights = highlight(results[i].Customer, terms)
+	}
+	return results, nil
+}
+
+// hasSearchIndex reports whether the FTS5 migration has been applied.
+func (s *SQLStore) hasSearchIndex(ctx context.Context) (bool, error) {
+	var count int
+	err := s.conn().queryRow(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'customer_fts'").Scan(&count)
+	return count > 0, err
+}
+
+// querySearchResults runs a query selecting the customer columns followed
+// by a score.
+func (s *SQLStore) querySearchResults(ctx context.Context, query string, args ...any) ([]api.SearchResult, error) {
+	rows, err := s.conn().query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []api.SearchResult{}
+	for rows.Next() {
+		var result api.SearchResult
+		result.Customer, err = scanCustomer(trailingColumns{rows, []any{&result.Score}})
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// trailingColumns scans a row into the destinations of another scan
+// function followed by its own, e.g. the customer columns and a score.
+type trailingColumns struct {
+	rowScanner
+	dest []any
+}
+
+func (r trailingColumns) Scan(dest ...any) error {
+	return r.rowScanner.Scan(append(dest, r.dest...)...)
+}
+
+// qualifiedColumns returns a list of columns prefixed with a table alias.
+func qualifiedColumns(alias, columns string) string {
+	return alias + "." + strings.ReplaceAll(columns, ", ", ", "+alias+".")
+}
+
+func (m *MemoryStore) Search(ctx context.Context, query string, limit int) ([]api.SearchResult, error) {
+	terms := parseSearchQuery(query)
+	if len(terms) == 0 {
+		return []api.SearchResult{}, nil
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var customers []api.Customer
+	for _, customer := range m.customers {
+		if customer.DeletedAt == nil {
+			customers = append(customers, cloneCustomer(customer))
+		}
+	}
+	return searchCustomers(customers, terms, limit), nil
+}
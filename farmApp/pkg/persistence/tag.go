@@ -0,0 +1,491 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"farmApp/pkg/api"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrTagNotFound is returned when a tag does not exist.
+	ErrTagNotFound = errors.New("tag not found")
+	// ErrTagExists is returned when a tag is created or renamed with the
+	// name of another tag.
+	ErrTagExists = errors.New("a tag with this name already exists")
+	// ErrCustomerTagNotFound is returned when a customer does not have a tag.
+	ErrCustomerTagNotFound = errors.New("customer does not have the tag")
+	// ErrTagSelfMerge is returned when a tag is merged with itself.
+	ErrTagSelfMerge = errors.New("a tag cannot be merged with itself")
+)
+
+// TagRepository stores tags and assigns them to customers. Tag names are
+// compared ignoring case. Assignments do not change the customer's version
+// or history, and those of customers in the trash are kept until they are
+// purged.
+type TagRepository interface {
+	// ListTags returns all tags ordered by name.
+	ListTags(ctx context.Context) ([]api.Tag, error)
+	GetTag(ctx context.Context, id int) (api.Tag, error)
+	CreateTag(ctx context.Context, name string) (api.Tag, error)
+	RenameTag(ctx context.Context, id int, name string) (api.Tag, error)
+	// DeleteTag removes a tag from all customers and deletes it.
+	DeleteTag(ctx context.Context, id int) error
+	// MergeTags gives the customers of the source tag the target tag and
+	// deletes the source.
+	MergeTags(ctx context.Context, targetID, sourceID int) (api.Tag, error)
+
+	// ListCustomerTags returns the tags of a customer ordered by name, or
+	// ErrNotFound if the customer does not exist or is in the trash.
+	ListCustomerTags(ctx context.Context, customerID int) ([]api.Tag, error)
+	// TagCustomer gives a customer the tag with the given name, creating the
+	// tag if there is none.
+	TagCustomer(ctx context.Context, customerID int, name string) (api.Tag, error)
+	UntagCustomer(ctx context.Context, customerID, tagID int) error
+}
+
+// tagKey is the form of a tag name that is compared.
+func tagKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// tagSelect selects the tags t with their number of customers.
+const tagSelect = "SELECT t.id, t.name, (SELECT COUNT(*) FROM customer_tag ct JOIN customer c ON c.id = ct.customer_id " +
+	"WHERE ct.tag_id = t.id AND c.deleted_at IS NULL) FROM tag t"
+
+func scanTag(row rowScanner) (api.Tag, error) {
+	var tag api.Tag
+	err := row.Scan(&tag.ID, &tag.Name, &tag.CustomerCount)
+	return tag, err
+}
+
+func queryTags(ctx context.Context, c conn, query string, args ...any) ([]api.Tag, error) {
+	rows, err := c.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []api.Tag{}
+	for rows.Next() {
+		tag, err := scanTag(rows)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+func (s *SQLStore) ListTags(ctx context.Context) ([]api.Tag, error) {
+	return queryTags(ctx, s.conn(), tagSelect+" ORDER BY t.name_key, t.id")
+}
+
+func (s *SQLStore) GetTag(ctx context.Context, id int) (api.Tag, error) {
+	return getTag(ctx, s.conn(), id)
+}
+
+func getTag(ctx context.Context, c conn, id int) (api.Tag, error) {
+	tag, err := scanTag(c.queryRow(ctx, tagSelect+" WHERE t.id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return tag, ErrTagNotFound
+	}
+	return tag, err
+}
+
+// findTag returns the ID of the tag with the name, or 0 if there is none.
+func findTag(ctx context.Context, c conn, name string) (int, error) {
+	var id int
+	err := c.queryRow(ctx, "SELECT id FROM tag WHERE name_key = ?", tagKey(name)).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return id, err
+}
+
+// insertTag adds a tag and returns its ID, or ErrTagExists if there is
+// already a tag with the name, even one a concurrent transaction just added.
+func insertTag(ctx context.Context, c conn, name string) (int, error) {
+	var id int
+	err := c.queryRow(ctx, "INSERT INTO tag (name, name_key) VALUES (?, ?) ON CONFLICT (name_key) DO NOTHING RETURNING id",
+		strings.TrimSpace(name), tagKey(name)).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrTagExists
+	}
+	return id, err
+}
+
+func (s *SQLStore) CreateTag(ctx context.Context, name string) (api.Tag, error) {
+	var created api.Tag
+	err := s.withTx(ctx, func(c conn) error {
+		id, err := insertTag(ctx, c, name)
+		if err != nil {
+			return err
+		}
+		created, err = getTag(ctx, c, id)
+		return err
+	})
+	return created, err
+}
+
+func (s *SQLStore) RenameTag(ctx context.Context, id int, name string) (api.Tag, error) {
+	var renamed api.Tag
+	err := s.withTx(ctx, func(c conn) error {
+		if _, err := getTag(ctx, c, id); err != nil {
+			return err
+		}
+		existing, err := findTag(ctx, c, name)
+		if err != nil {
+			return err
+		}
+		if existing != 0 && existing != id {
+			return ErrTagExists
+		}
+		_, err = c.exec(ctx, "UPDATE tag SET name = ?, name_key = ? WHERE id = ?", strings.TrimSpace(name), tagKey(name), id)
+		if isUniqueViolation(err) {
+			// Another transaction took the name after findTag looked.
+			return ErrTagExists
+		}
+		if err != nil {
+			return err
+		}
+		renamed, err = getTag(ctx, c, id)
+		return err
+	})
+	return renamed, err
+}
+
+func (s *SQLStore) DeleteTag(ctx context.Context, id int) error {
+	return s.withTx(ctx, func(c conn) error {
+		if _, err := c.exec(ctx, "DELETE FROM customer_tag WHERE tag_id = ?", id); err != nil {
+			return err
+		}
+		result, err := c.exec(ctx, "DELETE FROM tag WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		if err := requireAffected(result); err != nil {
+			return ErrTagNotFound
+		}
+		return nil
+	})
+}
+
+func (s *SQLStore) MergeTags(ctx context.Context, targetID, sourceID int) (api.Tag, error) {
+	if targetID == sourceID {
+		return api.Tag{}, ErrTagSelfMerge
+	}
+	var merged api.Tag
+	err := s.withTx(ctx, func(c conn) error {
+		if _, err := getTag(ctx, c, targetID); err != nil {
+			return err
+		}
+		if _, err := getTag(ctx, c, sourceID); err != nil {
+			return err
+		}
+		_, err := c.exec(ctx, "UPDATE customer_tag SET tag_id = ? WHERE tag_id = ? "+
+			"AND customer_id NOT IN (SELECT customer_id FROM customer_tag WHERE tag_id = ?)", targetID, sourceID, targetID)
+		if err != nil {
+			return err
+		}
+		if _, err := c.exec(ctx, "DELETE FROM customer_tag WHERE tag_id = ?", sourceID); err != nil {
+			return err
+		}
+		if _, err := c.exec(ctx, "DELETE FROM tag WHERE id = ?", sourceID); err != nil {
+			return err
+		}
+		merged, err = getTag(ctx, c, targetID)
+		return err
+	})
+	return merged, err
+}
+
+func (s *SQLStore) ListCustomerTags(ctx context.Context, customerID int) ([]api.Tag, error) {
+	c := s.conn()
+	if _, err := checkVersion(ctx, c, customerID, AnyVersion); err != nil {
+		return nil, err
+	}
+	return queryTags(ctx, c, tagSelect+" WHERE t.id IN (SELECT tag_id FROM customer_tag WHERE customer_id = ?) "+
+		"ORDER BY t.name_key, t.id", customerID)
+}
+
+func (s *SQLStore) TagCustomer(ctx context.Context, customerID int, name string) (api.Tag, error) {
+	var tag api.Tag
+	err := s.withTx(ctx, func(c conn) error {
+		if _, err := checkVersion(ctx, c, customerID, AnyVersion); err != nil {
+			return err
+		}
+		id, err := findTag(ctx, c, name)
+		if err != nil {
+			return err
+		}
+		if id == 0 {
+			id, err = insertTag(ctx, c, name)
+			if errors.Is(err, ErrTagExists) {
+				// Another transaction created the tag after findTag looked.
+				id, err = findTag(ctx, c, name)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		_, err = c.exec(ctx, "INSERT INTO customer_tag (customer_id, tag_id) VALUES (?, ?) "+
+			"ON CONFLICT (customer_id, tag_id) DO NOTHING", customerID, id)
+		if err != nil {
+			return err
+		}
+		tag, err = getTag(ctx, c, id)
+		return err
+	})
+	return tag, err
+}
+
+func (s *SQLStore) UntagCustomer(ctx context.Context, customerID, tagID int) error {
+	return s.withTx(ctx, func(c conn) error {
+		if _, err := getTag(ctx, c, tagID); err != nil {
+			return err
+		}
+		if _, err := checkVersion(ctx, c, customerID, AnyVersion); err != nil {
+			return err
+		}
+		result, err := c.exec(ctx, "DELETE FROM customer_tag WHERE customer_id = ? AND tag_id = ?", customerID, tagID)
+		if err != nil {
+			return err
+		}
+		if err := requireAffected(result); err != nil {
+			return ErrCustomerTagNotFound
+		}
+		return nil
+	})
+}
+
+// moveCustomerTags gives the tags of a merged customer to the customer it
+// was merged into.
+func moveCustomerTags(ctx context.Context, c conn, fromID, toID int) error {
+	_, err := c.exec(ctx, "UPDATE customer_tag SET customer_id = ? WHERE customer_id = ? "+
+		"AND tag_id NOT IN (SELECT tag_id FROM customer_tag WHERE customer_id = ?)", toID, fromID, toID)
+	if err != nil {
+		return err
+	}
+	_, err = c.exec(ctx, "DELETE FROM customer_tag WHERE customer_id = ?", fromID)
+	return err
+}
+
+// customerTag identifies an assignment in the MemoryStore.
+type customerTag struct {
+	customerID, tagID int
+}
+
+// tag returns the tag with its number of customers. The caller must hold
+// m.mu.
+func (m *MemoryStore) tag(id int) (api.Tag, error) {
+	tag, ok := m.tags[id]
+	if !ok {
+		return api.Tag{}, ErrTagNotFound
+	}
+	for assignment := range m.customerTags {
+		if assignment.tagID == id && m.customers[assignment.customerID].DeletedAt == nil {
+			tag.CustomerCount++
+		}
+	}
+	return tag, nil
+}
+
+// findTag returns the ID of the tag with the name, or 0 if there is none.
+// The caller must hold m.mu.
+func (m *MemoryStore) findTag(name string) int {
+	for id, tag := range m.tags {
+		if tagKey(tag.Name) == tagKey(name) {
+			return id
+		}
+	}
+	return 0
+}
+
+// insertTag stores a new tag. The caller must hold m.mu.
+func (m *MemoryStore) insertTag(name string) int {
+	m.lastTagID++
+	m.tags[m.lastTagID] = api.Tag{ID: m.lastTagID, Name: strings.TrimSpace(name)}
+	return m.lastTagID
+}
+
+// sortTags orders tags like the SQL store.
+func sortTags(tags []api.Tag) {
+	sort.Slice(tags, func(i, j int) bool {
+		if a, b := tagKey(tags[i].Name), tagKey(tags[j].Name); a != b {
+			return a < b
+		}
+		return tags[i].ID < tags[j].ID
+	})
+}
+
+func (m *MemoryStore) ListTags(ctx context.Context) ([]api.Tag, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tags := []api.Tag{}
+	for id := range m.tags {
+		tag, _ := m.tag(id)
+		tags = append(tags, tag)
+	}
+	sortTags(tags)
+	return tags, nil
+}
+
+func (m *MemoryStore) GetTag(ctx context.Context, id int) (api.Tag, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.tag(id)
+}
+
+func (m *MemoryStore) CreateTag(ctx context.Context, name string) (api.Tag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.findTag(name) != 0 {
+		return api.Tag{}, ErrTagExists
+	}
+	return m.tag(m.insertTag(name))
+}
+
+func (m *MemoryStore) RenameTag(ctx context.Context, id int, name string) (api.Tag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tag, ok := m.tags[id]
+	if !ok {
+		return api.Tag{}, ErrTagNotFound
+	}
+	if existing := m.findTag(name); existing != 0 && existing != id {
+		return api.Tag{}, ErrTagExists
+	}
+	tag.Name = strings.TrimSpace(name)
+	m.tags[id] = tag
+	return m.tag(id)
+}
+
+func (m *MemoryStore) DeleteTag(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.tags[id]; !ok {
+		return ErrTagNotFound
+	}
+	for assignment := range m.customerTags {
+		if assignment.tagID == id {
+			delete(m.customerTags, assignment)
+		}
+	}
+	delete(m.tags, id)
+	return nil
+}
+
+func (m *MemoryStore) MergeTags(ctx context.Context, targetID, sourceID int) (api.Tag, error) {
+	if targetID == sourceID {
+		return api.Tag{}, ErrTagSelfMerge
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.tags[targetID]; !ok {
+		return api.Tag{}, ErrTagNotFound
+	}
+	if _, ok := m.tags[sourceID]; !ok {
+		return api.Tag{}, ErrTagNotFound
+	}
+	for assignment := range m.customerTags {
+		if assignment.tagID == sourceID {
+			delete(m.customerTags, assignment)
+			m.customerTags[customerTag{assignment.customerID, targetID}] = struct{}{}
+		}
+	}
+	delete(m.tags, sourceID)
+	return m.tag(targetID)
+}
+
+func (m *MemoryStore) ListCustomerTags(ctx context.Context, customerID int) ([]api.Tag, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, err := m.checkVersion(customerID, AnyVersion); err != nil {
+		return nil, err
+	}
+	tags := []api.Tag{}
+	for assignment := range m.customerTags {
+		if assignment.customerID == customerID {
+			tag, _ := m.tag(assignment.tagID)
+			tags = append(tags, tag)
+		}
+	}
+	sortTags(tags)
+	return tags, nil
+}
+
+func (m *MemoryStore) TagCustomer(ctx context.Context, customerID int, name string) (api.Tag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.checkVersion(customerID, AnyVersion); err != nil {
+		return api.Tag{}, err
+	}
+	id := m.findTag(name)
+	if id == 0 {
+		id = m.insertTag(name)
+	}
+	m.customerTags[customerTag{customerID, id}] = struct{}{}
+	return m.tag(id)
+}
+
+func (m *MemoryStore) UntagCustomer(ctx context.Context, customerID, tagID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.tags[tagID]; !ok {
+		return ErrTagNotFound
+	}
+	if _, err := m.checkVersion(customerID, AnyVersion); err != nil {
+		return err
+	}
+	assignment := customerTag{customerID, tagID}
+	if _, ok := m.customerTags[assignment]; !ok {
+		return ErrCustomerTagNotFound
+	}
+	delete(m.customerTags, assignment)
+	return nil
+}
+
+// tagKeysOf returns the compared names of a customer's tags. The caller must
+// hold m.mu.
+func (m *MemoryStore) tagKeysOf(customerID int) []string {
+	var keys []string
+	for assignment := range m.customerTags {
+		if assignment.customerID == customerID {
+			keys = append(keys, tagKey(m.tags[assignment.tagID].Name))
+		}
+	}
+	return keys
+}
+
+// moveCustomerTags mirrors the SQL store's moveCustomerTags. The caller must
+// hold m.mu.
+func (m *MemoryStore) moveCustomerTags(fromID, toID int) {
+	for assignment := range m.customerTags {
+		if assignment.customerID == fromID {
+			delete(m.customerTags, assignment)
+			m.customerTags[customerTag{toID, assignment.tagID}] = struct{}{}
+		}
+	}
+}
+
+// deleteCustomerTags forgets the tags of a purged customer. The caller must
+// hold m.mu.
+func (m *MemoryStore) deleteCustomerTags(customerID int) {
+	for assignment := range m.customerTags {
+		if assignment.customerID == customerID {
+			delete(m.customerTags, assignment)
+		}
+	}
+}